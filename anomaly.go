@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// anomalyWindow is how many past samples the z-score is computed against,
+	// zero, the default, disables it. anomalyThreshold is the |z| at which a
+	// sample counts as anomalous.
+	anomalyWindow    = 0
+	anomalyThreshold = 3.0
+
+	udpBufferQueuedZScore    *prometheus.GaugeVec
+	udpBufferQueuedAnomalous *prometheus.GaugeVec
+)
+
+func registerAnomalyMetrics(labels []string) {
+	udpBufferQueuedZScore = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: metricName(legacyExporterPrefix, "buffer_queued_zscore"),
+			Help: "How many standard deviations the latest queued sample is from the rolling mean of the previous samples.",
+		},
+		labels,
+	)
+	udpBufferQueuedAnomalous = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: metricName(legacyExporterPrefix, "buffer_queued_anomalous"),
+			Help: "1 when the absolute z-score of the latest queued sample is over the anomaly threshold, 0 otherwise.",
+		},
+		labels,
+	)
+	prometheus.MustRegister(udpBufferQueuedZScore)
+	prometheus.MustRegister(udpBufferQueuedAnomalous)
+}
+
+// rollingWindow keeps the last len(samples) values in a ring buffer.
+type rollingWindow struct {
+	samples []float64
+	next    int
+	full    bool
+}
+
+func newRollingWindow(size int) *rollingWindow {
+	return &rollingWindow{samples: make([]float64, size)}
+}
+
+func (w *rollingWindow) add(v float64) {
+	w.samples[w.next] = v
+	w.next = (w.next + 1) % len(w.samples)
+	if w.next == 0 {
+		w.full = true
+	}
+}
+
+// zscore scores v against the samples in the window. It reports false until
+// the window has filled, since a handful of samples says little about what
+// normal looks like.
+func (w *rollingWindow) zscore(v float64) (float64, bool) {
+	if !w.full {
+		return 0, false
+	}
+	mean := 0.0
+	for _, s := range w.samples {
+		mean += s
+	}
+	mean /= float64(len(w.samples))
+
+	variance := 0.0
+	for _, s := range w.samples {
+		variance += (s - mean) * (s - mean)
+	}
+	// Queues are measured in bytes, so floor the deviation at one byte to
+	// keep an idle, perfectly flat queue from turning any blip into +Inf.
+	stddev := math.Max(math.Sqrt(variance/float64(len(w.samples))), 1)
+	return (v - mean) / stddev, true
+}
+
+// observeQueued scores queued against the target's history for the protocol
+// in labels and then adds it to that history.
+func (t *target) observeQueued(labels []string, protocol string, queued float64) {
+	if anomalyWindow <= 0 {
+		return
+	}
+	if t.queueHistory == nil {
+		t.queueHistory = make(map[string]*rollingWindow)
+	}
+	w, ok := t.queueHistory[protocol]
+	if !ok {
+		w = newRollingWindow(anomalyWindow)
+		t.queueHistory[protocol] = w
+	}
+
+	if z, ok := w.zscore(queued); ok {
+		udpBufferQueuedZScore.WithLabelValues(labels...).Set(z)
+		anomalous := 0.0
+		if math.Abs(z) >= anomalyThreshold {
+			anomalous = 1
+		}
+		udpBufferQueuedAnomalous.WithLabelValues(labels...).Set(anomalous)
+	}
+	w.add(queued)
+}
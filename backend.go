@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// backend is set by --backend and decides how the watch loop reads a local
+// target's sockets: by parsing the procfs tables, or through sock_diag.
+var backend = "procfs"
+
+// diagTables are the tables the netlink backend can read, by the family and
+// IP protocol inet_diag knows them as. Others are still read from procfs.
+var diagTables = map[string]struct{ family, protocol uint8 }{
+	"udp":      {afInet, 17},
+	"udp6":     {afInet6, 17},
+	"udplite":  {afInet, 136},
+	"udplite6": {afInet6, 136},
+}
+
+// netNamespaces returns the network namespace of each of t's processes, or of
+// a --netns target, each only once. It's nil for targets that aren't local.
+func (t *target) netNamespaces() []string {
+	if t.nsPath != "" {
+		return []string{t.nsPath}
+	}
+	var paths []string
+	seen := make(map[string]bool)
+	for _, pid := range t.livePIDs() {
+		path := procPath(pid, "ns", "net")
+		ns, err := os.Readlink(path)
+		if err != nil || seen[ns] {
+			continue
+		}
+		seen[ns] = true
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// parseDiagSockets is parseProcfsNetFile for the netlink backend, summing the
+// same columns from sock_diag's reply, along with the sockets' receive buffer
+// sizes, which the procfs tables don't have. ok is false when protocol or t
+// can't be read this way, and the procfs table should be used instead.
+func parseDiagSockets(t *target, protocol string, seen, owned map[string]bool) (queued, txQueued, dropped, rcvbuf int, ok bool, err error) {
+	table, known := diagTables[protocol]
+	if !known || (t.nsPath == "" && len(t.currentPIDs()) == 0) {
+		return 0, 0, 0, 0, false, nil
+	}
+	namespaces := t.netNamespaces()
+	if len(namespaces) == 0 {
+		return 0, 0, 0, 0, true, &os.PathError{Op: "open", Path: procPath(t.currentPIDs()[0], "ns", "net"), Err: os.ErrNotExist}
+	}
+
+	for _, nsPath := range namespaces {
+		sockets, err := listDiagSockets(nsPath, table.family, table.protocol, true)
+		// Kernels without UDP-Lite, or IPv6, don't know the table.
+		if errno, isErrno := underlyingErrno(err); isErrno && errno == syscall.ENOENT {
+			err = &os.PathError{Op: "sock_diag", Path: protocol, Err: os.ErrNotExist}
+		}
+		if err != nil {
+			return 0, 0, 0, 0, true, err
+		}
+		for _, s := range sockets {
+			inode := strconv.FormatUint(uint64(s.inode), 10)
+			if owned != nil && !owned[inode] {
+				continue
+			}
+			if inode != "0" {
+				if seen[inode] {
+					continue
+				}
+				seen[inode] = true
+			}
+			queued += int(s.rxQueue)
+			txQueued += int(s.txQueue)
+			dropped += int(s.skmem[skmemDrops])
+			rcvbuf += int(s.skmem[skmemRcvbuf])
+		}
+	}
+	return queued, txQueued, dropped, rcvbuf, true, nil
+}
+
+func underlyingErrno(err error) (syscall.Errno, bool) {
+	if e, ok := err.(*os.SyscallError); ok {
+		err = e.Err
+	}
+	errno, ok := err.(syscall.Errno)
+	return errno, ok
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// cardinalityReport is the response of /api/v1/cardinality.
+type cardinalityReport struct {
+	// Series is the number of series each metric has right now, and Total
+	// their sum.
+	Series map[string]int `json:"series"`
+	Total  int            `json:"total"`
+	// Estimates is how many series the per-socket collectors would export
+	// for the sockets open right now, whether or not they are enabled.
+	Estimates map[string]int `json:"estimates,omitempty"`
+}
+
+// serveCardinality reports the exporter's current series counts, along with
+// estimates for the collectors whose cardinality depends on the target's
+// sockets, so their impact can be judged before turning them on.
+func serveCardinality(w http.ResponseWriter, r *http.Request) {
+	// Gathering for /metrics would reset the peaks and sample windows.
+	families, err := snapshotGatherer().Gather()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	report := cardinalityReport{Series: make(map[string]int, len(families))}
+	for _, f := range families {
+		report.Series[f.GetName()] = len(f.GetMetric())
+		report.Total += len(f.GetMetric())
+	}
+
+	// sock_diag only sees the local target's network namespace.
+	if currentTargetPID() != "" {
+		estimates, err := socketCardinality()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		report.Estimates = estimates
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// socketCardinality counts the label sets the skmem collector (its gauges and
+// drops counter, in both its summed and --collector.skmem.inode-label modes),
+// the peers collector and the sockets collector would export, capped by
+// --series.max-per-metric.
+func socketCardinality() (map[string]int, error) {
+	nsPath := procPath(currentTargetPID(), "ns", "net")
+	addrs := make(map[string]bool)
+	inodes := make(map[string]bool)
+	peers := make(map[string]bool)
+	for _, family := range []uint8{afInet, afInet6} {
+		sockets, err := listUDPSockets(nsPath, family, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range sockets {
+			local := diagProtocol(s.family) + " " + s.localAddr.String() + " " + strconv.Itoa(int(s.localPort))
+			addrs[local] = true
+			inodes[local+" "+strconv.FormatUint(uint64(s.inode), 10)] = true
+			if s.remotePort != 0 || !s.remoteAddr.IsUnspecified() {
+				peers[diagProtocol(s.family)+" "+s.remoteAddr.String()+" "+strconv.Itoa(int(s.remotePort))] = true
+			}
+		}
+	}
+
+	capped := func(n int) int {
+		if n > seriesMaxPerMetric {
+			return seriesMaxPerMetric
+		}
+		return n
+	}
+	return map[string]int{
+		"skmem":             (len(skmemMetrics) + 1) * capped(len(addrs)),
+		"skmem_inode_label": (len(skmemMetrics) + 1) * capped(len(inodes)),
+		"peers":             describedMetrics(peerQueued, peerDropped) * capped(len(peers)),
+		"sockets":           describedMetrics(socketQueued, socketDropped, socketUtilization) * capped(len(inodes)),
+	}, nil
+}
+
+// describedMetrics counts the metrics collectors describe, each of which
+// gets a series per label set.
+func describedMetrics(collectors ...prometheus.Collector) int {
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		for _, c := range collectors {
+			c.Describe(ch)
+		}
+		close(ch)
+	}()
+	n := 0
+	for range ch {
+		n++
+	}
+	return n
+}
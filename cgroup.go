@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// containerIDPattern matches the 64 hex digit container ID at the end of a
+// cgroup path, whether the runtime uses the systemd driver (crio-<id>.scope,
+// docker-<id>.scope) or the cgroupfs one (/kubepods/.../<id>).
+var containerIDPattern = regexp.MustCompile(`(?:^|[/-])([0-9a-f]{64})(?:\.scope)?$`)
+
+// cgroupContainerIDs maps the ID of every container with a process on this
+// host to one PID inside it, read from /proc/<pid>/cgroup.
+func cgroupContainerIDs() map[string]string {
+	ids := make(map[string]string)
+	entries, err := ioutil.ReadDir(procfsPath)
+	if err != nil {
+		return ids
+	}
+	for _, e := range entries {
+		if _, err := strconv.Atoi(e.Name()); err != nil {
+			continue
+		}
+		f, err := os.Open(procPath(e.Name(), "cgroup"))
+		if err != nil {
+			continue
+		}
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			if m := containerIDPattern.FindStringSubmatch(s.Text()); m != nil {
+				if _, ok := ids[m[1]]; !ok {
+					ids[m[1]] = e.Name()
+				}
+			}
+		}
+		f.Close()
+	}
+	return ids
+}
+
+// cgroupRoot is where relative --cgroup paths are looked for.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupPIDs returns the PIDs of the processes in the cgroup at dir and its
+// descendants, from their cgroup.procs, which cgroup v1 and v2 both have. On
+// v2 only leaf cgroups have processes, so a slice's are all in its children.
+func cgroupPIDs(dir string) ([]string, error) {
+	var pids []string
+	seen := make(map[string]bool)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path != dir && os.IsNotExist(err) {
+				// A child cgroup removed mid-walk.
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		b, err := ioutil.ReadFile(filepath.Join(path, "cgroup.procs"))
+		if err != nil {
+			if path != dir && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		for _, line := range bytes.Fields(b) {
+			if pid := string(line); !seen[pid] {
+				seen[pid] = true
+				pids = append(pids, pid)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pids, nil
+}
+
+// newCgroupTarget returns a target reading the procfs tables of every process
+// in the cgroup at path as one, as newAggregateTarget does, re-reading its
+// members every poll. A cgroup that's gone, or has no processes left, makes
+// the target absent.
+func newCgroupTarget(path string) (*target, error) {
+	dir := path
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(cgroupRoot, dir)
+	}
+	pids, err := cgroupPIDs(dir)
+	if err != nil {
+		return nil, err
+	}
+	t := newAggregateTarget(path, pids, nil)
+	t.sdLabels["cgroup"] = path
+	t.members = func() []string {
+		// One that just went away has no processes.
+		pids, _ := cgroupPIDs(dir)
+		return pids
+	}
+	return t, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// loadConfigFile applies the YAML file at filename, in the format printed by
+// --dump-config: every key is a flag name, plus process and port for the
+// positional arguments. Lists may be given for flags that can be repeated,
+// and are joined with commas for the rest (e.g. ssh.hosts). help maps metric
+// names to the help text to export them with instead of their own, and
+// relabel lists Prometheus metric_relabel_configs rules to apply to every
+// series.
+// Flags set on the command line take precedence over the file. It returns the
+// positional arguments the file describes.
+func loadConfigFile(filename string) ([]string, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	config, err := parseConfigFile(filename, b)
+	if err != nil {
+		return nil, err
+	}
+
+	onCommandLine := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		onCommandLine[f.Name] = true
+	})
+
+	// Apply keys in a stable order so errors are reproducible.
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var process, port string
+	for _, key := range keys {
+		value := config[key]
+		switch key {
+		case "process":
+			process = fmt.Sprint(value)
+			continue
+		case "port":
+			port = fmt.Sprint(value)
+			continue
+		case "help":
+			help, err := configHelp(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", filename, err)
+			}
+			setHelpOverrides(help)
+			continue
+		case "relabel":
+			rules, err := configRelabel(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", filename, err)
+			}
+			setRelabelRules(rules)
+			continue
+		case "config.file", "dump-config", "version":
+			continue
+		}
+
+		f := flag.Lookup(key)
+		if onCommandLine[key] || value == nil {
+			continue
+		}
+
+		values := []string{fmt.Sprint(value)}
+		if list, ok := value.([]interface{}); ok {
+			values = values[:0]
+			for _, v := range list {
+				values = append(values, fmt.Sprint(v))
+			}
+			if _, repeatable := f.Value.(*stringsFlag); !repeatable {
+				values = []string{strings.Join(values, ",")}
+			}
+		}
+		for _, v := range values {
+			if err := f.Value.Set(v); err != nil {
+				return nil, fmt.Errorf("%s: invalid %s: %v", filename, key, err)
+			}
+		}
+	}
+
+	var args []string
+	if process != "" {
+		args = append(args, process)
+	}
+	if port != "" {
+		args = append(args, port)
+	}
+	return args, nil
+}
+
+// parseConfigFile parses b, the contents of the config file filename, and
+// checks every key in it is an option, so that a misspelt one is an error
+// rather than silently ignored.
+func parseConfigFile(filename string, b []byte) (map[string]interface{}, error) {
+	var config map[string]interface{}
+	if err := yaml.UnmarshalStrict(b, &config); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	var unknown []string
+	for key := range config {
+		switch key {
+		case "process", "port", "help", "relabel", "config.file", "dump-config", "version":
+			continue
+		}
+		if flag.Lookup(key) == nil {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("%s: unknown option %q", filename, unknown[0])
+	}
+	return config, nil
+}
+
+// stringsFlag is a flag that can be repeated, collecting every value.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// counterTracker feeds absolute kernel counters into a CounterVec by adding
+// the increase since the last value seen for the same labels. A value going
+// backwards means the kernel reset it (driver reload, qdisc replaced), in
+// which case we just resync.
+type counterTracker struct {
+	vec  *prometheus.CounterVec
+	gc   *seriesGC
+	last map[string]uint64
+}
+
+func newCounterTracker(mode, name string, vec *prometheus.CounterVec) *counterTracker {
+	c := &counterTracker{vec: vec, gc: newCounterGC(mode, name, vec), last: make(map[string]uint64)}
+	c.gc.onDelete = func(key string) { delete(c.last, key) }
+	return c
+}
+
+func (c *counterTracker) set(value uint64, labels ...string) {
+	if !c.gc.admit(labels...) {
+		return
+	}
+	key := strings.Join(labels, "\xff")
+	counter := c.vec.WithLabelValues(labels...)
+	if value >= c.last[key] {
+		counter.Add(float64(value - c.last[key]))
+	}
+	c.last[key] = value
+}
+
+// sweep ends a poll, see seriesGC.sweep.
+func (c *counterTracker) sweep() {
+	c.gc.sweep()
+}
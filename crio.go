@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// crioContainerPID finds the PID of the CRI-O container called name through
+// the inspect API on CRI-O's socket. That API looks containers up by ID only,
+// so every container ID found in the host's cgroups is inspected in turn
+// until one has a matching Kubernetes container name (or full CRI-O name).
+func crioContainerPID(ctx context.Context, socket, name string) (string, error) {
+	client := unixSocketClient(socket)
+	for id := range cgroupContainerIDs() {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		var info struct {
+			Name   string            `json:"name"`
+			Pid    int               `json:"pid"`
+			Labels map[string]string `json:"labels"`
+		}
+		if err := getJSON(ctx, client, "http://crio/containers/"+id, &info); err != nil {
+			// Containers from other runtimes are unknown to CRI-O.
+			continue
+		}
+		if info.Labels["io.kubernetes.container.name"] == name || info.Name == name {
+			return strconv.Itoa(info.Pid), nil
+		}
+	}
+	return "", fmt.Errorf("no CRI-O container named %s", name)
+}
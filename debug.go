@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// debugSampleEvery is how many polls pass between logged samples of each
+// table at debug level.
+const debugSampleEvery = 6
+
+// sampleLine logs a random socket line of the procfs table in b next to the
+// values we parse out of it, so a kernel that shifts the columns around is
+// obvious from the logs alone.
+func sampleLine(name, protocol string, b []byte) {
+	lines := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	if len(lines) < 2 {
+		slog.Debug("Table has no sockets to sample", "target", name, "protocol", protocol)
+		return
+	}
+	line := lines[1+rand.Intn(len(lines)-1)]
+
+	fields := strings.Fields(line)
+	parsed := "unparseable"
+	if len(fields) >= 13 && strings.Contains(fields[4], ":") {
+		_, port, err := parseProcfsAddr(fields[1])
+		queued, qerr := strconv.ParseInt(strings.Split(fields[4], ":")[1], 16, 32)
+		dropped, derr := strconv.Atoi(fields[12])
+		if err == nil && qerr == nil && derr == nil {
+			parsed = fmt.Sprintf("port=%d queued=%d dropped=%d", port, queued, dropped)
+		}
+	}
+	slog.Debug("Sampled a socket", "target", name, "protocol", protocol, "line", line, "parsed", parsed)
+}
+
+// sampledReader returns r unchanged unless t is due a debug sample, in which
+// case it buffers the table, logs a sample and returns the buffered copy.
+func (t *target) sampledReader(protocol string, r io.Reader) io.Reader {
+	if !slog.Default().Enabled(context.Background(), slog.LevelDebug) || t.polls%debugSampleEvery != 0 {
+		return r
+	}
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		slog.Debug("Unable to buffer table for sampling", "protocol", protocol, "err", err)
+	}
+	sampleLine(t.name, protocol, buf.Bytes())
+	return &buf
+}
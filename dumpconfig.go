@@ -0,0 +1,141 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// secretMarkers are substrings of flag names whose values are never printed.
+var secretMarkers = []string{"password", "secret", "token"}
+
+// secretQueryParams are the query parameters, besides those named like a
+// secretMarker, that carry credentials in a URL: InfluxDB 1's u and p, and
+// the API keys and signatures other push endpoints take.
+var secretQueryParams = []string{"u", "p", "key", "apikey", "api_key", "sig", "signature", "auth"}
+
+// dumpConfig writes the effective configuration as YAML: every flag with its
+// resolved value (defaults included) followed by the positional arguments and
+// the config file's help and relabel sections.
+func dumpConfig(w io.Writer, args []string) {
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name == "dump-config" || f.Name == "version" {
+			return
+		}
+		if list, ok := f.Value.(*stringsFlag); ok {
+			quoted := make([]string, len(*list))
+			for i, v := range *list {
+				quoted[i] = strconv.Quote(redact(f.Name, v))
+			}
+			fmt.Fprintf(w, "%s: [%s]\n", f.Name, strings.Join(quoted, ", "))
+			return
+		}
+		fmt.Fprintf(w, "%s: %s\n", f.Name, strconv.Quote(redact(f.Name, f.Value.String())))
+	})
+
+	process, port := "", ""
+	if len(args) > 0 {
+		process = args[0]
+	}
+	if len(args) > 1 {
+		port = args[1]
+	}
+	fmt.Fprintf(w, "process: %s\n", strconv.Quote(process))
+	fmt.Fprintf(w, "port: %s\n", strconv.Quote(port))
+
+	helpOverridesMu.Lock()
+	defer helpOverridesMu.Unlock()
+	if len(helpOverrides) > 0 {
+		names := make([]string, 0, len(helpOverrides))
+		for name := range helpOverrides {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintln(w, "help:")
+		for _, name := range names {
+			fmt.Fprintf(w, "  %s: %s\n", name, strconv.Quote(helpOverrides[name]))
+		}
+	}
+
+	relabelRulesMu.Lock()
+	defer relabelRulesMu.Unlock()
+	if len(relabelRules) > 0 {
+		b, _ := yaml.Marshal(map[string][]relabelRule{"relabel": relabelRules})
+		w.Write(b)
+	}
+}
+
+// redact returns value, the value (or one of the values) of the flag name,
+// with anything secret in it replaced. The values of the *.header flags are
+// "Name: value" pairs, often Authorization, so only their names are kept, and
+// URLs, like --push.gateway-url and --influx.url, lose their userinfo and any
+// credentials in their query.
+func redact(name, value string) string {
+	if value == "" {
+		return value
+	}
+	if strings.HasSuffix(name, ".header") || strings.HasSuffix(name, "-header") {
+		if i := strings.IndexByte(value, ':'); i >= 0 {
+			return value[:i] + ": <redacted>"
+		}
+		return "<redacted>"
+	}
+	if isSecret(name) {
+		return "<redacted>"
+	}
+	if strings.Contains(value, "://") {
+		return redactURL(value)
+	}
+	return value
+}
+
+// redactURL returns the URL value with its userinfo and secret query
+// parameters masked. Values that don't parse are redacted entirely, as they
+// may still hold credentials.
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil {
+		return "<redacted>"
+	}
+	if u.User != nil {
+		u.User = url.User("redacted")
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for param, values := range query {
+			if !isSecretParam(param) {
+				continue
+			}
+			for i := range values {
+				values[i] = "redacted"
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+func isSecretParam(param string) bool {
+	param = strings.ToLower(param)
+	for _, secret := range secretQueryParams {
+		if param == secret {
+			return true
+		}
+	}
+	return isSecret(param)
+}
+
+func isSecret(name string) bool {
+	for _, marker := range secretMarkers {
+		if strings.Contains(name, marker) {
+			return true
+		}
+	}
+	return false
+}
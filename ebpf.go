@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// watchEBPF counts drops as they happen from the udp_fail_queue_rcv_skb and
+// kfree_skb tracepoints, which catch what the procfs drops column misses
+// between polls and say why. Either being unavailable, for want of a new
+// enough kernel or of CAP_BPF and CAP_PERFMON, only disables its metric.
+func watchEBPF(ctx context.Context) {
+	ebpfUDPDrops := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: metricName(legacyExporterPrefix, "ebpf_drops_total"),
+		Help: "Datagrams the kernel couldn't queue on a UDP socket, on the whole host, by the error queueing failed with (ENOMEM or ENOBUFS, depending on the kernel and whether the receive buffer or protocol memory ran out) and the socket's local port.",
+	}, []string{"reason", "port"})
+	ebpfSkbDrops := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: metricName(legacyExporterPrefix, "ebpf_skb_drops_total"),
+		Help: "Packets of any protocol the kernel dropped, on the whole host, by skb_drop_reason.",
+	}, []string{"reason"})
+
+	udp, err := openUDPDropCounters()
+	if err != nil {
+		slog.Warn("Unable to track UDP drops with eBPF", "err", err)
+	} else {
+		defer udp.Close()
+		prometheus.MustRegister(ebpfUDPDrops)
+	}
+	skb, err := openSkbDropCounters()
+	if err != nil {
+		slog.Warn("Unable to track drop reasons with eBPF", "err", err)
+	} else {
+		defer skb.Close()
+		prometheus.MustRegister(ebpfSkbDrops)
+	}
+	if udp == nil && skb == nil {
+		return
+	}
+	udpDrops := newCounterTracker("ebpf", metricName(legacyExporterPrefix, "ebpf_drops_total"), ebpfUDPDrops)
+	skbDrops := newCounterTracker("ebpf", metricName(legacyExporterPrefix, "ebpf_skb_drops_total"), ebpfSkbDrops)
+	reasons := skbDropReasons()
+
+	for {
+		if udp != nil {
+			counts, err := udp.counts()
+			if err != nil {
+				slog.Warn("Unable to read eBPF UDP drop counts", "err", err)
+			}
+			for key, n := range counts {
+				// The key is the error in the low 32 bits and the
+				// local port in the next 16.
+				errno := syscall.Errno(-int32(uint32(key)))
+				udpDrops.set(n, errnoName(errno), strconv.Itoa(int(uint16(key>>32))))
+			}
+		}
+		if skb != nil {
+			counts, err := skb.counts()
+			if err != nil {
+				slog.Warn("Unable to read eBPF drop reason counts", "err", err)
+			}
+			for key, n := range counts {
+				reason, ok := reasons[uint32(key)]
+				if !ok {
+					reason = strconv.FormatUint(uint64(uint32(key)), 10)
+				}
+				skbDrops.set(n, reason)
+			}
+		}
+
+		if !sleepContext(ctx, currentPollInterval()) {
+			return
+		}
+	}
+}
+
+// errnoName returns the symbolic name of the errors UDP queueing fails with.
+func errnoName(errno syscall.Errno) string {
+	switch errno {
+	case syscall.ENOBUFS:
+		return "ENOBUFS"
+	case syscall.ENOMEM:
+		return "ENOMEM"
+	}
+	return strconv.Itoa(int(errno))
+}
+
+var dropReasonPattern = regexp.MustCompile(`\{ (\d+), "(\w+)" \}`)
+
+// skbDropReasons maps the numbers of skb_drop_reason to their names, which
+// the running kernel lists in the kfree_skb tracepoint's print format. It's
+// empty if tracefs isn't mounted, and the reasons are exported as numbers.
+func skbDropReasons() map[uint32]string {
+	reasons := make(map[uint32]string)
+	for _, dir := range []string{"/sys/kernel/tracing", "/sys/kernel/debug/tracing"} {
+		b, err := ioutil.ReadFile(dir + "/events/skb/kfree_skb/format")
+		if err != nil {
+			continue
+		}
+		for _, m := range dropReasonPattern.FindAllStringSubmatch(string(b), -1) {
+			if n, err := strconv.ParseUint(m[1], 10, 32); err == nil {
+				reasons[uint32(n)] = m[2]
+			}
+		}
+		break
+	}
+	return reasons
+}
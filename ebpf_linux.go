@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// bpf(2) commands, program and map types, and helpers from linux/bpf.h.
+const (
+	bpfMapCreate         = 0
+	bpfMapLookupElem     = 1
+	bpfMapGetNextKey     = 4
+	bpfProgLoad          = 5
+	bpfRawTracepointOpen = 17
+
+	bpfMapTypeHash           = 1
+	bpfProgTypeRawTracepoint = 17
+
+	bpfFuncMapLookupElem   = 1
+	bpfFuncMapUpdateElem   = 2
+	bpfFuncProbeRead       = 4
+	bpfFuncProbeReadKernel = 113
+	bpfNoExist             = 1
+	bpfPseudoMapFD         = 1
+	bpfMaxKeys             = 4096
+	bpfVerifierLogSize     = 64 * 1024
+	sockCommonNumOffset    = 14 // offsetof(struct sock_common, skc_num)
+	bpfCounterKeySize      = 8
+	bpfCounterValueSize    = 8
+)
+
+var bpfLicense = []byte("GPL\x00")
+
+// bpfCounters is a hash map of 8 byte keys to u64 counts, filled in by one
+// of the programs below from a raw tracepoint.
+type bpfCounters struct {
+	mapFD, progFD, linkFD int
+
+	// The kernel is handed pointers to these, so they live on the heap.
+	key, next, value uint64
+}
+
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// openUDPDropCounters attaches to the udp_fail_queue_rcv_skb tracepoint,
+// which fires for every datagram a socket couldn't queue, counting them by
+// the error (in the low 32 bits of the key) and the socket's local port (in
+// the next 16).
+func openUDPDropCounters() (*bpfCounters, error) {
+	var err error
+	for _, probeRead := range []int32{bpfFuncProbeReadKernel, bpfFuncProbeRead} {
+		var c *bpfCounters
+		c, err = openBPFCounters("udp_fail_queue_rcv_skb", []bpfInsn{
+			{0xbf, 6, 1, 0, 0},                   // r6 = r1 (the tracepoint's arguments)
+			{0x7a, 10, 0, -8, 0},                 // *(u64 *)(fp - 8) = 0
+			{0x79, 7, 6, 0, 0},                   // r7 = args[0], rc
+			{0x63, 10, 7, -8, 0},                 // *(u32 *)(fp - 8) = r7
+			{0x79, 3, 6, 8, 0},                   // r3 = args[1], sk
+			{0x07, 3, 0, 0, sockCommonNumOffset}, // r3 += offsetof(skc_num)
+			{0xbf, 1, 10, 0, 0},                  // r1 = fp
+			{0x07, 1, 0, 0, -4},                  // r1 -= 4
+			{0xb7, 2, 0, 0, 2},                   // r2 = sizeof(skc_num)
+			{0x85, 0, 0, 0, probeRead},           // probe_read(fp - 4, 2, &sk->skc_num)
+		})
+		if err == nil {
+			return c, nil
+		}
+	}
+	return nil, err
+}
+
+// openSkbDropCounters attaches to the kfree_skb tracepoint, counting every
+// packet the kernel drops, of any protocol, by its skb_drop_reason. Kernels
+// before 5.17 don't pass a reason and refuse to attach the program.
+func openSkbDropCounters() (*bpfCounters, error) {
+	return openBPFCounters("kfree_skb", []bpfInsn{
+		{0xbf, 6, 1, 0, 0},   // r6 = r1 (the tracepoint's arguments)
+		{0x7a, 10, 0, -8, 0}, // *(u64 *)(fp - 8) = 0
+		{0x79, 7, 6, 16, 0},  // r7 = args[2], reason
+		{0x63, 10, 7, -8, 0}, // *(u32 *)(fp - 8) = r7
+	})
+}
+
+// bpfInsn is one eBPF instruction. The destination and source registers
+// share a byte, dst in the low nibble.
+type bpfInsn struct {
+	code     uint8
+	dst, src uint8
+	off      int16
+	imm      int32
+}
+
+// openBPFCounters loads a program that runs prologue, which leaves an 8 byte
+// key at fp - 8, then counts that key in a new hash map, and attaches it to
+// the raw tracepoint called name.
+func openBPFCounters(name string, prologue []bpfInsn) (*bpfCounters, error) {
+	mapAttr := struct {
+		mapType, keySize, valueSize, maxEntries uint32
+	}{bpfMapTypeHash, bpfCounterKeySize, bpfCounterValueSize, bpfMaxKeys}
+	mapFD, err := bpf(bpfMapCreate, unsafe.Pointer(&mapAttr), unsafe.Sizeof(mapAttr))
+	if err != nil {
+		return nil, fmt.Errorf("creating map: %v", err)
+	}
+	fd := int32(mapFD)
+	loadMap := func(dst uint8) []bpfInsn {
+		return []bpfInsn{{0x18, dst, bpfPseudoMapFD, 0, fd}, {}}
+	}
+
+	insns := append([]bpfInsn{}, prologue...)
+	insns = append(insns, loadMap(1)...) // r1 = map
+	insns = append(insns, []bpfInsn{
+		{0xbf, 2, 10, 0, 0},                   // r2 = fp
+		{0x07, 2, 0, 0, -8},                   // r2 -= 8
+		{0x85, 0, 0, 0, bpfFuncMapLookupElem}, // r0 = map_lookup_elem(map, fp - 8)
+		{0x15, 0, 0, 3, 0},                    // if r0 == 0 goto insert
+		{0xb7, 1, 0, 0, 1},                    // r1 = 1
+		{0xdb, 0, 1, 0, 0},                    // lock *(u64 *)(r0 + 0) += r1
+		{0x05, 0, 0, 9, 0},                    // goto out
+		{0x7a, 10, 0, -16, 1},                 // insert: *(u64 *)(fp - 16) = 1
+	}...)
+	insns = append(insns, loadMap(1)...) // r1 = map
+	insns = append(insns, []bpfInsn{
+		{0xbf, 2, 10, 0, 0},                   // r2 = fp
+		{0x07, 2, 0, 0, -8},                   // r2 -= 8
+		{0xbf, 3, 10, 0, 0},                   // r3 = fp
+		{0x07, 3, 0, 0, -16},                  // r3 -= 16
+		{0xb7, 4, 0, 0, bpfNoExist},           // r4 = BPF_NOEXIST
+		{0x85, 0, 0, 0, bpfFuncMapUpdateElem}, // map_update_elem(map, fp - 8, fp - 16, BPF_NOEXIST)
+		{0xb7, 0, 0, 0, 0},                    // out: r0 = 0
+		{0x95, 0, 0, 0, 0},                    // exit
+	}...)
+
+	progFD, err := loadBPFProgram(insns)
+	if err != nil {
+		unix.Close(mapFD)
+		return nil, err
+	}
+
+	tpName := append([]byte(name), 0)
+	tpAttr := struct {
+		name   uint64
+		progFD uint32
+		_      uint32
+	}{uint64(uintptr(unsafe.Pointer(&tpName[0]))), uint32(progFD), 0}
+	linkFD, err := bpf(bpfRawTracepointOpen, unsafe.Pointer(&tpAttr), unsafe.Sizeof(tpAttr))
+	if err != nil {
+		unix.Close(progFD)
+		unix.Close(mapFD)
+		return nil, fmt.Errorf("attaching to %s: %v", name, err)
+	}
+	return &bpfCounters{mapFD: mapFD, progFD: progFD, linkFD: linkFD}, nil
+}
+
+func loadBPFProgram(insns []bpfInsn) (int, error) {
+	code := make([]byte, 8*len(insns))
+	for i, insn := range insns {
+		b := code[8*i:]
+		b[0] = insn.code
+		b[1] = insn.dst | insn.src<<4
+		binary.LittleEndian.PutUint16(b[2:], uint16(insn.off))
+		binary.LittleEndian.PutUint32(b[4:], uint32(insn.imm))
+	}
+	log := make([]byte, bpfVerifierLogSize)
+	attr := struct {
+		progType, insnCnt uint32
+		insns, license    uint64
+		logLevel, logSize uint32
+		logBuf            uint64
+	}{
+		progType: bpfProgTypeRawTracepoint,
+		insnCnt:  uint32(len(insns)),
+		insns:    uint64(uintptr(unsafe.Pointer(&code[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&bpfLicense[0]))),
+		logLevel: 1,
+		logSize:  uint32(len(log)),
+		logBuf:   uint64(uintptr(unsafe.Pointer(&log[0]))),
+	}
+	fd, err := bpf(bpfProgLoad, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		if n := indexNUL(log); n > 0 {
+			return -1, fmt.Errorf("loading program: %v: %s", err, log[:n])
+		}
+		return -1, fmt.Errorf("loading program: %v", err)
+	}
+	return fd, nil
+}
+
+func indexNUL(b []byte) int {
+	for i, c := range b {
+		if c == 0 {
+			return i
+		}
+	}
+	return len(b)
+}
+
+// counts returns every key in the map with its count so far.
+func (c *bpfCounters) counts() (map[uint64]uint64, error) {
+	counts := make(map[uint64]uint64)
+	attr := struct {
+		mapFD uint32
+		_     uint32
+		key   uint64
+		value uint64
+		flags uint64
+	}{mapFD: uint32(c.mapFD)}
+
+	for first := true; ; first = false {
+		attr.key = uint64(uintptr(unsafe.Pointer(&c.key)))
+		if first {
+			attr.key = 0
+		}
+		attr.value = uint64(uintptr(unsafe.Pointer(&c.next)))
+		if _, err := bpf(bpfMapGetNextKey, unsafe.Pointer(&attr), unsafe.Sizeof(attr)); err != nil {
+			if err == unix.ENOENT {
+				return counts, nil
+			}
+			return nil, fmt.Errorf("listing map: %v", err)
+		}
+		c.key = c.next
+
+		attr.key = uint64(uintptr(unsafe.Pointer(&c.key)))
+		attr.value = uint64(uintptr(unsafe.Pointer(&c.value)))
+		if _, err := bpf(bpfMapLookupElem, unsafe.Pointer(&attr), unsafe.Sizeof(attr)); err == nil {
+			counts[c.key] = c.value
+		}
+	}
+}
+
+func (c *bpfCounters) Close() {
+	unix.Close(c.linkFD)
+	unix.Close(c.progFD)
+	unix.Close(c.mapFD)
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+type bpfCounters struct{}
+
+func openUDPDropCounters() (*bpfCounters, error) {
+	return nil, errors.New("eBPF is only supported on linux")
+}
+
+func openSkbDropCounters() (*bpfCounters, error) {
+	return nil, errors.New("eBPF is only supported on linux")
+}
+
+func (c *bpfCounters) counts() (map[uint64]uint64, error) { return nil, nil }
+
+func (c *bpfCounters) Close() {}
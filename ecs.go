@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ecsLabels returns the ecs_cluster, task_arn and container_name labels for
+// the target when the exporter runs in an ECS task, detected by the metadata
+// endpoint the ECS agent advertises to every container. It returns nil off
+// ECS. container_name is only set when the target's cgroup shows which of
+// the task's containers it runs in.
+func ecsLabels(ctx context.Context, pid string) (prometheus.Labels, error) {
+	uri := os.Getenv("ECS_CONTAINER_METADATA_URI_V4")
+	if uri == "" {
+		uri = os.Getenv("ECS_CONTAINER_METADATA_URI")
+	}
+	if uri == "" {
+		return nil, nil
+	}
+
+	req, err := http.NewRequest("GET", uri+"/task", nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s/task: %s", uri, resp.Status)
+	}
+
+	var task struct {
+		Cluster    string `json:"Cluster"`
+		TaskARN    string `json:"TaskARN"`
+		Containers []struct {
+			DockerID string `json:"DockerId"`
+			Name     string `json:"Name"`
+		} `json:"Containers"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&task); err != nil {
+		return nil, err
+	}
+
+	labels := prometheus.Labels{
+		"ecs_cluster": task.Cluster,
+		"task_arn":    task.TaskARN,
+	}
+	cgroup, err := ioutil.ReadFile(procPath(pid, "cgroup"))
+	if err != nil {
+		return labels, nil
+	}
+	for _, c := range task.Containers {
+		if c.DockerID != "" && strings.Contains(string(cgroup), c.DockerID) {
+			labels["container_name"] = c.Name
+			break
+		}
+	}
+	return labels, nil
+}
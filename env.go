@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix is prepended to a flag's name, upper-cased with dots and dashes
+// turned into underscores, to get the environment variable setting it, e.g.
+// UPE_WEB_LISTEN_ADDRESS for --web.listen-address.
+const envPrefix = "UPE_"
+
+// envAliases are shorter environment variable names accepted for flags.
+var envAliases = map[string]string{
+	"UPE_LISTEN_ADDRESS": "web.listen-address",
+}
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(flagName))
+}
+
+// applyEnv sets every flag not given on the command line from its
+// environment variable, if set. Repeatable flags take a comma separated list.
+// It returns the positional arguments given as UPE_TARGET_PROCESS and
+// UPE_PORT.
+func applyEnv() ([]string, error) {
+	onCommandLine := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		onCommandLine[f.Name] = true
+	})
+
+	names := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		names[envName(f.Name)] = f.Name
+	})
+	for env, name := range envAliases {
+		names[env] = name
+	}
+
+	for env, name := range names {
+		value, ok := os.LookupEnv(env)
+		if !ok || onCommandLine[name] {
+			continue
+		}
+		values := []string{value}
+		if _, repeatable := flag.Lookup(name).Value.(*stringsFlag); repeatable {
+			values = strings.Split(value, ",")
+		}
+		for _, v := range values {
+			if err := flag.Set(name, v); err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", env, err)
+			}
+		}
+	}
+
+	var args []string
+	if process := os.Getenv("UPE_TARGET_PROCESS"); process != "" {
+		args = append(args, process)
+	}
+	if port := os.Getenv("UPE_PORT"); port != "" {
+		args = append(args, port)
+	}
+	return args, nil
+}
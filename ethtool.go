@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// watchEthtool periodically exports the driver statistics matching include
+// for every interface in the network namespace of targetPID.
+func watchEthtool(ctx context.Context, include *regexp.Regexp) {
+	ethtoolStat := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metricName(legacyExporterPrefix, "ethtool_stat"),
+			Help: "NIC driver statistics as reported by ethtool -S for interfaces in the target's network namespace.",
+		},
+		[]string{"device", "stat"},
+	)
+	prometheus.MustRegister(ethtoolStat)
+
+	stats := newCounterTracker("ethtool", metricName(legacyExporterPrefix, "ethtool_stat"), ethtoolStat)
+	for {
+		nsPath := procPath(currentTargetPID(), "ns", "net")
+		devices, err := netDevices(procPath(currentTargetPID(), "net", "dev"))
+		if err == nil {
+			var current map[string]map[string]uint64
+			current, err = ethtoolStats(nsPath, devices)
+			for device, s := range current {
+				for name, value := range s {
+					if include.MatchString(name) {
+						stats.set(value, device, name)
+					}
+				}
+			}
+		}
+		if err != nil {
+			slog.Warn("Unable to collect ethtool stats", "err", err)
+		}
+		stats.sweep()
+
+		if !sleepContext(ctx, currentPollInterval()) {
+			return
+		}
+	}
+}
+
+// netDevices returns the interface names listed in a /proc/<pid>/net/dev file.
+func netDevices(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var devices []string
+	s := bufio.NewScanner(f)
+	for n := 0; s.Scan(); n++ {
+		// Skip the two header lines.
+		if n < 2 {
+			continue
+		}
+		if i := strings.IndexByte(s.Text(), ':'); i > 0 {
+			devices = append(devices, strings.TrimSpace(s.Text()[:i]))
+		}
+	}
+	return devices, s.Err()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ethtool ioctl commands from linux/ethtool.h.
+const (
+	ethtoolGDrvInfo = 0x03
+	ethtoolGStrings = 0x1b
+	ethtoolGStats   = 0x1d
+
+	ethSSStats         = 1
+	ethGStringLen      = 32
+	ethtoolDrvInfoSize = 196
+	ethtoolNStatsOff   = 180
+)
+
+// ethtoolStats returns the driver statistics of each device, keyed by device
+// and then stat name. Devices without driver statistics (loopback, most
+// virtual interfaces) are left out.
+func ethtoolStats(nsPath string, devices []string) (map[string]map[string]uint64, error) {
+	var fd int
+	err := inNetNS(nsPath, func() error {
+		var err error
+		fd, err = unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(fd)
+
+	stats := make(map[string]map[string]uint64, len(devices))
+	for _, device := range devices {
+		drvinfo := make([]byte, ethtoolDrvInfoSize)
+		binary.LittleEndian.PutUint32(drvinfo, ethtoolGDrvInfo)
+		if ethtoolIoctl(fd, device, drvinfo) != nil {
+			continue
+		}
+		n := binary.LittleEndian.Uint32(drvinfo[ethtoolNStatsOff:])
+		if n == 0 {
+			continue
+		}
+
+		names := make([]byte, 12+n*ethGStringLen)
+		binary.LittleEndian.PutUint32(names[0:], ethtoolGStrings)
+		binary.LittleEndian.PutUint32(names[4:], ethSSStats)
+		binary.LittleEndian.PutUint32(names[8:], n)
+		if err := ethtoolIoctl(fd, device, names); err != nil {
+			return stats, err
+		}
+
+		values := make([]byte, 8+n*8)
+		binary.LittleEndian.PutUint32(values[0:], ethtoolGStats)
+		binary.LittleEndian.PutUint32(values[4:], n)
+		if err := ethtoolIoctl(fd, device, values); err != nil {
+			return stats, err
+		}
+
+		s := make(map[string]uint64, n)
+		for i := uint32(0); i < n; i++ {
+			name := names[12+i*ethGStringLen : 12+(i+1)*ethGStringLen]
+			name = name[:bytes.IndexByte(append(name, 0), 0)]
+			s[string(name)] = binary.LittleEndian.Uint64(values[8+i*8:])
+		}
+		stats[device] = s
+	}
+	return stats, nil
+}
+
+func ethtoolIoctl(fd int, device string, data []byte) error {
+	// struct ifreq with ifr_data pointing at data.
+	var ifr struct {
+		name [unix.IFNAMSIZ]byte
+		data uintptr
+		_    [16]byte
+	}
+	copy(ifr.name[:unix.IFNAMSIZ-1], device)
+	ifr.data = uintptr(unsafe.Pointer(&data[0]))
+
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.SIOCETHTOOL, uintptr(unsafe.Pointer(&ifr)))
+	runtime.KeepAlive(data)
+	if errno != 0 {
+		return os.NewSyscallError("ioctl SIOCETHTOOL "+device, errno)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+func ethtoolStats(nsPath string, devices []string) (map[string]map[string]uint64, error) {
+	return nil, errors.New("ethtool statistics are only supported on linux")
+}
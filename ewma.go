@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// ewmaHalfLife is how long it takes a sample's weight in the average to
+	// halve, zero, the default, disables the smoothed gauge.
+	ewmaHalfLife time.Duration
+
+	udpBufferQueuedEWMA *prometheus.GaugeVec
+)
+
+func registerEWMAMetric(labels []string) {
+	udpBufferQueuedEWMA = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: metricName(legacyExporterPrefix, "buffer_queued_ewma"),
+			Help: "Exponentially weighted moving average of the number of queued UDP messages in the linux buffer.",
+		},
+		labels,
+	)
+	prometheus.MustRegister(udpBufferQueuedEWMA)
+}
+
+// ewma is an exponentially weighted moving average over irregularly spaced
+// samples: each sample's weight depends on the time since the previous one,
+// so the half-life holds regardless of how often we poll.
+type ewma struct {
+	value float64
+	last  time.Time
+}
+
+func (e *ewma) add(v float64, now time.Time) float64 {
+	if e.last.IsZero() {
+		e.value = v
+	} else {
+		alpha := 1 - math.Exp2(-now.Sub(e.last).Seconds()/ewmaHalfLife.Seconds())
+		e.value += alpha * (v - e.value)
+	}
+	e.last = now
+	return e.value
+}
+
+// observeEWMA folds queued into the target's moving average for protocol.
+func (t *target) observeEWMA(labels []string, protocol string, queued float64) {
+	if ewmaHalfLife <= 0 {
+		return
+	}
+	if t.queueEWMA == nil {
+		t.queueEWMA = make(map[string]*ewma)
+	}
+	e, ok := t.queueEWMA[protocol]
+	if !ok {
+		e = &ewma{}
+		t.queueEWMA[protocol] = e
+	}
+	udpBufferQueuedEWMA.WithLabelValues(labels...).Set(e.add(queued, time.Now()))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// detectFeatures probes the local kernel once at startup, logs what it
+// found and exports it so fleets can be sliced by what each host supports.
+// It isn't called with --ssh.hosts or --replay, whose tables come from other
+// kernels.
+func detectFeatures() {
+	featureInfo := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: metricName(legacyProcfsPrefix, "exporter_feature"),
+			Help: "Whether the kernel this exporter runs on supports a feature (1) or not (0).",
+		},
+		[]string{"name"},
+	)
+	kernelInfo := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: metricName(legacyProcfsPrefix, "exporter_kernel_info"),
+			Help: "The release of the kernel this exporter runs on, always 1.",
+		},
+		[]string{"release"},
+	)
+
+	features := map[string]bool{
+		"drops_column": hasDropsColumn("/proc/self/net/udp"),
+		"sock_diag":    probeSockDiag(),
+		"ebpf":         probeEBPF(),
+	}
+
+	release := kernelRelease()
+	prometheus.MustRegister(featureInfo)
+	prometheus.MustRegister(kernelInfo)
+	kernelInfo.WithLabelValues(release).Set(1)
+
+	names := make([]string, 0, len(features))
+	for name := range features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	summary := make([]string, 0, len(names))
+	for _, name := range names {
+		v := 0.0
+		if features[name] {
+			v = 1
+		}
+		featureInfo.WithLabelValues(name).Set(v)
+		summary = append(summary, fmt.Sprintf("%s=%t", name, features[name]))
+	}
+	slog.Info("Detected kernel features", "release", release, "features", strings.Join(summary, " "))
+}
+
+// hasDropsColumn reports whether the header of a procfs UDP table has the
+// drops column, which was only added in 2.6.27.
+func hasDropsColumn(filename string) bool {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	if !s.Scan() {
+		return false
+	}
+	for _, field := range strings.Fields(s.Text()) {
+		if field == "drops" {
+			return true
+		}
+	}
+	return false
+}
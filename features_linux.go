@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func kernelRelease() string {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return "unknown"
+	}
+	return string(bytes.TrimRight(uts.Release[:], "\x00"))
+}
+
+// probeSockDiag asks NETLINK_SOCK_DIAG for a dump of UDP sockets, which
+// fails when udp_diag isn't built or loaded.
+func probeSockDiag() bool {
+	_, err := listUDPSockets("/proc/self/ns/net", afInet, false)
+	return err == nil
+}
+
+// probeEBPF creates and immediately closes a one entry array map, which only
+// succeeds when the bpf syscall exists and we hold the capabilities to use it.
+func probeEBPF() bool {
+	attr := struct {
+		mapType    uint32
+		keySize    uint32
+		valueSize  uint32
+		maxEntries uint32
+	}{mapType: 2 /* BPF_MAP_TYPE_ARRAY */, keySize: 4, valueSize: 4, maxEntries: 1}
+
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, 0 /* BPF_MAP_CREATE */, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return false
+	}
+	unix.Close(int(fd))
+	return true
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+func kernelRelease() string { return "unknown" }
+
+func probeSockDiag() bool { return false }
+
+func probeEBPF() bool { return false }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// graphitePathReplacer swaps what Graphite would read as path separators or
+// the end of the path for underscores in label values.
+var graphitePathReplacer = strings.NewReplacer(".", "_", " ", "_", "/", "_", "\n", "_")
+
+// sendGraphite sends the exporter's gauges and counters to the carbon
+// plaintext listener at address every interval, as e.g.
+// udp.buffer.queued.statsd.udp with prefix "udp": the name below prefix, then
+// the label values in label name order. Counters are sent as is, so need
+// nonNegativeDerivative. It returns once ctx is done.
+func sendGraphite(ctx context.Context, address, prefix string, interval time.Duration) {
+	for sleepContext(ctx, interval) {
+		families, err := snapshotGatherer().Gather()
+		if err != nil {
+			slog.Warn("Unable to gather metrics for Graphite", "err", err)
+			continue
+		}
+		if err := sendGraphiteOnce(address, graphiteLines(families, prefix, time.Now())); err != nil {
+			slog.Warn("Unable to send to Graphite", "err", err)
+		}
+	}
+}
+
+func sendGraphiteOnce(address string, body []byte) error {
+	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write(body)
+	return err
+}
+
+func graphiteLines(families []*dto.MetricFamily, prefix string, now time.Time) []byte {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	var body bytes.Buffer
+	for _, f := range families {
+		if isRuntimeMetric(f.GetName()) {
+			continue
+		}
+		name := f.GetName()
+		if legacyMetricNames {
+			if strings.HasPrefix(name, legacyExporterPrefix) {
+				name = strings.TrimPrefix(name, legacyExporterPrefix)
+			} else {
+				name = strings.TrimPrefix(name, "udp_")
+			}
+		} else {
+			name = strings.TrimPrefix(name, metricPrefix)
+		}
+		name = prefix + "." + strings.Replace(name, "_", ".", -1)
+
+		for _, m := range f.GetMetric() {
+			var v float64
+			switch f.GetType() {
+			case dto.MetricType_GAUGE:
+				v = m.GetGauge().GetValue()
+			case dto.MetricType_COUNTER:
+				v = m.GetCounter().GetValue()
+			case dto.MetricType_UNTYPED:
+				v = m.GetUntyped().GetValue()
+			default:
+				continue
+			}
+			labels := m.GetLabel()
+			sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+			path := name
+			for _, l := range labels {
+				path += "." + graphitePathReplacer.Replace(l.GetValue())
+			}
+			fmt.Fprintf(&body, "%s %s %s\n", path, strconv.FormatFloat(v, 'f', -1, 64), timestamp)
+		}
+	}
+	return body.Bytes()
+}
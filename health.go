@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// serveHealthy answers liveness probes: it fails once a target's watch loop
+// hasn't finished a poll for three poll intervals, as the loop is then stuck.
+// With --collection.on-scrape there's no loop, so answering is enough.
+func serveHealthy(w http.ResponseWriter, r *http.Request) {
+	if !pollOnScrape {
+		for _, t := range currentTargets() {
+			t.stateMu.Lock()
+			last := t.lastPoll
+			t.stateMu.Unlock()
+			// The first poll can take a while to finish.
+			if last.IsZero() {
+				continue
+			}
+			if since := time.Since(last); since > 3*t.currentPollInterval() {
+				http.Error(w, fmt.Sprintf("%s hasn't been polled for %s", t.name, since.Round(time.Second)), http.StatusServiceUnavailable)
+				return
+			}
+		}
+	}
+	fmt.Fprintln(w, "Healthy.")
+}
+
+// serveReady answers readiness probes: it's ready once every target has been
+// found and at least one of its tables read.
+func serveReady(w http.ResponseWriter, r *http.Request) {
+	targets := currentTargets()
+	if len(targets) == 0 {
+		http.Error(w, "No targets found yet.", http.StatusServiceUnavailable)
+		return
+	}
+	for _, t := range targets {
+		t.stateMu.Lock()
+		parsed := t.parsed
+		t.stateMu.Unlock()
+		if !parsed {
+			http.Error(w, t.name+" hasn't been read yet.", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	fmt.Fprintln(w, "Ready.")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	// helpOverrides replaces the help text of metrics by name, from the
+	// config file's help section, for metric catalogs that register
+	// series by their description.
+	helpOverrides map[string]string
+	// helpOverridesMu guards helpOverrides, as reloads can change it.
+	helpOverridesMu sync.Mutex
+)
+
+func setHelpOverrides(help map[string]string) {
+	helpOverridesMu.Lock()
+	defer helpOverridesMu.Unlock()
+	helpOverrides = help
+}
+
+// overrideHelp replaces the help text of the families named in the config
+// file's help section.
+func overrideHelp(families []*dto.MetricFamily) {
+	helpOverridesMu.Lock()
+	defer helpOverridesMu.Unlock()
+	for _, f := range families {
+		if help, ok := helpOverrides[f.GetName()]; ok {
+			f.Help = proto.String(help)
+		}
+	}
+}
+
+// configHelp returns the config file's help section, v, as the help text of
+// each metric by its name.
+func configHelp(v interface{}) (map[string]string, error) {
+	section, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("help must map metric names to their help text")
+	}
+	help := make(map[string]string, len(section))
+	for name, text := range section {
+		s, ok := text.(string)
+		if !ok {
+			return nil, fmt.Errorf("help for %v must be a string", name)
+		}
+		help[fmt.Sprint(name)] = s
+	}
+	return help, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestOverrideHelp(t *testing.T) {
+	var config map[string]interface{}
+	if err := yaml.Unmarshal([]byte("help:\n  udp_exporter_buffer_dropped: Drops, from the catalog.\n  unknown_metric: Not exported.\n"), &config); err != nil {
+		t.Fatal(err)
+	}
+	help, err := configHelp(config["help"])
+	if err != nil {
+		t.Fatalf("configHelp() error = %v", err)
+	}
+	setHelpOverrides(help)
+	defer setHelpOverrides(nil)
+
+	families := []*dto.MetricFamily{
+		{Name: proto.String("udp_exporter_buffer_queued"), Help: proto.String("The number of queued UDP messages in the linux buffer.")},
+		{Name: proto.String("udp_exporter_buffer_dropped"), Help: proto.String("The number of dropped UDP messages in the linux buffer")},
+	}
+	overrideHelp(families)
+	if got, want := families[1].GetHelp(), "Drops, from the catalog."; got != want {
+		t.Errorf("overridden help = %q, want %q", got, want)
+	}
+	if got, want := families[0].GetHelp(), "The number of queued UDP messages in the linux buffer."; got != want {
+		t.Errorf("help not overridden = %q, want %q", got, want)
+	}
+}
+
+func TestConfigHelpErrors(t *testing.T) {
+	for _, doc := range []string{"help: text\n", "help:\n  udp_exporter_buffer_dropped: [a, b]\n"} {
+		var config map[string]interface{}
+		if err := yaml.Unmarshal([]byte(doc), &config); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := configHelp(config["help"]); err == nil {
+			t.Errorf("configHelp(%q) succeeded, want an error", doc)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// pushInflux writes the exporter's gauges and counters in InfluxDB line
+// protocol every interval, to writeURL: either an HTTP write endpoint (e.g.
+// http://influx:8086/api/v2/write?org=o&bucket=b, with headers as in
+// pushRemoteWrite for the token) or udp://host:port. Each metric is a
+// measurement with a value field, tagged with its labels. With
+// --push.wal-dir, HTTP writes that fail are sent again later. It returns once
+// ctx is done.
+func pushInflux(ctx context.Context, writeURL string, headers []string, interval time.Duration) {
+	u, err := url.Parse(writeURL)
+	if err != nil {
+		slog.Error("Invalid InfluxDB URL", "err", err)
+		return
+	}
+	header, err := parseHeaders(headers)
+	if err != nil {
+		slog.Error("Invalid InfluxDB header", "err", err)
+		return
+	}
+
+	wal, err := newPushWAL("influx")
+	if err != nil {
+		slog.Error("Unable to open the InfluxDB WAL", "err", err)
+		return
+	}
+
+	client := &http.Client{Timeout: interval}
+	send := func(lines []string) error {
+		return wal.send([]byte(strings.Join(lines, "\n")), func(body []byte) error {
+			return influxHTTP(ctx, client, writeURL, header, body)
+		})
+	}
+	if u.Scheme == "udp" {
+		conn, err := net.Dial("udp", u.Host)
+		if err != nil {
+			slog.Warn("Unable to reach InfluxDB", "err", err)
+			return
+		}
+		defer conn.Close()
+		send = func(lines []string) error { return influxUDP(conn, lines) }
+	}
+
+	for sleepContext(ctx, interval) {
+		families, err := snapshotGatherer().Gather()
+		if err != nil {
+			slog.Warn("Unable to gather metrics for InfluxDB", "err", err)
+			continue
+		}
+		if err := send(influxLines(families, time.Now())); err != nil {
+			slog.Warn("Unable to write to InfluxDB", "err", err)
+		}
+	}
+}
+
+func influxHTTP(ctx context.Context, client *http.Client, writeURL string, header http.Header, body []byte) error {
+	req, err := http.NewRequest("POST", writeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return responseError(resp)
+}
+
+// influxUDP sends lines in datagrams of up to statsdMaxPacket bytes, as
+// InfluxDB's UDP listener takes any number of lines per datagram.
+func influxUDP(conn net.Conn, lines []string) error {
+	var packet bytes.Buffer
+	for i, line := range lines {
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+		if i == len(lines)-1 || packet.Len()+1+len(lines[i+1]) > statsdMaxPacket {
+			if _, err := conn.Write(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+	}
+	return nil
+}
+
+func influxLines(families []*dto.MetricFamily, now time.Time) []string {
+	timestamp := strconv.FormatInt(now.UnixNano(), 10)
+	var lines []string
+	for _, f := range families {
+		if isRuntimeMetric(f.GetName()) {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			var v float64
+			switch f.GetType() {
+			case dto.MetricType_GAUGE:
+				v = m.GetGauge().GetValue()
+			case dto.MetricType_COUNTER:
+				v = m.GetCounter().GetValue()
+			case dto.MetricType_UNTYPED:
+				v = m.GetUntyped().GetValue()
+			default:
+				continue
+			}
+			if !finite(v) {
+				// Line protocol has no NaN or infinities.
+				continue
+			}
+			labels := m.GetLabel()
+			sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+			line := influxMeasurementEscaper.Replace(f.GetName())
+			for _, l := range labels {
+				// Empty tag values aren't allowed.
+				if l.GetValue() != "" {
+					line += "," + influxTagEscaper.Replace(l.GetName()) + "=" + influxTagEscaper.Replace(l.GetValue())
+				}
+			}
+			lines = append(lines, line+" value="+strconv.FormatFloat(v, 'g', -1, 64)+" "+timestamp)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestInfluxLines(t *testing.T) {
+	families := append(pushFamilies(), &dto.MetricFamily{
+		Name: proto.String("udp_procfs_unix_dgram_sockets"),
+		Type: dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{{
+			Label: []*dto.LabelPair{
+				{Name: proto.String("path"), Value: proto.String("/run/a b,c=d")},
+				{Name: proto.String("container"), Value: proto.String("")},
+			},
+			Gauge: &dto.Gauge{Value: proto.Float64(2)},
+		}},
+	})
+
+	got := influxLines(families, pushTime)
+	// Histograms, NaN and the Go runtime's metrics are left out, and so
+	// are empty tags.
+	want := []string{
+		"udp_procfs_buffer_queued,protocol=udp value=7488 1700000000000000000",
+		"udp_procfs_buffer_queued,protocol=udp6 value=0 1700000000000000000",
+		"udp_procfs_buffer_dropped,protocol=udp value=91 1700000000000000000",
+		`udp_procfs_unix_dgram_sockets,path=/run/a\ b\,c\=d value=2 1700000000000000000`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("influxLines():\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
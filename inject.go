@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+)
+
+const (
+	// injectedProtocol is the protocol label synthetic failures are exported
+	// under, so they can't be mistaken for (or hide) real data.
+	injectedProtocol = "injected"
+
+	// Every injectEvery polls the synthetic series get a queue spike and a
+	// burst of drops; in between the queue is empty and nothing drops.
+	injectEvery        = 6
+	injectedQueueSpike = 1 << 20
+	injectedDropBurst  = 1000
+)
+
+// injectFailures is set by the hidden --testing.inject-failures flag.
+var injectFailures bool
+
+// injectFailure records one poll of synthetic data for t. It goes through
+// record like real data so every derived metric (anomaly, peak, time since
+// last drop) reacts to it too.
+func (t *target) injectFailure() {
+	t.injectPolls++
+	queued := 0
+	if t.injectPolls%injectEvery == 0 {
+		queued = injectedQueueSpike
+		t.injectDropped += injectedDropBurst
+	}
+	t.record(injectedProtocol, queued, t.injectDropped)
+}
+
+// printVisibleDefaults is flag.PrintDefaults without the testing.* flags,
+// which are for exercising alert pipelines rather than general use.
+func printVisibleDefaults() {
+	visible := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	visible.SetOutput(flag.CommandLine.Output())
+	flag.VisitAll(func(f *flag.Flag) {
+		if !strings.HasPrefix(f.Name, "testing.") {
+			visible.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	visible.PrintDefaults()
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The service account credentials Kubernetes mounts into every pod.
+const (
+	serviceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	serviceAccountCA    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// kubernetesLabels are the target labels of --kubernetes.* targets.
+var kubernetesLabels = []string{"namespace", "pod", "container"}
+
+// kubeletDiscovery finds the containers on this node to watch through the
+// kubelet's /pods API, which lists the node's pods without needing access to
+// the API server. The exporter has to share the host's PID namespace
+// (hostPID) so the containers' processes can be found by their cgroups.
+type kubeletDiscovery struct {
+	url            string
+	client         *http.Client
+	namespace, pod string
+	selector       func(labels map[string]string) bool
+	container      string
+}
+
+// kubeletPods is as much of a kubelet /pods response as discovery needs.
+type kubeletPods struct {
+	Items []struct {
+		Metadata struct {
+			Name      string            `json:"name"`
+			Namespace string            `json:"namespace"`
+			Labels    map[string]string `json:"labels"`
+		} `json:"metadata"`
+		Status struct {
+			ContainerStatuses []struct {
+				Name        string `json:"name"`
+				ContainerID string `json:"containerID"`
+				State       struct {
+					Running *struct{} `json:"running"`
+				} `json:"state"`
+			} `json:"containerStatuses"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// newKubeletDiscovery returns discovery of the pod named pod, which may be
+// given as namespace/name, or of the pods matching the label selector
+// selector, in namespace unless it's empty. Only containers called container
+// are watched, unless it's empty.
+func newKubeletDiscovery(kubeletURL, namespace, pod, selector, container string, insecureSkipVerify bool) (*kubeletDiscovery, error) {
+	d := &kubeletDiscovery{
+		url:       strings.TrimSuffix(kubeletURL, "/") + "/pods",
+		namespace: namespace,
+		pod:       pod,
+		container: container,
+	}
+	if i := strings.IndexByte(pod, '/'); i >= 0 {
+		d.namespace, d.pod = pod[:i], pod[i+1:]
+	} else if pod != "" && namespace == "" {
+		d.namespace = "default"
+	}
+	if selector != "" {
+		var err error
+		if d.selector, err = parseLabelSelector(selector); err != nil {
+			return nil, err
+		}
+	}
+
+	// Kubelets usually serve a self-signed certificate rather than one
+	// from the cluster CA, hence --kubernetes.kubelet-insecure-skip-verify.
+	config := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if ca, err := ioutil.ReadFile(serviceAccountCA); err == nil && !insecureSkipVerify {
+		config.RootCAs = x509.NewCertPool()
+		config.RootCAs.AppendCertsFromPEM(ca)
+	}
+	d.client = &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{TLSClientConfig: config}}
+	return d, nil
+}
+
+// containers returns the IDs of the running containers discovery selects, by
+// their namespace/pod/container entry.
+func (d *kubeletDiscovery) containers(ctx context.Context) (map[string]string, error) {
+	req, err := http.NewRequest("GET", d.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Service account tokens are rotated, so it's read every time.
+	if token, err := ioutil.ReadFile(serviceAccountToken); err == nil {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	var pods kubeletPods
+	if err := doJSON(ctx, d.client, req, &pods); err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]string)
+	for _, p := range pods.Items {
+		m := p.Metadata
+		if d.namespace != "" && m.Namespace != d.namespace || d.pod != "" && m.Name != d.pod {
+			continue
+		}
+		if d.selector != nil && !d.selector(m.Labels) {
+			continue
+		}
+		for _, c := range p.Status.ContainerStatuses {
+			if c.State.Running == nil || d.container != "" && c.Name != d.container {
+				continue
+			}
+			// IDs are prefixed with the runtime, e.g. containerd://.
+			id := c.ContainerID
+			if i := strings.Index(id, "://"); i >= 0 {
+				id = id[i+len("://"):]
+			}
+			found[m.Namespace+"/"+m.Name+"/"+c.Name] = id
+		}
+	}
+	return found, nil
+}
+
+// newKubernetesTarget returns a target for the container with entry
+// namespace/pod/container, whose process is pid.
+func newKubernetesTarget(entry, containerID, pid string) *target {
+	labels := strings.SplitN(entry, "/", 3)
+	t := newProcessTarget(entry, pid, labels)
+	t.entry = entry
+	t.containerID = containerID
+	t.sdLabels["process"] = processName(pid)
+	for i, name := range kubernetesLabels {
+		t.sdLabels[name] = labels[i]
+	}
+	return t
+}
+
+// sync watches the containers discovery selects that aren't yet, including
+// those restarted since, and stops watching the ones that are gone.
+func (d *kubeletDiscovery) sync(ctx context.Context) error {
+	found, err := d.containers(ctx)
+	if err != nil {
+		return err
+	}
+
+	targetChangesMu.Lock()
+	defer targetChangesMu.Unlock()
+	var removed []*target
+	watching := make(map[string]bool)
+	for _, t := range currentTargets() {
+		if found[t.entry] != t.containerID {
+			removed = append(removed, t)
+			continue
+		}
+		watching[t.entry] = true
+	}
+	var entries []string
+	for entry := range found {
+		if !watching[entry] {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Strings(entries)
+
+	var added []*target
+	var pids map[string]string
+	for _, entry := range entries {
+		if pids == nil {
+			pids = cgroupContainerIDs()
+		}
+		pid, ok := pids[found[entry]]
+		if !ok {
+			// Its processes may not be running yet; next time.
+			slog.Debug("No process found for container", "container", entry, "id", found[entry])
+			continue
+		}
+		t := newKubernetesTarget(entry, found[entry], pid)
+		t.observeResolution(true)
+		added = append(added, t)
+	}
+	stopTargets("container", removed)
+	startTargets(ctx, "container", added)
+	return nil
+}
+
+// watchKubernetes syncs the watched containers with the kubelet every
+// interval until ctx is done.
+func watchKubernetes(ctx context.Context, d *kubeletDiscovery, interval time.Duration) {
+	for sleepContext(ctx, interval) {
+		if err := d.sync(ctx); err != nil {
+			slog.Warn("Unable to list pods from the kubelet", "err", err)
+		}
+	}
+}
+
+// parseLabelSelector parses a Kubernetes label selector, such as
+// app=statsd,tier!=canary or env in (prod,staging), into a matcher requiring
+// every requirement in it.
+func parseLabelSelector(selector string) (func(labels map[string]string) bool, error) {
+	var requirements []string
+	depth, start := 0, 0
+	for i, c := range selector {
+		switch {
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			requirements = append(requirements, selector[start:i])
+			start = i + 1
+		}
+	}
+	requirements = append(requirements, selector[start:])
+
+	var matchers []func(labels map[string]string) bool
+	for _, r := range requirements {
+		m, err := parseLabelRequirement(strings.TrimSpace(r))
+		if err != nil {
+			return nil, fmt.Errorf("invalid label selector %q: %v", selector, err)
+		}
+		matchers = append(matchers, m)
+	}
+	return func(labels map[string]string) bool {
+		for _, m := range matchers {
+			if !m(labels) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func parseLabelRequirement(r string) (func(labels map[string]string) bool, error) {
+	if r == "" {
+		return nil, fmt.Errorf("empty requirement")
+	}
+	if fields := strings.Fields(r); len(fields) >= 2 && (fields[1] == "in" || fields[1] == "notin") {
+		key, in := fields[0], fields[1] == "in"
+		set := strings.TrimSpace(strings.Join(fields[2:], " "))
+		if !strings.HasPrefix(set, "(") || !strings.HasSuffix(set, ")") {
+			return nil, fmt.Errorf("%q: the values must be in parentheses", r)
+		}
+		values := make(map[string]bool)
+		for _, v := range strings.Split(set[1:len(set)-1], ",") {
+			values[strings.TrimSpace(v)] = true
+		}
+		return func(labels map[string]string) bool {
+			v, ok := labels[key]
+			return ok && values[v] == in || !ok && !in
+		}, nil
+	}
+	for _, op := range []string{"!=", "==", "="} {
+		if i := strings.Index(r, op); i >= 0 {
+			key, value := strings.TrimSpace(r[:i]), strings.TrimSpace(r[i+len(op):])
+			if key == "" {
+				return nil, fmt.Errorf("%q has no key", r)
+			}
+			if op == "!=" {
+				return func(labels map[string]string) bool { return labels[key] != value }, nil
+			}
+			return func(labels map[string]string) bool {
+				v, ok := labels[key]
+				return ok && v == value
+			}, nil
+		}
+	}
+	if strings.HasPrefix(r, "!") {
+		key := strings.TrimSpace(r[1:])
+		return func(labels map[string]string) bool {
+			_, ok := labels[key]
+			return !ok
+		}, nil
+	}
+	return func(labels map[string]string) bool {
+		_, ok := labels[r]
+		return ok
+	}, nil
+}
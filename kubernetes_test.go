@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestParseLabelSelector(t *testing.T) {
+	labels := map[string]string{"app": "statsd", "tier": "metrics"}
+	tests := []struct {
+		selector string
+		want     bool
+		wantErr  bool
+	}{
+		{selector: "app=statsd", want: true},
+		{selector: "app==statsd", want: true},
+		{selector: "app=collectd", want: false},
+		{selector: "app!=collectd", want: true},
+		{selector: "app!=statsd", want: false},
+		{selector: "release!=canary", want: true},
+		{selector: "app", want: true},
+		{selector: "release", want: false},
+		{selector: "!release", want: true},
+		{selector: "!app", want: false},
+		{selector: "app in (collectd, statsd)", want: true},
+		{selector: "app in (collectd)", want: false},
+		{selector: "release in (canary)", want: false},
+		{selector: "app notin (collectd)", want: true},
+		{selector: "app notin (collectd,statsd)", want: false},
+		{selector: "release notin (canary)", want: true},
+		{selector: "app in (statsd),tier=metrics", want: true},
+		{selector: "app in (statsd, collectd), tier!=metrics", want: false},
+		{selector: "app in statsd", wantErr: true},
+		{selector: "=statsd", wantErr: true},
+		{selector: "app=statsd,", wantErr: true},
+	}
+	for _, tt := range tests {
+		match, err := parseLabelSelector(tt.selector)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseLabelSelector(%q) error = %v, wantErr %t", tt.selector, err, tt.wantErr)
+			continue
+		}
+		if err == nil && match(labels) != tt.want {
+			t.Errorf("parseLabelSelector(%q) matches %v = %t, want %t", tt.selector, labels, !tt.want, tt.want)
+		}
+	}
+}
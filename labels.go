@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// reservedLabels are the label names the exporter gives its own series, which
+// --label can't reuse.
+var reservedLabels = map[string]bool{
+	"protocol":  true,
+	"process":   true,
+	"pid":       true,
+	"host":      true,
+	"netns":     true,
+	"container": true,
+
+	"ecs_cluster":    true,
+	"task_arn":       true,
+	"container_name": true,
+}
+
+// parseStaticLabels turns --label's name=value pairs into the constant labels
+// every series registered afterwards gets.
+func parseStaticLabels(pairs []string) (prometheus.Labels, error) {
+	labels := make(prometheus.Labels, len(pairs))
+	for _, pair := range pairs {
+		i := strings.IndexByte(pair, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("%q isn't name=value", pair)
+		}
+		name, value := pair[:i], pair[i+1:]
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("%q isn't a valid label name", name)
+		}
+		if reservedLabels[name] {
+			return nil, fmt.Errorf("%q is one of the exporter's own labels", name)
+		}
+		if _, ok := labels[name]; ok {
+			return nil, fmt.Errorf("%q is given twice", name)
+		}
+		labels[name] = value
+	}
+	return labels, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head><title>UDP Procfs Exporter</title></head>
+<body>
+<h1>UDP Procfs Exporter</h1>
+<p>Version {{.Version}} ({{.Revision}}), built with {{.GoVersion}}.</p>
+<ul>
+<li><a href="{{.MetricsPath}}">Metrics</a></li>
+<li><a href="/-/healthy">Health</a> and <a href="/-/ready">readiness</a></li>
+<li><form action="/probe">Probe a process by name: <input name="process" placeholder="statsd" required> <input type="submit" value="Probe"></form></li>
+<li><a href="/api/v1/stats">Stats</a>, <a href="/api/v1/targets">targets</a>, <a href="/api/v1/sd">service discovery</a> and <a href="/api/v1/cardinality">cardinality</a></li>
+{{if .Pprof}}<li><a href="/debug/pprof/">Profiling</a></li>
+{{end}}</ul>
+<h2>Targets</h2>
+<table>
+<tr><th>Target</th><th>State</th><th>Labels</th></tr>
+{{range .Targets}}<tr><td>{{.Name}}</td><td>{{.State}}</td><td>{{.Labels}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+type landingTarget struct {
+	Name, State, Labels string
+}
+
+// landingPage serves a page at / linking to everything the exporter serves,
+// and listing the watched targets, so hitting the root shows it's up.
+func landingPage(metricsPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		data := struct {
+			Version, Revision, GoVersion, MetricsPath string
+			Pprof                                     bool
+			Targets                                   []landingTarget
+		}{exporterVersion(), revision, runtime.Version(), metricsPath, enablePprof, nil}
+		for _, t := range currentTargets() {
+			t.stateMu.Lock()
+			labels := make([]string, 0, len(t.sdLabels))
+			for k, v := range t.sdLabels {
+				labels = append(labels, k+"="+v)
+			}
+			t.stateMu.Unlock()
+			sort.Strings(labels)
+			data.Targets = append(data.Targets, landingTarget{t.name, t.currentState(), strings.Join(labels, " ")})
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		landingTemplate.Execute(w, data)
+	}
+}
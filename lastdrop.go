@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var lastDrops *lastDropCollector
+
+// lastDropCollector exports the time since each target last dropped a
+// packet, computed at scrape time so it keeps counting between polls.
+type lastDropCollector struct {
+	desc *prometheus.Desc
+
+	mu   sync.Mutex
+	last map[string]lastDrop
+}
+
+type lastDrop struct {
+	labels []string
+	at     time.Time
+}
+
+func newLastDropCollector(labels []string) *lastDropCollector {
+	return &lastDropCollector{
+		desc: prometheus.NewDesc(
+			metricName(legacyProcfsPrefix, "seconds_since_last_drop"),
+			"Seconds since the dropped count last increased, or since the exporter started watching if it never has.",
+			labels, nil,
+		),
+		last: make(map[string]lastDrop),
+	}
+}
+
+// observe records a poll of labels that saw dropped new drops.
+func (c *lastDropCollector) observe(labels []string, dropped int) {
+	key := strings.Join(labels, "\xff")
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.last[key]; !ok || dropped > 0 {
+		c.last[key] = lastDrop{labels: labels, at: now}
+	}
+}
+
+// forget stops exporting labels.
+func (c *lastDropCollector) forget(labels []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.last, strings.Join(labels, "\xff"))
+}
+
+func (c *lastDropCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *lastDropCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, l := range c.last {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, time.Since(l.at).Seconds(), l.labels...)
+	}
+}
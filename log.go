@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+var (
+	// logLevel and logFormat are set by --log.level and --log.format.
+	logLevel  = "info"
+	logFormat = "text"
+)
+
+// setupLogging sends the log, from now on, to stderr at logLevel in
+// logFormat: logfmt-style key=value pairs, or one JSON object per line.
+func setupLogging() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		return fmt.Errorf("invalid --log.level %s, must be debug, info, warn or error", logLevel)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch logFormat {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, opts)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, opts)))
+	default:
+		return fmt.Errorf("invalid --log.format %s, must be text or json", logFormat)
+	}
+	return nil
+}
+
+// fatal logs msg at error level and exits, like log.Fatal did.
+func fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// lxdSockets are where the snap and the distro packages put the LXD socket.
+var lxdSockets = []string{"/var/snap/lxd/common/lxd/unix.socket", "/var/lib/lxd/unix.socket"}
+
+// lxdContainerPID finds the init PID of the LXD container called name.
+func lxdContainerPID(ctx context.Context, socket, name string) (string, error) {
+	sockets := []string{socket}
+	if socket == "" {
+		sockets = lxdSockets
+	}
+
+	var lastErr error
+	for _, s := range sockets {
+		if _, err := os.Stat(s); err != nil {
+			lastErr = err
+			continue
+		}
+		client := unixSocketClient(s)
+		// /1.0/instances replaced /1.0/containers in LXD 3.19.
+		for _, collection := range []string{"instances", "containers"} {
+			var state struct {
+				Metadata struct {
+					Status string `json:"status"`
+					Pid    int    `json:"pid"`
+				} `json:"metadata"`
+			}
+			err := getJSON(ctx, client, "http://lxd/1.0/"+collection+"/"+url.PathEscape(name)+"/state", &state)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			if state.Metadata.Pid <= 0 {
+				return "", fmt.Errorf("lxd container %s is %s", name, state.Metadata.Status)
+			}
+			return strconv.Itoa(state.Metadata.Pid), nil
+		}
+	}
+	return "", fmt.Errorf("unable to query lxd container %s: %v", name, lastErr)
+}
@@ -3,156 +3,1358 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/exporter-toolkit/web"
 )
 
 var (
-	targetProcName  string
-	targetPID       string
+	targetProcName    string
+	targetPID         string
+	udpBufferQueued   *prometheus.GaugeVec
+	udpBufferTxQueued *prometheus.GaugeVec
+	udpBufferDropped  *prometheus.CounterVec
+
+	udpBufferDroppedLastInterval *prometheus.GaugeVec
+
+	// pollInterval is how often every collector reads its source.
+	pollInterval = 10 * time.Second
+
+	// onTargetExit is set by --on-target-exit: what to do once a target's
+	// processes have all exited.
+	onTargetExit = "retry"
+
+	// protocols are the procfs net tables we read, named as they are exported
+	// in the protocol label.
+	protocols = []string{"udp", "udp6", "udplite", "udplite6"}
+
+	// webConfigFile is --web.config.file, the exporter-toolkit web
+	// configuration to serve with TLS or basic auth from.
+	webConfigFile string
+	// webSystemdSocket is --web.systemd-socket, to serve on the sockets
+	// systemd passes rather than --web.listen-address.
+	webSystemdSocket bool
+)
+
+// target is a single watched process. labels holds the values for any label
+// names passed to registerMetrics ahead of protocol, and open returns the
+// contents of /proc/<pid>/net/<protocol> for it, giving up when ctx is done.
+type target struct {
+	name   string
+	labels []string
+	open   func(ctx context.Context, protocol string) (io.ReadCloser, error)
+	polls  int
+
+	// stateMu guards state, pids, sdLabels, totals, tables, owned, lastPoll
+	// and parsed, which change as the watch loop runs and are read by the
+	// HTTP handlers.
+	stateMu sync.Mutex
+	state   string
+
+	// sdLabels describe the target on the http_sd endpoint.
+	sdLabels map[string]string
+
+	// totals are the last poll's sums, by protocol, for /api/v1/stats.
+	totals map[string]protocolTotals
+	// tables and owned are the tables the last poll read, by protocol,
+	// and the sockets it counted in them, which /api/v1/stats and
+	// /api/v1/sd list rather than reading the tables again.
+	tables map[string][]byte
+	owned  map[string]bool
+
+	// lastPoll is when the last poll finished, and parsed whether any
+	// ever read a table, for the health endpoints.
+	lastPoll time.Time
+	parsed   bool
+
+	// pids are the local processes open reads, started at starts, and
+	// rediscover, when set, looks for them again once they have all exited,
+	// failing with ctx's error if ctx is done first.
+	// members, when set, lists them afresh every poll instead, as for a
+	// cgroup, whose processes come and go. rescan, with rediscover set, has
+	// it look for them every --match-mode.rescan-interval too, last at
+	// rescannedAt, to pick up processes joining those still running.
+	// rebaseDrops is set for the poll after they changed.
+	pids        []string
+	starts      []string
+	rediscover  func(ctx context.Context) ([]string, error)
+	members     func() []string
+	rescan      bool
+	rescannedAt time.Time
+	rebaseDrops bool
+
+	// expire is set for targets that can't find their process again, those
+	// of --match-mode=per-pid, which are stopped and their series deleted
+	// once it's been gone for --series.retention. absentSince is when it
+	// was first missed. group is the per-pid selection they're from.
+	expire      bool
+	absentSince time.Time
+	group       *pidGroup
+
+	// nsPath is the network namespace of a --netns target.
+	nsPath string
+
+	// entry is the --target name, --ssh.hosts host or --netns path t
+	// was started for, which reloads tell targets apart by and
+	// --target.poll-interval names; it's empty for a single process.
+	// stop, once start has been called, stops t's watch loop, which
+	// closes done on returning.
+	entry string
+	// containerID is the container a --kubernetes.* target was started
+	// for, so that a restarted container is watched anew.
+	containerID string
+	stop        context.CancelFunc
+	done        chan struct{}
+
+	lastDropped map[string]int
+	// exported is what t's polls exported by protocol, for
+	// scrapeCollector.
+	exported     map[string]*exportedTotals
+	queueHistory map[string]*rollingWindow
+	queueEWMA    map[string]*ewma
+
+	injectPolls   int
+	injectDropped int
+
+	// upValues are the label values t's udp_procfs_target_up was last
+	// exported with.
+	upValues []string
+
+	// ownedFallback is set once we've given up on filtering to the sockets
+	// the target owns.
+	ownedFallback bool
+}
+
+// newProcessTarget returns a target reading the procfs tables of the local
+// process pid, called name.
+func newProcessTarget(name, pid string, labels []string) *target {
+	t := &target{
+		name:     name,
+		labels:   labels,
+		sdLabels: map[string]string{"process": name, "pid": pid},
+	}
+	t.setPIDs([]string{pid})
+	t.open = func(ctx context.Context, protocol string) (io.ReadCloser, error) {
+		pids := t.livePIDs()
+		if len(pids) == 0 {
+			return nil, &os.PathError{Op: "open", Path: procPath(pid, "net", protocol), Err: os.ErrNotExist}
+		}
+		return os.Open(procPath(pids[0], "net", protocol))
+	}
+	return t
+}
+
+func registerMetrics(targetLabels []string) {
+	labels := append(append([]string{}, targetLabels...), "protocol")
 	udpBufferQueued = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "udp_exporter_buffer_queued",
+			Name: metricName(legacyExporterPrefix, "buffer_queued"),
 			Help: "The number of queued UDP messages in the linux buffer.",
 		},
-		[]string{"protocol"},
+		labels,
+	)
+	udpBufferTxQueued = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: metricName(legacyExporterPrefix, "buffer_tx_queued"),
+			Help: "The number of bytes queued for sending in the linux buffer.",
+		},
+		labels,
 	)
 	udpBufferDropped = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: "udp_exporter_buffer_dropped",
+			Name: metricName(legacyExporterPrefix, "buffer_dropped"),
 			Help: "The number of dropped UDP messages in the linux buffer",
 		},
-		[]string{"protocol"},
+		labels,
 	)
-)
-
-func init() {
-	prometheus.MustRegister(udpBufferQueued)
-	prometheus.MustRegister(udpBufferDropped)
+	udpBufferDroppedLastInterval = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: metricName(legacyExporterPrefix, "buffer_dropped_last_interval"),
+			Help: "The number of UDP messages dropped between the last two polls, for consumers that don't handle counters.",
+		},
+		labels,
+	)
+	if !pollOnScrape {
+		// Otherwise scrapeCollector exports them from each scrape's
+		// poll.
+		prometheus.MustRegister(udpBufferQueued)
+		prometheus.MustRegister(udpBufferTxQueued)
+		prometheus.MustRegister(udpBufferDropped)
+	}
+	prometheus.MustRegister(udpBufferDroppedLastInterval)
+	if backend == "netlink" {
+		registerUtilizationMetric(labels)
+	}
+	registerSampleHistogram(labels)
+	registerStateMetric(targetLabels)
+	registerBuildInfo()
+	registerTelemetryMetrics(targetLabels)
+	newSocketMetrics()
+	newPeerMetrics()
+	lastDrops = newLastDropCollector(labels)
+	prometheus.MustRegister(lastDrops)
+	udpBufferDroppedPeak = newPeakCollector(
+		metricName(legacyExporterPrefix, "buffer_dropped_interval_max"),
+		"The most UDP messages dropped between two consecutive polls since the last scrape.",
+		labels,
+	)
+	prometheus.MustRegister(udpBufferDroppedPeak)
+	udpBufferQueuedPeak = newPeakCollector(
+		metricName(legacyExporterPrefix, "buffer_queued_max"),
+		"The most queued UDP messages seen in a poll since the last scrape.",
+		labels,
+	)
+	prometheus.MustRegister(udpBufferQueuedPeak)
+	if anomalyWindow > 0 {
+		registerAnomalyMetrics(labels)
+	}
+	if ewmaHalfLife > 0 {
+		registerEWMAMetric(labels)
+	}
+	if sampleInterval > 0 {
+		registerSampleMetrics(labels)
+	}
 }
 
 func main() {
+	listenAddress := flag.String("web.listen-address", ":8125", "Address to listen on for the web interface and telemetry. A port given as the last argument overrides it.")
+	telemetryPath := flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
+	flag.StringVar(&webConfigFile, "web.config.file", "", "Path to a Prometheus exporter-toolkit web configuration file, to serve over TLS or require basic auth.")
+	// Socket activation is only available on Linux.
+	if runtime.GOOS == "linux" {
+		flag.BoolVar(&webSystemdSocket, "web.systemd-socket", false, "Use systemd socket activation listeners instead of port listeners (Linux only).")
+	}
+	flag.BoolVar(&enableOpenMetrics, "web.enable-openmetrics", false, "Serve the OpenMetrics format, with created timestamps for the counters, to scrapers that ask for it. Counter samples end in _total, renaming udp_procfs_buffer_dropped.")
+	flag.BoolVar(&enableLifecycle, "web.enable-lifecycle", false, "Enable reloading the config file through POST /-/reload, and adding and removing targets through POST and DELETE /api/v1/targets.")
+	flag.BoolVar(&enablePprof, "web.enable-pprof", false, "Serve Go's profiling endpoints under /debug/pprof/, to profile the exporter itself in place.")
+	flag.DurationVar(&pollInterval, "poll-interval", pollInterval, "How often to read the target's procfs tables and the optional collectors' sources.")
+	flag.StringVar(&metricPrefix, "metric-prefix", metricPrefix, "Prefix of the exporter's metric names. Go runtime and HTTP handler metrics keep theirs.")
+	flag.BoolVar(&legacyMetricNames, "legacy-metric-names", false, "Export the metrics under their names from before --metric-prefix, udp_exporter_*, udp_procfs_* and udp_socket_*, for dashboards built on them.")
+	flag.BoolVar(&pollOnScrape, "collection.on-scrape", false, "Poll the targets when scraped rather than every --poll-interval, so the metrics are never staler than the scrape. The optional collectors still poll in the background.")
+	flag.DurationVar(&sampleInterval, "sample-interval", 0, "How often to also sample the local target's queues between polls, e.g. 200ms, exporting their min, max and average since the last scrape. 0 disables sampling.")
+	sshHosts := flag.String("ssh.hosts", "", "Comma separated list of [user@]host[:port] to collect from over SSH instead of the local /proc.")
+	sshIdentity := flag.String("ssh.identity-file", "", "Private key used to authenticate to --ssh.hosts.")
+	sshUser := flag.String("ssh.user", "", "Login user for --ssh.hosts entries that don't specify one.")
+	flag.StringVar(&sampleHistogram, "sample.histogram", "", "Also count the queues every poll reads in a histogram of the bytes queued, or of how full the buffers are (ratio), which needs --backend=netlink. One of: [bytes, ratio]")
+	flag.StringVar(&sampleBuckets, "sample.histogram.buckets", "", "Comma separated upper bounds of the --sample.histogram buckets, in its unit, instead of its presets.")
+	var targetIntervals stringsFlag
+	flag.Var(&targetIntervals, "target.poll-interval", "An entry=duration pair polling the targets of one --target, --ssh.hosts or --netns entry at their own interval instead of --poll-interval, e.g. statsd=1s. Can be repeated.")
+	ethtool := flag.Bool("collector.ethtool", false, "Export NIC driver statistics for interfaces in the target's network namespace.")
+	ethtoolInclude := flag.String("collector.ethtool.metrics-include", `drop|miss|no_buf|nobuf|discard`, "Regexp of ethtool statistic names to export.")
+	qdisc := flag.Bool("collector.qdisc", false, "Export qdisc drop and overlimit counters for interfaces in the target's network namespace.")
+	skmem := flag.Bool("collector.skmem", false, "Export per-socket memory counters (rmem_alloc, rcvbuf, fwd_alloc, wmem_alloc, sndbuf, drops) obtained via sock_diag.")
+	flag.BoolVar(&skmemInodeLabel, "collector.skmem.inode-label", false, "Label per-socket series with the socket inode, for joining against ss -e and fd listings. Can produce many series.")
+	process := flag.Bool("collector.process", false, "Export CPU time, resident memory, thread count, start time and open file descriptors of the watched process.")
+	peers := flag.Bool("collector.peers", false, "Export queued and dropped counts of connected UDP sockets, by remote address and port.")
+	tcp := flag.Bool("collector.tcp", false, "Export socket counts by state, queued bytes and retransmit timers of the target's TCP sockets.")
+	snmp := flag.Bool("collector.snmp", false, "Export the UDP counters of /proc/net/snmp and snmp6 for the target's network namespace, including RcvbufErrors.")
+	netstat := flag.Bool("collector.netstat", false, "Export counters from /proc/net/netstat, snmp and snmp6 for the target's network namespace.")
+	netstatFields := flag.String("collector.netstat.fields", netstatInclude, "Regexp of MIB_field names, e.g. IpExt_InOctets, of the netstat counters to export.")
+	sockstat := flag.Bool("collector.sockstat", false, "Export the UDP socket counts and memory of /proc/net/sockstat and sockstat6 for the target's network namespace.")
+	softnet := flag.Bool("collector.softnet", false, "Export the host's per-CPU network backlog processed, dropped and time_squeeze counters from /proc/net/softnet_stat.")
+	sysctl := flag.Bool("collector.sysctl", false, "Export the net.core.rmem_max, net.core.rmem_default and net.ipv4.udp_rmem_min receive buffer limits of the target's network namespace.")
+	ebpf := flag.Bool("collector.ebpf", false, "Count drops host-wide as they happen with eBPF, from the udp_fail_queue_rcv_skb tracepoint by error and local port, and from kfree_skb by drop reason. Needs CAP_BPF and CAP_PERFMON (or root).")
+	raw := flag.Bool("collector.raw", false, "Also read the raw, raw6, icmp and icmp6 tables, exporting their sockets' queued and dropped counts under those protocol labels.")
+	unixDgram := flag.Bool("collector.unix", false, "Export counts and send queue sizes of the target's unix domain datagram sockets, by path.")
+	sockets := flag.Bool("collector.sockets", false, "Export queued and dropped counts of every UDP socket of the target separately, by local address, port and inode.")
+	flag.DurationVar(&seriesRetention, "series.retention", seriesRetention, "How long the socket, peer, ethtool and qdisc collectors keep exporting a label set after it disappears, and --match-mode=per-pid a PID after it exits.")
+	var retentionOverrides stringsFlag
+	flag.Var(&retentionOverrides, "series.retention.override", "A mode=duration pair keeping the series of one collector (sockets, peers, skmem, qdisc, ethtool, ebpf or unix), or of per-pid's PIDs, for their own time instead of --series.retention, e.g. per-pid=1h. Can be repeated.")
+	flag.BoolVar(&seriesZeroBeforeDelete, "series.zero-before-delete", false, "Set gauges of disappeared label sets to 0 before deleting them, so the last value doesn't linger in queries.")
+	flag.IntVar(&seriesMaxPerMetric, "series.max-per-metric", seriesMaxPerMetric, "Most label sets the socket, peer, ethtool and qdisc collectors export per metric. New label sets over the limit are dropped.")
+	gate := flag.Duration("fail-on-drops", 0, "Watch the target for this long, then exit 0 if it dropped nothing and 1 otherwise instead of serving metrics.")
+	flag.IntVar(&anomalyWindow, "anomaly.window", anomalyWindow, "Number of past polls the queue depth z-score is computed against, e.g. 30. The default of 0 disables anomaly detection.")
+	flag.Float64Var(&anomalyThreshold, "anomaly.threshold", anomalyThreshold, "Absolute z-score at which the queue depth is flagged as anomalous.")
+	flag.DurationVar(&ewmaHalfLife, "ewma.half-life", ewmaHalfLife, "Half-life of the smoothed queue depth gauge, e.g. 1m. The default of 0 disables it.")
+	flag.StringVar(&procfsPath, "path.procfs", "", "Procfs mountpoint to find targets in. Defaults to /proc, or to a host procfs mounted at "+strings.Join(hostProcfsCandidates, ", ")+" when /proc is namespaced.")
+	flag.BoolVar(&injectFailures, "testing.inject-failures", false, "Export synthetic drops and queue spikes under protocol=\""+injectedProtocol+"\" to test alerting end-to-end.")
+	flag.StringVar(&logLevel, "log.level", logLevel, "Only log messages with the given severity or above. One of: [debug, info, warn, error]")
+	flag.StringVar(&logFormat, "log.format", logFormat, "Output format of log messages, on stderr. One of: [text, json]")
+	var targetNames stringsFlag
+	flag.Var(&targetNames, "target", "Watch the process with this name. Can be repeated to watch several processes at once, in which case metrics gain a process label.")
+	procRegex := flag.String("process.regex", "", "Watch the last process whose status Name: fully matches this regexp instead of one with an exact name.")
+	cmdlineMatch := flag.String("process.cmdline-match", "", "Watch the last process whose command line contains this string. Combines with --process.regex.")
+	cmdlineRegex := flag.String("process.cmdline-regex", "", "Watch the last process whose command line matches this regexp. Combines with --process.regex.")
+	cgroupPath := flag.String("cgroup", "", "Watch every process in this v1 or v2 cgroup and the cgroups under it, e.g. /sys/fs/cgroup/system.slice/statsd.service, as one target, re-reading its members every poll. Paths that aren't absolute are under /sys/fs/cgroup.")
+	procPort := flag.Int("process.port", 0, "Watch the last process with a UDP or UDP-Lite socket bound to this port, whatever its name. Combines with the other --process.* flags.")
+	flag.StringVar(&backend, "backend", backend, "How to read the sockets of local targets: by parsing the procfs tables, or through sock_diag, which is much faster with tens of thousands of sockets. One of: [procfs, netlink]")
+	flag.StringVar(&onTargetExit, "on-target-exit", onTargetExit, "What to do once the watched process exits: look for it again, publishing zeros meanwhile (retry), exit non-zero so a supervisor restarts the exporter (exit), or like retry, but keep publishing the last values (hold). One of: [retry, exit, hold]")
+	flag.StringVar(&matchMode, "match-mode", matchMode, "What to watch when the process name or --process.* matches several PIDs: the last one found, all of them as one (aggregate), or each with a pid label (per-pid). One of: [last, aggregate, per-pid]")
+	flag.DurationVar(&matchRescanInterval, "match-mode.rescan-interval", matchRescanInterval, "How often --match-mode=aggregate and per-pid look for processes that started matching since, such as new workers. 0 disables it.")
+	pidFlag := flag.String("pid", "", "Watch the process with this PID instead of looking one up by name.")
+	crioContainer := flag.String("crio.container", "", "Watch the CRI-O container with this (Kubernetes) container name instead of a process name.")
+	crioSocket := flag.String("crio.socket", "/var/run/crio/crio.sock", "Path to the CRI-O API socket.")
+	podmanContainer := flag.String("podman.container", "", "Watch the Podman container with this name or ID instead of a process name.")
+	podmanSocket := flag.String("podman.socket", "", "Path to the Podman API socket. Defaults to the rootful socket as root and the calling user's rootless socket otherwise.")
+	lxdContainer := flag.String("lxd.container", "", "Watch the LXD container with this name instead of a process name.")
+	lxdSocket := flag.String("lxd.socket", "", "Path to the LXD API socket. Defaults to the snap's socket, then /var/lib/lxd/unix.socket.")
+	k8sPod := flag.String("kubernetes.pod", "", "Watch the containers of the pod on this node with this name, or namespace/name, found through the kubelet. Needs hostPID.")
+	k8sSelector := flag.String("kubernetes.selector", "", "Watch the containers of the pods on this node matching this label selector, e.g. app=statsd, found through the kubelet. Needs hostPID.")
+	k8sNamespace := flag.String("kubernetes.namespace", "", "Only watch pods in this namespace. Defaults to every namespace with --kubernetes.selector, and to default for a --kubernetes.pod without one.")
+	k8sContainer := flag.String("kubernetes.container", "", "Only watch the pods' containers with this name.")
+	kubeletURL := flag.String("kubernetes.kubelet-url", "https://localhost:10250", "URL of this node's kubelet API.")
+	kubeletInsecure := flag.Bool("kubernetes.kubelet-insecure-skip-verify", false, "Don't verify the kubelet's serving certificate, which is usually self-signed.")
+	k8sRefresh := flag.Duration("kubernetes.refresh-interval", 30*time.Second, "How often to look for new, restarted and removed containers through the kubelet.")
+	var netnsPaths stringsFlag
+	flag.BoolVar(&socketsNamespaceWide, "sockets.namespace-wide", false, "Count every socket in the target's network namespace, not just those the target has open.")
+	flag.Var(&netnsPaths, "netns", "Collect from the network namespace bind-mounted at this path (e.g. /var/run/netns/blue) instead of a process's. Can be repeated.")
+	vmURL := flag.String("push.victoriametrics.url", "", "Push metrics to this VictoriaMetrics /api/v1/import/prometheus URL, in addition to serving them.")
+	var staticLabels stringsFlag
+	flag.Var(&staticLabels, "label", "A name=value label to add to every series, e.g. service=statsd. Can be repeated.")
+	var vmLabels stringsFlag
+	flag.Var(&vmLabels, "push.victoriametrics.extra-label", "A name=value label VictoriaMetrics adds to every pushed series. Can be repeated.")
+	gatewayURL := flag.String("push.gateway-url", "", "Push metrics to the Prometheus Pushgateway at this URL, in addition to serving them.")
+	gatewayJob := flag.String("push.gateway-job", "udp_procfs_exporter", "Job label to push to --push.gateway-url under.")
+	var gatewayGrouping stringsFlag
+	flag.Var(&gatewayGrouping, "push.gateway-grouping", "A name=value grouping label to push to --push.gateway-url under, besides the job. Can be repeated.")
+	remoteWriteURL := flag.String("push.remote-write-url", "", "Send metrics to this Prometheus remote_write URL, e.g. Mimir's /api/v1/push, in addition to serving them.")
+	var remoteWriteHeaders stringsFlag
+	flag.Var(&remoteWriteHeaders, "push.remote-write-header", "A \"Name: value\" header to send --push.remote-write-url, e.g. \"X-Scope-OrgID: edge\". Can be repeated.")
+	otlpEndpoint := flag.String("otlp.endpoint", "", "Push metrics to the OTLP/HTTP receiver at this URL, e.g. http://otel-collector:4318, in addition to serving them.")
+	var otlpHeaders stringsFlag
+	flag.Var(&otlpHeaders, "otlp.header", "A \"Name: value\" header to send --otlp.endpoint. Can be repeated.")
+	graphiteAddress := flag.String("graphite.address", "", "Send the metrics to the carbon plaintext listener at this host:port every --poll-interval, in addition to serving them.")
+	graphitePrefix := flag.String("graphite.prefix", "udp", "First component of the Graphite paths sent to --graphite.address.")
+	influxURL := flag.String("influx.url", "", "Write the metrics in InfluxDB line protocol to this HTTP write URL, e.g. http://influx:8086/api/v2/write?org=o&bucket=b, or udp://host:port, in addition to serving them.")
+	var influxHeaders stringsFlag
+	flag.Var(&influxHeaders, "influx.header", "A \"Name: value\" header to send --influx.url, e.g. \"Authorization: Token ...\". Can be repeated.")
+	statsdAddress := flag.String("statsd.address", "", "Send the metrics as DogStatsD gauges and counters to the agent at this host:port, in addition to serving them.")
+	flag.StringVar(&walDir, "push.wal-dir", "", "Keep the requests --push.remote-write-url, --otlp.endpoint and an HTTP --influx.url can't send in this directory, to send them again once the sink is back.")
+	flag.Int64Var(&walMaxBytes, "push.wal-max-bytes", walMaxBytes, "Most bytes of requests each sink keeps in --push.wal-dir before dropping the oldest.")
+	pushInterval := flag.Duration("push.interval", 10*time.Second, "How often to push to --push.victoriametrics.url, --push.gateway-url, --push.remote-write-url, --otlp.endpoint, --influx.url and --statsd.address, and write --output.textfile-dir.")
+	textfileDir := flag.String("output.textfile-dir", "", "Also write metrics to "+textfileName+" in this directory, for node_exporter's textfile collector. Pass --web.listen-address= to only write the file.")
+	recordFile := flag.String("record", "", "Archive every procfs table read for the watched process to this gzipped file, for --replay.")
+	replayFile := flag.String("replay", "", "Serve metrics by playing back a --record archive instead of watching a process.")
+	configFile := flag.String("config.file", "", "YAML file of options, in the format printed by --dump-config. Flags and environment variables override it.")
+	flag.BoolVar(&configWatch, "config.watch", false, "Reload --config.file whenever it changes, as well as on SIGHUP and POST /-/reload.")
+	showConfig := flag.Bool("dump-config", false, "Print the effective configuration as YAML and exit.")
+	showVersion := flag.Bool("version", false, "Print the version and build information and exit.")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: udp-procfs-exporter [flags] <processname> [port]")
+		fmt.Fprintln(flag.CommandLine.Output(), "       udp-procfs-exporter [flags] --process.<regex|cmdline-match|cmdline-regex>=<pattern> [port]")
+		fmt.Fprintln(flag.CommandLine.Output(), "       udp-procfs-exporter [flags] --target=<processname> [--target=<processname>...] [port]")
+		fmt.Fprintln(flag.CommandLine.Output(), "       udp-procfs-exporter [flags] --pid=<pid> [port]")
+		fmt.Fprintln(flag.CommandLine.Output(), "       udp-procfs-exporter [flags] --<crio|podman|lxd>.container=<name> [port]")
+		fmt.Fprintln(flag.CommandLine.Output(), "       udp-procfs-exporter [flags] --netns=<path> [port]")
+		fmt.Fprintln(flag.CommandLine.Output(), "       udp-procfs-exporter [flags] --replay=<file> [port]")
+		fmt.Fprintln(flag.CommandLine.Output(), "       udp-procfs-exporter [flags] resolve [<processname>]")
+		fmt.Fprintln(flag.CommandLine.Output(), "       udp-procfs-exporter [flags] snapshot <processname> <file>")
+		fmt.Fprintln(flag.CommandLine.Output(), "       udp-procfs-exporter diff <before file> <after file>")
+		fmt.Fprintln(flag.CommandLine.Output(), "Every flag can also be set as an environment variable, e.g. UPE_WEB_LISTEN_ADDRESS for --web.listen-address, and the arguments as UPE_TARGET_PROCESS and UPE_PORT.")
+		printVisibleDefaults()
+	}
+	flag.Parse()
+	if *showVersion {
+		printVersion(os.Stdout)
+		return
+	}
+	// Flags on the command line win over the environment, which wins over
+	// the config file.
+	args := flag.Args()
+	envArgs, err := applyEnv()
+	if err != nil {
+		fatal("Unable to apply environment", "err", err)
+	}
+	if len(args) == 0 {
+		args = envArgs
+	}
+	if *configFile != "" {
+		configArgs, err := loadConfigFile(*configFile)
+		if err != nil {
+			fatal("Unable to load config file", "err", err)
+		}
+		if len(args) == 0 {
+			args = configArgs
+		}
+		reloader = newConfigReloader(*configFile)
+	}
+	if configWatch && reloader == nil {
+		fatal("--config.watch needs --config.file")
+	}
+	if err := setupLogging(); err != nil {
+		fatal("Unable to set up logging", "err", err)
+	}
+	if matchMode != "last" && matchMode != "aggregate" && matchMode != "per-pid" {
+		fatal("Invalid --match-mode, must be last, aggregate or per-pid", "match_mode", matchMode)
+	}
+	if matchRescanInterval < 0 {
+		fatal("--match-mode.rescan-interval can't be negative")
+	}
+	if onTargetExit != "retry" && onTargetExit != "exit" && onTargetExit != "hold" {
+		fatal("Invalid --on-target-exit, must be retry, exit or hold", "on_target_exit", onTargetExit)
+	}
+	if backend != "procfs" && backend != "netlink" {
+		fatal("Invalid --backend, must be procfs or netlink", "backend", backend)
+	}
+	if pollInterval <= 0 {
+		fatal("--poll-interval must be positive")
+	}
+	intervals, err := parseTargetPollIntervals(targetIntervals)
+	if err != nil {
+		fatal("Invalid --target.poll-interval", "err", err)
+	}
+	targetPollIntervals = intervals
+	if legacyMetricNames && metricPrefix != defaultMetricPrefix {
+		fatal("--legacy-metric-names can't be combined with --metric-prefix")
+	}
+	if !validMetricPrefix.MatchString(metricPrefix) {
+		fatal("Invalid --metric-prefix, must be a valid start of a metric name", "prefix", metricPrefix)
+	}
+	if err := web.Validate(webConfigFile); err != nil {
+		fatal("Invalid --web.config.file", "err", err)
+	}
+	if seriesRetentions, err = parseRetentions(retentionOverrides); err != nil {
+		fatal("Invalid --series.retention.override", "err", err)
+	}
+	if sampleInterval < 0 {
+		fatal("--sample-interval can't be negative")
+	}
+	if _, ok := sampleBucketPresets[sampleHistogram]; sampleHistogram != "" && !ok {
+		fatal("Invalid --sample.histogram, must be bytes or ratio", "histogram", sampleHistogram)
+	}
+	if sampleHistogram == "ratio" && backend != "netlink" {
+		fatal("--sample.histogram=ratio needs --backend=netlink, the only backend to read the buffer sizes")
+	}
+	if sampleBuckets != "" {
+		if _, err := parseBuckets(sampleBuckets); err != nil {
+			fatal("Invalid --sample.histogram.buckets", "err", err)
+		}
+	}
+	if *raw {
+		// Raw and ping sockets' tables have the same columns as UDP's.
+		protocols = append(protocols, "raw", "raw6", "icmp", "icmp6")
+	}
+	if procfsPath == "" {
+		procfsPath = detectProcfs()
+	}
+	if injectFailures {
+		slog.Warn("Injecting synthetic failures, do not use in production!", "protocol", injectedProtocol)
+	}
+
+	ctx := signalContext()
+	if *showConfig {
+		dumpConfig(os.Stdout, args)
+		return
+	}
+	resolving := len(args) > 0 && args[0] == "resolve"
+	if resolving {
+		args = args[1:]
+	}
+	if len(args) == 3 && args[0] == "snapshot" {
+		os.Exit(runSnapshot(ctx, os.Stdout, args[1], args[2]))
+	}
+	if len(args) == 3 && args[0] == "diff" {
+		os.Exit(runDiff(os.Stdout, args[1], args[2]))
+	}
+
+	// --target, --process.*, --pid, container discovery, --netns and
+	// --replay replace the process name argument. The port argument predates --web.listen-address and
+	// still overrides it.
+	var container string
+	var findContainer func() (string, error)
+	for _, d := range []struct {
+		name string
+		find func() (string, error)
+	}{
+		{*crioContainer, func() (string, error) { return crioContainerPID(ctx, *crioSocket, *crioContainer) }},
+		{*podmanContainer, func() (string, error) { return podmanContainerPID(ctx, *podmanSocket, *podmanContainer) }},
+		{*lxdContainer, func() (string, error) { return lxdContainerPID(ctx, *lxdSocket, *lxdContainer) }},
+	} {
+		if d.name == "" {
+			continue
+		}
+		if container != "" {
+			fatal("Only one container discovery flag can be given")
+		}
+		container, findContainer = d.name, d.find
+	}
+	if *procPort < 0 || *procPort > 65535 {
+		fatal("Invalid --process.port", "port", *procPort)
+	}
+	procMatch, err := processMatcher(*procRegex, *cmdlineMatch, *cmdlineRegex, *procPort)
+	if err != nil {
+		fatal("Invalid process match", "err", err)
+	}
+	if procMatch != nil {
+		if *pidFlag != "" || container != "" || *sshHosts != "" || len(netnsPaths) > 0 || *replayFile != "" {
+			fatal("--process.* can't be combined with other targets")
+		}
+	}
+	if *pidFlag != "" {
+		if _, err := strconv.Atoi(*pidFlag); err != nil {
+			fatal("Invalid --pid", "pid", *pidFlag)
+		}
+		if container != "" || *sshHosts != "" || len(netnsPaths) > 0 || *replayFile != "" {
+			fatal("--pid can't be combined with other targets")
+		}
+	}
+	if *cgroupPath != "" {
+		if len(targetNames) > 0 || procMatch != nil || *pidFlag != "" || container != "" || *sshHosts != "" || len(netnsPaths) > 0 || *replayFile != "" {
+			fatal("--cgroup can't be combined with other targets")
+		}
+	}
+	kubernetes := *k8sPod != "" || *k8sSelector != ""
+	if kubernetes {
+		if len(targetNames) > 0 || procMatch != nil || *pidFlag != "" || container != "" || *sshHosts != "" || len(netnsPaths) > 0 || *replayFile != "" || *cgroupPath != "" {
+			fatal("--kubernetes.* can't be combined with other targets")
+		}
+		if *k8sPod != "" && *k8sSelector != "" {
+			fatal("Only one of --kubernetes.pod and --kubernetes.selector can be given")
+		}
+	}
+	procName, port := "", ""
+	noProcName := len(targetNames) > 0 || procMatch != nil || *pidFlag != "" || container != "" || len(netnsPaths) > 0 || *replayFile != "" || kubernetes || *cgroupPath != ""
+	if !noProcName && len(args) > 0 {
+		procName, args = args[0], args[1:]
+	}
+	if len(args) > 0 {
+		port, args = args[0], args[1:]
+	}
+	if len(args) > 0 || (!noProcName && procName == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if port != "" {
+		*listenAddress = ":" + port
+	}
+	sel := localSelection{
+		container:     container,
+		findContainer: findContainer,
+		pid:           *pidFlag,
+		cgroup:        *cgroupPath,
+		match:         procMatch,
+		procName:      procName,
+	}
+	if resolving {
+		if len(targetNames) > 0 || *sshHosts != "" || len(netnsPaths) > 0 || *replayFile != "" || kubernetes {
+			fatal("resolve only supports a process name, --process.*, --pid, --cgroup and container discovery")
+		}
+		os.Exit(runResolve(ctx, os.Stdout, sel))
+	}
+	if container != "" {
+		prometheus.DefaultRegisterer = prometheus.WrapRegistererWith(prometheus.Labels{"container": container}, prometheus.DefaultRegisterer)
+	}
+	if len(staticLabels) > 0 {
+		labels, err := parseStaticLabels(staticLabels)
+		if err != nil {
+			fatal("Invalid --label", "err", err)
+		}
+		prometheus.DefaultRegisterer = prometheus.WrapRegistererWith(labels, prometheus.DefaultRegisterer)
+	}
+
+	if *vmURL != "" {
+		go pushVictoriaMetrics(ctx, *vmURL, vmLabels, *pushInterval)
+	}
+	if *gatewayURL != "" {
+		go pushGateway(ctx, *gatewayURL, *gatewayJob, gatewayGrouping, *pushInterval)
+	}
+	if *remoteWriteURL != "" {
+		go pushRemoteWrite(ctx, *remoteWriteURL, remoteWriteHeaders, *pushInterval)
+	}
+	if *otlpEndpoint != "" {
+		go pushOTLP(ctx, *otlpEndpoint, otlpHeaders, *pushInterval)
+	}
+	if *graphiteAddress != "" {
+		go sendGraphite(ctx, *graphiteAddress, *graphitePrefix, pollInterval)
+	}
+	if *influxURL != "" {
+		go pushInflux(ctx, *influxURL, influxHeaders, *pushInterval)
+	}
+	if *statsdAddress != "" {
+		go emitStatsd(ctx, *statsdAddress, *pushInterval)
+	}
+	if *textfileDir != "" {
+		go writeTextfiles(ctx, *textfileDir, *pushInterval)
+	}
+
+	if *replayFile != "" {
+		if *sshHosts != "" || container != "" || len(netnsPaths) > 0 || *recordFile != "" {
+			fatal("--replay can't be combined with other targets or --record")
+		}
+		t, err := newReplayTarget(*replayFile)
+		if err != nil {
+			fatal("Unable to read replay archive", "err", err)
+		}
+		targetProcName = *replayFile
+		t.sdLabels = map[string]string{"process": *replayFile}
+		registerMetrics(nil)
+		t.setState(stateDiscovering)
+		watchedTargets = append(watchedTargets, t)
+		t.start(ctx)
+		serveHTTP(ctx, *listenAddress, *telemetryPath)
+		return
+	}
+	if *recordFile != "" && (*sshHosts != "" || len(netnsPaths) > 0) {
+		fatal("--record only supports watching a local process or container")
+	}
+	if *recordFile != "" && backend == "netlink" {
+		fatal("--record saves the procfs tables, so needs --backend=procfs")
+	}
+
+	if len(netnsPaths) > 0 {
+		if *sshHosts != "" || container != "" {
+			fatal("--netns can't be combined with --ssh.hosts or container discovery")
+		}
+		if *gate > 0 {
+			fatal("--fail-on-drops can't be combined with --netns")
+		}
+		if runtime.GOOS != "linux" {
+			fatal("Network namespaces are only supported on linux!")
+		}
+		// The namespaces share the exporter's kernel.
+		detectFeatures()
+		registerMetrics([]string{"netns"})
+		dynamicTargets = &targetSource{"netns", func(ctx context.Context, path string) ([]*target, error) {
+			if _, err := os.Stat(path); err != nil {
+				return nil, err
+			}
+			return []*target{newNetNSTarget(path)}, nil
+		}}
+		for _, path := range netnsPaths {
+			t := newNetNSTarget(path)
+			t.setState(stateDiscovering)
+			watchedTargets = append(watchedTargets, t)
+			t.start(ctx)
+		}
+		slog.Info("UDP Procfs Exporter started", "netns", netnsPaths.String())
+		serveHTTP(ctx, *listenAddress, *telemetryPath)
+		return
+	}
+
+	if *sshHosts != "" {
+		if *gate > 0 {
+			fatal("--fail-on-drops can't be combined with --ssh.hosts")
+		}
+		if container != "" {
+			fatal("Container discovery can't be combined with --ssh.hosts")
+		}
+		targetProcName = procName
+		registerMetrics([]string{"host"})
+		for _, host := range strings.Split(*sshHosts, ",") {
+			host = strings.TrimSpace(host)
+			t := newSSHTarget(host, *sshUser, *sshIdentity, targetProcName)
+			t.entry = host
+			t.setState(stateDiscovering)
+			watchedTargets = append(watchedTargets, t)
+			t.start(ctx)
+		}
+		slog.Info("UDP Procfs Exporter started", "process", targetProcName, "hosts", *sshHosts)
+		serveHTTP(ctx, *listenAddress, *telemetryPath)
+		return
+	}
+
 	if runtime.GOOS != "linux" {
-		log.Fatalln("ProcFS is only supported on linux!")
+		fatal("ProcFS is only supported on linux!")
+	}
+	detectFeatures()
+
+	var pidLabel []string
+	if matchMode == "per-pid" {
+		if *gate > 0 || *recordFile != "" {
+			fatal("--fail-on-drops and --record don't support --match-mode=per-pid")
+		}
+		pidLabel = []string{"pid"}
+	}
+
+	if kubernetes {
+		if *gate > 0 || *recordFile != "" {
+			fatal("--fail-on-drops and --record don't support --kubernetes.*")
+		}
+		if *ethtool || *qdisc || *skmem || *peers || *sockets || *tcp || *unixDgram || *ebpf || *snmp || *netstat || *sockstat || *softnet || *sysctl || *process {
+			fatal("The optional collectors only support a single target")
+		}
+		d, err := newKubeletDiscovery(*kubeletURL, *k8sNamespace, *k8sPod, *k8sSelector, *k8sContainer, *kubeletInsecure)
+		if err != nil {
+			fatal("Invalid --kubernetes.selector", "err", err)
+		}
+		registerMetrics(kubernetesLabels)
+		if err := d.sync(ctx); err != nil {
+			fatal("Unable to list pods from the kubelet", "err", err)
+		}
+		slog.Info("UDP Procfs Exporter started", "kubelet", *kubeletURL, "containers", len(currentTargets()))
+		go watchKubernetes(ctx, d, *k8sRefresh)
+		serveHTTP(ctx, *listenAddress, *telemetryPath)
+		return
+	}
+
+	if len(targetNames) > 0 {
+		if procMatch != nil || *pidFlag != "" || container != "" {
+			fatal("--target can't be combined with other targets")
+		}
+		if *gate > 0 || *recordFile != "" {
+			fatal("--fail-on-drops and --record only support a single target")
+		}
+		if *ethtool || *qdisc || *skmem || *peers || *sockets || *tcp || *unixDgram || *ebpf || *snmp || *netstat || *sockstat || *softnet || *sysctl || *process {
+			fatal("The optional collectors only support a single target")
+		}
+		registerMetrics(append([]string{"process"}, pidLabel...))
+		findTargets := func(ctx context.Context, name string) ([]*target, error) {
+			targets, _, err := matchTargets(ctx, func(_ *procWalk, _, comm string) bool { return comm == name }, []string{name})
+			if err != nil {
+				return nil, err
+			}
+			if len(targets) == 0 {
+				return nil, fmt.Errorf("no process named %q", name)
+			}
+			for _, t := range targets {
+				t.entry = name
+				t.observeResolution(true)
+			}
+			return targets, nil
+		}
+		dynamicTargets = &targetSource{"target", findTargets}
+		for _, name := range targetNames {
+			targets, err := findTargets(ctx, name)
+			if err != nil && ctx.Err() != nil {
+				// Interrupted while starting up.
+				return
+			}
+			if err != nil {
+				fatal("Unable to find proc with the name", "process", name, "err", err)
+			}
+			for _, t := range targets {
+				t.setState(stateDiscovering)
+				watchedTargets = append(watchedTargets, t)
+				slog.Info("UDP Procfs Exporter started", "process", name, "pid", t.sdLabels["pid"])
+				t.start(ctx)
+			}
+			addPIDGroups(targets)
+		}
+		if matchMode == "per-pid" && matchRescanInterval > 0 {
+			go watchPIDGroups(ctx, matchRescanInterval)
+		}
+		serveHTTP(ctx, *listenAddress, *telemetryPath)
+		return
+	}
+
+	locals, pid, name, err := selectLocalTargets(ctx, sel)
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		fatal("Unable to find the target", "err", err)
 	}
-	args := os.Args
-	if len(args) != 3 {
-		log.Fatalln("Usage: udp-procfs-exporter <processname> <port to expose for scraping>")
+	targetPID, targetProcName = pid, name
+	if sel.container != "" || sel.pid != "" || sel.cgroup != "" {
+		// They're a single target whatever --match-mode says.
+		pidLabel = nil
+	}
+	// The single-process collectors follow the only target to its new PID.
+	if rediscover := locals[0].rediscover; len(locals) == 1 && rediscover != nil {
+		locals[0].rediscover = func(ctx context.Context) ([]string, error) {
+			pids, err := rediscover(ctx)
+			if len(pids) > 0 {
+				setTargetPID(pids[len(pids)-1])
+			}
+			return pids, err
+		}
+	}
+	if members := locals[0].members; len(locals) == 1 && members != nil {
+		locals[0].members = func() []string {
+			pids := members()
+			if len(pids) > 0 {
+				setTargetPID(pids[len(pids)-1])
+			}
+			return pids
+		}
 	}
 
-	findPIDByName(args[1])
-	if targetPID == "" {
-		log.Fatalln("Unable to find proc with the name: " + targetProcName)
+	// Constant labels have to be in place before anything registers.
+	ecs, err := ecsLabels(ctx, targetPID)
+	if err != nil {
+		slog.Warn("Unable to read ECS task metadata", "err", err)
+	}
+	if len(ecs) > 0 {
+		prometheus.DefaultRegisterer = prometheus.WrapRegistererWith(ecs, prometheus.DefaultRegisterer)
+	}
+	if *recordFile != "" {
+		recording, err = newRecorder(*recordFile)
+		if err != nil {
+			fatal("Unable to create recording", "err", err)
+		}
+	}
+	registerMetrics(pidLabel)
+	for _, t := range locals {
+		t.setState(stateDiscovering)
+		t.observeResolution(true)
+	}
+	if *gate > 0 {
+		os.Exit(failOnDrops(ctx, locals[0], *gate))
+	}
+	slog.Info("UDP Procfs Exporter started", "process", targetProcName, "pid", locals[0].sdLabels["pid"])
+	watchedTargets = append(watchedTargets, locals...)
+	addPIDGroups(locals)
+	if *ethtool {
+		include, err := regexp.Compile(*ethtoolInclude)
+		if err != nil {
+			fatal("Invalid --collector.ethtool.metrics-include", "err", err)
+		}
+		go watchEthtool(ctx, include)
+	}
+	if *qdisc {
+		go watchQdiscs(ctx)
+	}
+	if *skmem {
+		go watchSkmem(ctx)
+	}
+	if *peers {
+		go watchPeers(ctx)
+	}
+	if *sockets {
+		go watchSockets(ctx)
+	}
+	if *tcp {
+		go watchTCP(ctx)
+	}
+	if *unixDgram {
+		go watchUnixSockets(ctx)
+	}
+	if *ebpf {
+		go watchEBPF(ctx)
+	}
+	if *process {
+		prometheus.MustRegister(newProcessCollector())
 	}
-	fmt.Println("UDP Procfs Exporter started, watching PID " + targetPID)
-	go serveHTTP(":"+args[2], "/metrics")
-	watchUDPBuffers(0, 0)
+	if *snmp {
+		prometheus.MustRegister(newSNMPCollector())
+	}
+	if *sockstat {
+		prometheus.MustRegister(newSockstatCollector())
+	}
+	if *softnet {
+		prometheus.MustRegister(newSoftnetCollector())
+	}
+	if *sysctl {
+		prometheus.MustRegister(newSysctlCollector())
+	}
+	if *netstat {
+		include, err := regexp.Compile(*netstatFields)
+		if err != nil {
+			fatal("Invalid --collector.netstat.fields", "err", err)
+		}
+		prometheus.MustRegister(&netstatCollector{include: include})
+	}
+	for _, t := range locals {
+		t.start(ctx)
+	}
+	if len(pidGroups) > 0 && matchRescanInterval > 0 {
+		go watchPIDGroups(ctx, matchRescanInterval)
+	}
+	serveHTTP(ctx, *listenAddress, *telemetryPath)
 }
 
-func serveHTTP(listenAddress, metricsEndpoint string) {
-	//lint:ignore SA1019 prometheus.Handler() is deprecated.
-	http.Handle(metricsEndpoint, promhttp.Handler())
-	log.Fatal(http.ListenAndServe(listenAddress, nil))
+// signalContext returns a context that is cancelled on SIGINT or SIGTERM, so
+// collection and the HTTP server can wind down. A second signal kills the
+// exporter outright.
+func signalContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		signal.Stop(c)
+		slog.Info("Shutting down")
+		cancel()
+	}()
+	return ctx
 }
 
-func findPIDByName(procName string) {
-	targetProcName = procName
-	err := filepath.Walk("/proc", walkProcFSStatus)
+// sleepContext sleeps for d, returning false early if ctx is done first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// serveHTTP serves metricsEndpoint and the API on listenAddress until ctx is
+// done, then waits up to 5 seconds for requests in flight to finish.
+func serveHTTP(ctx context.Context, listenAddress, metricsEndpoint string) {
+	// Each scrape gathers with its own context, so that it stops polling
+	// when the scraper gives up.
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g := gatherer(r.Context())
+		h := promhttp.HandlerFor(g, promhttp.HandlerOpts{})
+		if enableOpenMetrics {
+			h = openMetricsHandler(g, h)
+		}
+		h.ServeHTTP(w, r)
+	})
+	mux := http.NewServeMux()
+	mux.Handle(metricsEndpoint, promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, handler))
+	mux.HandleFunc("/api/v1/sd", serveSD)
+	mux.HandleFunc("/api/v1/cardinality", serveCardinality)
+	mux.HandleFunc("/api/v1/stats", serveStats)
+	mux.HandleFunc("/api/v1/targets", serveTargets(ctx))
+	mux.HandleFunc("/api/v1/targets/", serveTargets(ctx))
+	mux.HandleFunc("/-/healthy", serveHealthy)
+	mux.HandleFunc("/-/ready", serveReady)
+	mux.HandleFunc("/-/reload", serveReload(ctx))
+	mux.HandleFunc("/probe", serveProbe)
+	if metricsEndpoint != "/" {
+		mux.HandleFunc("/", landingPage(metricsEndpoint))
+	}
+	if enablePprof {
+		registerPprof(mux)
+	}
+
+	if reloader != nil {
+		go watchReloads(ctx)
+	}
+	if configWatch {
+		if err := watchConfigFile(ctx); err != nil {
+			fatal("Unable to watch config file", "err", err)
+		}
+	}
+
+	srv := &http.Server{Handler: mux}
+	stopped := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+		close(stopped)
+	}()
+
+	if !webSystemdSocket && listenAddress == "" {
+		// Metrics only go to --output.textfile-dir or the push sinks.
+		<-stopped
+		return
+	}
+	// The toolkit serves TLS and checks basic auth per --web.config.file,
+	// rereading it for every new connection.
+	flags := &web.FlagConfig{
+		WebListenAddresses: &[]string{listenAddress},
+		WebSystemdSocket:   &webSystemdSocket,
+		WebConfigFile:      &webConfigFile,
+	}
+	if err := web.ListenAndServe(srv, flags, slog.Default()); err != http.ErrServerClosed {
+		fatal("Unable to serve HTTP", "err", err)
+	}
+	<-stopped
+}
+
+// localSelection is how the flags choose the local targets.
+type localSelection struct {
+	// container is the name given to container discovery, which
+	// findContainer looks up the PID of.
+	container     string
+	findContainer func() (string, error)
+	pid, cgroup   string
+	// match is the --process.* matcher, nil to match procName.
+	match    func(walk *procWalk, pid, name string) bool
+	procName string
+}
+
+// selectLocalTargets returns the targets sel chooses, the PID the
+// single-process collectors start on and the name the targets are known by.
+// The resolve subcommand uses it too, so it reports what would be watched.
+func selectLocalTargets(ctx context.Context, sel localSelection) ([]*target, string, string, error) {
+	switch {
+	case sel.container != "":
+		pid, err := sel.findContainer()
+		if err != nil {
+			return nil, "", "", fmt.Errorf("unable to find container %q: %v", sel.container, err)
+		}
+		t := newProcessTarget(sel.container, pid, nil)
+		t.rediscover = func(context.Context) ([]string, error) {
+			if pid, err := sel.findContainer(); err == nil {
+				return []string{pid}, nil
+			}
+			return nil, nil
+		}
+		return []*target{t}, pid, sel.container, nil
+	case sel.pid != "":
+		status, err := ioutil.ReadFile(procPath(sel.pid, "status"))
+		if err != nil {
+			return nil, "", "", fmt.Errorf("unable to find PID %s: %v", sel.pid, err)
+		}
+		name := statusName(status)
+		return []*target{newProcessTarget(name, sel.pid, nil)}, sel.pid, name, nil
+	case sel.cgroup != "":
+		t, err := newCgroupTarget(sel.cgroup)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("unable to read cgroup %s: %v", sel.cgroup, err)
+		}
+		pids := t.currentPIDs()
+		if len(pids) == 0 {
+			return nil, "", "", fmt.Errorf("no processes in cgroup %s", sel.cgroup)
+		}
+		return []*target{t}, pids[len(pids)-1], t.name, nil
+	}
+
+	match := sel.match
+	if match == nil {
+		match = func(_ *procWalk, _, name string) bool { return name == sel.procName }
+	}
+	targets, pid, err := matchTargets(ctx, match, nil)
 	if err != nil {
-		if err == io.EOF {
-			// Not an error, just a signal when we are done
-			err = nil
-		} else {
-			log.Fatal(err)
+		return nil, "", "", err
+	}
+	if len(targets) == 0 {
+		if sel.match != nil {
+			return nil, "", "", fmt.Errorf("no process matches --process.*")
+		}
+		return nil, "", "", fmt.Errorf("no process named %q", sel.procName)
+	}
+	return targets, pid, targets[0].name, nil
+}
+
+// findPIDByName returns the PID of the last process named procName, or ""
+// if there is none. The walk is abandoned once ctx is done.
+func findPIDByName(ctx context.Context, procName string) (string, error) {
+	pid, _, err := findPID(ctx, func(_ *procWalk, _, name string) bool { return name == procName })
+	return pid, err
+}
+
+// findPID returns the PID and status Name: of the last process for which
+// match returns true, or "" if there is none.
+func findPID(ctx context.Context, match func(walk *procWalk, pid, name string) bool) (string, string, error) {
+	found, err := findPIDs(ctx, match)
+	if err != nil || len(found) == 0 {
+		return "", "", err
+	}
+	return found[len(found)-1].pid, found[len(found)-1].name, nil
+}
+
+// foundProcess is a process findPIDs matched.
+type foundProcess struct {
+	pid, name string
+}
+
+// procWalk is what matchers keep for the rest of one findPIDs walk of
+// procfs, such as --process.port's net tables by network namespace. Each walk
+// has its own, so walks running at once don't share them.
+type procWalk struct {
+	boundInodes map[string]map[string]bool
+}
+
+// findPIDs returns every process for which match, given the walk, its PID
+// and status Name:, returns true, in the order the walk visited them. The
+// walk is abandoned with ctx's error once ctx is done, e.g. on shutdown or
+// when a /probe request goes away.
+func findPIDs(ctx context.Context, match func(walk *procWalk, pid, name string) bool) ([]foundProcess, error) {
+	var found []foundProcess
+	walk := &procWalk{boundInodes: make(map[string]map[string]bool)}
+	err := filepath.Walk(procfsPath, func(path string, info os.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
+		return walkProcFSStatus(path, info, err, func(pid, name string) {
+			if match(walk, pid, name) {
+				found = append(found, foundProcess{pid, name})
+			}
+		})
+	})
+	switch {
+	case err == nil, err == io.EOF:
+		// io.EOF isn't an error, just a signal when we are done
+		return found, nil
+	case err == ctx.Err():
+		return nil, err
 	}
+	return nil, fmt.Errorf("unable to walk procfs: %v", err)
 }
 
-func walkProcFSStatus(path string, info os.FileInfo, err error) error {
+func walkProcFSStatus(path string, info os.FileInfo, err error, visit func(pid, name string)) error {
 	if err != nil {
 		// All of these are garbage that I can find, we just need to skip any known error'd files
 		return nil
 	}
 
-	if strings.Contains(path, "/status") && strings.Contains(path, "/proc/") && strings.Count(path, "/") == 3 {
-		pid, err := strconv.Atoi(path[6:strings.LastIndex(path, "/")])
+	if filepath.Base(path) == "status" && filepath.Dir(filepath.Dir(path)) == filepath.Clean(procfsPath) {
+		pid, err := strconv.Atoi(filepath.Base(filepath.Dir(path)))
 		if err != nil {
 			return err
 		}
 
 		f, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) || errors.Is(err, syscall.ESRCH) {
+			// The process exited since its directory was listed.
+			return nil
+		}
 		if err != nil {
 			return err
 		}
 
-		// First line of procfs status files looks like..
-		// Name:	<proc name>
-		name := string(f[6:bytes.IndexByte(f, '\n')])
-
-		if name == targetProcName {
-			targetPID = strconv.Itoa(pid)
+		name := statusName(f)
+		if name == "" {
+			return nil
 		}
+		visit(strconv.Itoa(pid), name)
 	}
 
 	return nil
 }
 
-func watchUDPBuffers(lastDropped int, lastDropped6 int) {
+// statusName returns the process name from a /proc/<pid>/status file, or ""
+// if it's cut short, e.g. by the process exiting as it was read.
+func statusName(status []byte) string {
+	// First line of procfs status files looks like..
+	// Name:	<proc name>
+	end := bytes.IndexByte(status, '\n')
+	if end < 6 || !bytes.HasPrefix(status, []byte("Name:\t")) {
+		return ""
+	}
+	return string(status[6:end])
+}
+
+// start watches t in the background until ctx is done or t.stop is called.
+func (t *target) start(ctx context.Context) {
+	ctx, t.stop = context.WithCancel(ctx)
+	t.done = make(chan struct{})
+	go func() {
+		defer close(t.done)
+		watchUDPBuffers(ctx, t)
+	}()
+}
+
+// watchUDPBuffers polls t every t.currentPollInterval() until ctx is done.
+// With --collection.on-scrape, t is only polled by scrapes instead.
+func watchUDPBuffers(ctx context.Context, t *target) {
+	if sampleInterval > 0 {
+		var sampling sync.WaitGroup
+		sampling.Add(1)
+		go func() {
+			defer sampling.Done()
+			sampleQueues(ctx, t)
+		}()
+		defer sampling.Wait()
+	}
+	if pollOnScrape {
+		return
+	}
 	for {
-		queuedUDP, droppedUDP := parseProcfsNetFile("/proc/" + targetPID + "/net/udp")
-		label := "udp"
+		t.poll(ctx)
 
-		udpBufferQueued.WithLabelValues(label).Set(float64(queuedUDP))
+		if !sleepContext(ctx, t.currentPollInterval()) {
+			return
+		}
+	}
+}
 
-		diff := droppedUDP - lastDropped
-		if diff < 0 {
-			fmt.Println("Dropped count went negative! Abandoning UDP buffer parsing")
-			diff = 0
-			droppedUDP = lastDropped
+// poll reads t's tables once and exports what's in them.
+func (t *target) poll(ctx context.Context) {
+	start := time.Now()
+	state := stateCollecting
+	missing := 0
+	parsed := false
+	var tables []polledTable
+	raw := make(map[string][]byte, len(protocols))
+	seen := make(map[string]bool)
+	if t.members != nil {
+		t.refreshMembers()
+	} else if t.rescan && matchRescanInterval > 0 && time.Since(t.rescannedAt) >= matchRescanInterval {
+		if err := t.rescanPIDs(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("Unable to look for new matching processes", "target", t.name, "err", err)
+		}
+	}
+	owned := t.pollOwnedInodes()
+	for _, protocol := range protocols {
+		var table bytes.Buffer
+		queued, txQueued, dropped, rcvbuf, err := parseProcfsNetFile(ctx, t, protocol, seen, owned, &table)
+		if err == nil {
+			// Nothing copied means sock_diag was read instead.
+			raw[protocol] = table.Bytes()
 		}
-		udpBufferDropped.WithLabelValues(label).Add(float64(diff))
+		// Exported from the first poll on, so no errors reads as 0.
+		errs := parseErrors.WithLabelValues(append(append([]string{}, t.labels...), protocol)...)
+		switch {
+		case err == nil:
+			parsed = true
+		case ctx.Err() != nil:
+			// Stopped, or the scrape polling t gave up, which
+			// isn't the table's fault.
+		case os.IsNotExist(err):
+			// Tables like udp6 are absent when the kernel lacks
+			// IPv6 (or udplite without UDP-Lite), so only all of
+			// them missing means the target is.
+			missing++
+		case os.IsPermission(err):
+			state = statePermissionDenied
+		default:
+			slog.Warn("Unable to read table", "target", t.name, "protocol", protocol, "err", err)
+			errs.Inc()
+			if state == stateCollecting {
+				state = stateDegraded
+			}
+		}
+		tables = append(tables, polledTable{protocol, queued, dropped, protocolTotals{Queued: queued, TxQueued: txQueued, Dropped: dropped}})
+		if err == nil && rcvbuf >= 0 {
+			t.recordUtilization(protocol, queued, rcvbuf)
+		}
+	}
+	if ctx.Err() != nil {
+		// Leave what the last whole poll exported.
+		return
+	}
+	if missing == len(protocols) {
+		state = stateTargetAbsent
+	}
+	// With --on-target-exit=hold, a target that's gone keeps exporting
+	// what it last had rather than zeros (as it does until exiting with
+	// exit).
+	hold := state == stateTargetAbsent && onTargetExit != "retry"
+	totals := make(map[string]protocolTotals, len(protocols))
+	if !hold {
+		for _, table := range tables {
+			t.record(table.protocol, table.queued, table.dropped)
+			totals[table.protocol] = table.totals
+			udpBufferTxQueued.WithLabelValues(append(append([]string{}, t.labels...), table.protocol)...).Set(float64(table.totals.TxQueued))
+			t.exportedFor(table.protocol).txQueued = float64(table.totals.TxQueued)
+		}
+	}
+	t.rebaseDrops = false
+	t.stateMu.Lock()
+	if !hold {
+		t.totals = totals
+		t.tables, t.owned = raw, owned
+	}
+	t.lastPoll = time.Now()
+	t.parsed = t.parsed || parsed
+	t.stateMu.Unlock()
+	t.observePoll(start, parsed)
+	t.setState(state)
+	if state == stateTargetAbsent && onTargetExit == "exit" {
+		fatal("Target exited", "target", t.name)
+	}
+	if state == stateTargetAbsent && t.rediscover != nil {
+		// Being stopped mid-walk isn't worth a word; the watch loop
+		// returns as soon as this poll does.
+		if err := t.rediscoverPIDs(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("Unable to look for the target again", "target", t.name, "err", err)
+		}
+	}
+	if state != stateTargetAbsent {
+		t.absentSince = time.Time{}
+	} else if t.expire && onTargetExit == "retry" {
+		if t.absentSince.IsZero() {
+			t.absentSince = time.Now()
+		}
+		if time.Since(t.absentSince) >= retention("per-pid") {
+			t.expire = false
+			// Stopping t waits for this poll's loop to return.
+			go expireTarget(t)
+		}
+	}
+	if injectFailures {
+		t.injectFailure()
+	}
+	t.polls++
+}
 
-		queuedUDP6, droppedUDP6 := parseProcfsNetFile("/proc/" + targetPID + "/net/udp6")
-		label = "udp6"
+// polledTable is what a poll read from one of the tables.
+type polledTable struct {
+	protocol        string
+	queued, dropped int
+	totals          protocolTotals
+}
 
-		udpBufferQueued.WithLabelValues(label).Set(float64(queuedUDP6))
+// record exports one poll's queued and dropped totals for protocol.
+func (t *target) record(protocol string, queued, dropped int) {
+	if t.lastDropped == nil {
+		t.lastDropped = make(map[string]int, len(protocols))
+	}
+	labels := append(append([]string{}, t.labels...), protocol)
 
-		diff = droppedUDP6 - lastDropped6
-		if diff < 0 {
-			fmt.Println("Dropped count went negative! Abandoning UDP buffer parsing")
-			diff = 0
-			droppedUDP6 = lastDropped6
-		}
-		udpBufferDropped.WithLabelValues(label).Add(float64(diff))
+	udpBufferQueued.WithLabelValues(labels...).Set(float64(queued))
+	udpBufferQueuedPeak.observe(labels, float64(queued))
+	t.observeQueued(labels, protocol, float64(queued))
+	t.observeEWMA(labels, protocol, float64(queued))
+	if sampleHistogram == "bytes" {
+		udpBufferSampledHistogram.WithLabelValues(labels...).Observe(float64(queued))
+	}
 
-		time.Sleep(10 * time.Second)
-		lastDropped = droppedUDP
-		lastDropped6 = droppedUDP6
+	previous, polled := t.lastDropped[protocol]
+	if t.rebaseDrops {
+		// Processes that joined or left brought or took their sockets'
+		// drops, which weren't dropped this poll.
+		previous = dropped
+	}
+	diff := dropped - previous
+	if diff < 0 {
+		slog.Warn("Dropped count went negative! Abandoning UDP buffer parsing", "target", t.name, "protocol", protocol)
+		diff = 0
+		dropped = previous
+	}
+	udpBufferDropped.WithLabelValues(labels...).Add(float64(diff))
+	t.exportedFor(protocol).add(queued, diff)
+	lastDrops.observe(labels, diff)
+	if polled {
+		// The first poll's diff is everything dropped before we
+		// started, which is no interval's worth.
+		udpBufferDroppedPeak.observe(labels, float64(diff))
+		udpBufferDroppedLastInterval.WithLabelValues(labels...).Set(float64(diff))
+	} else {
+		udpBufferDroppedLastInterval.WithLabelValues(labels...).Set(0)
 	}
+	t.lastDropped[protocol] = dropped
 }
 
-func parseProcfsNetFile(filename string) (int, int) {
-	f, err := os.Open(filename)
+// parseProcfsNetFile sums t's protocol table as parseProcfsNet does. rcvbuf is
+// the sum of the counted sockets' receive buffer sizes when the netlink
+// backend read them, and -1 when the table came from procfs, which doesn't
+// have them. table, unless nil, gets a copy of a table read from procfs.
+func parseProcfsNetFile(ctx context.Context, t *target, protocol string, seen, owned map[string]bool, table *bytes.Buffer) (queued, txQueued, dropped, rcvbuf int, err error) {
+	if backend == "netlink" {
+		if queued, txQueued, dropped, rcvbuf, ok, err := parseDiagSockets(t, protocol, seen, owned); ok {
+			return queued, txQueued, dropped, rcvbuf, err
+		}
+	}
+
+	f, err := t.open(ctx, protocol)
 	if err != nil {
-		return 0, 0
+		return 0, 0, 0, -1, err
 	}
 	defer f.Close()
 
+	r := t.sampledReader(protocol, f)
+	if recording != nil {
+		r = recording.tee(t.polls, protocol, r)
+	}
+	if table != nil {
+		r = io.TeeReader(r, table)
+	}
+	queued, txQueued, dropped, err = parseProcfsNet(r, seen, owned)
+	return queued, txQueued, dropped, -1, err
+}
+
+// parseProcfsNet sums the rx_queue, tx_queue and drops columns of a procfs UDP
+// table.
+// Sockets whose inode is already in seen are skipped and the rest are added
+// to it, so sharing one seen set across the tables of a poll counts a socket
+// listed more than once, e.g. in each of the tables of several processes in
+// one network namespace, only once. (A dual-stack socket is only listed in
+// udp6.) If owned isn't nil, only sockets whose inode is in it are counted.
+func parseProcfsNet(r io.Reader, seen, owned map[string]bool) (int, int, int, error) {
 	queued := 0
+	txQueued := 0
 	dropped := 0
-	s := bufio.NewScanner(f)
+	s := bufio.NewScanner(r)
 	for n := 0; s.Scan(); n++ {
 		// Skip the header lines.
 		if n < 1 {
@@ -160,21 +1362,44 @@ func parseProcfsNetFile(filename string) (int, int) {
 		}
 
 		fields := strings.Fields(s.Text())
+		// Skip malformed lines rather than index past their end, e.g. a
+		// replayed or SSH-read table cut short.
+		if len(fields) < 13 {
+			continue
+		}
 
-		queuedLine, err := strconv.ParseInt(strings.Split(fields[4], ":")[1], 16, 32)
+		if owned != nil && !owned[fields[9]] {
+			continue
+		}
+		if inode := fields[9]; inode != "0" {
+			if seen[inode] {
+				continue
+			}
+			seen[inode] = true
+		}
+
+		queues := strings.Split(fields[4], ":")
+		if len(queues) != 2 {
+			return 0, 0, 0, fmt.Errorf("unable to parse UDP buffer queues %q", fields[4])
+		}
+		queuedLine, err := strconv.ParseInt(queues[1], 16, 32)
 		queued = queued + int(queuedLine)
 		if err != nil {
-			fmt.Println("Unable to parse queued UDP buffers:", err)
-			return 0, 0
+			return 0, 0, 0, fmt.Errorf("unable to parse queued UDP buffers: %v", err)
+		}
+
+		txQueuedLine, err := strconv.ParseInt(queues[0], 16, 32)
+		txQueued = txQueued + int(txQueuedLine)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("unable to parse tx queued UDP buffers: %v", err)
 		}
 
 		droppedLine, err := strconv.Atoi(fields[12])
 		dropped = dropped + droppedLine
 		if err != nil {
-			fmt.Println("Unable to parse dropped UDP buffers:", err)
-			return 0, 0
+			return 0, 0, 0, fmt.Errorf("unable to parse dropped UDP buffers: %v", err)
 		}
 	}
 
-	return queued, dropped
+	return queued, txQueued, dropped, s.Err()
 }
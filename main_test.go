@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const procfsHeader = "   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops            \n"
+
+func TestParseProcfsNet(t *testing.T) {
+	tests := []struct {
+		name                      string
+		table                     string
+		seen, owned               map[string]bool
+		queued, txQueued, dropped int
+		wantErr                   bool
+		seenAfter                 []string
+	}{
+		{
+			name:  "header only",
+			table: procfsHeader,
+		},
+		{
+			name: "sums every socket",
+			table: procfsHeader +
+				" 1229: 00000000:EB55 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 504549 2 0000000058df152d 0        \n" +
+				" 2873: 0100007F:11C1 00000000:0000 07 00000010:00001D40 00:00000000 00000000     0        0 504547 2 0000000013db98ee 91       \n" +
+				" 2874: 0100007F:11C2 00000000:0000 07 00000000:00000100 00:00000000 00000000     0        0 504548 2 0000000013db98ef 9        \n",
+			queued:    0x1d40 + 0x100,
+			txQueued:  0x10,
+			dropped:   100,
+			seenAfter: []string{"504547", "504548", "504549"},
+		},
+		{
+			name: "skips sockets already seen",
+			table: procfsHeader +
+				" 2873: 0100007F:11C1 00000000:0000 07 00000000:00001D40 00:00000000 00000000     0        0 504547 2 0000000013db98ee 91       \n" +
+				" 2874: 0100007F:11C2 00000000:0000 07 00000000:00000100 00:00000000 00000000     0        0 504548 2 0000000013db98ef 9        \n",
+			seen:    map[string]bool{"504547": true},
+			queued:  0x100,
+			dropped: 9,
+		},
+		{
+			name: "only counts owned sockets",
+			table: procfsHeader +
+				" 2873: 0100007F:11C1 00000000:0000 07 00000000:00001D40 00:00000000 00000000     0        0 504547 2 0000000013db98ee 91       \n" +
+				" 2874: 0100007F:11C2 00000000:0000 07 00000000:00000100 00:00000000 00000000     0        0 504548 2 0000000013db98ef 9        \n",
+			owned:   map[string]bool{"504548": true},
+			queued:  0x100,
+			dropped: 9,
+		},
+		{
+			name: "counts every socket with no inode",
+			table: procfsHeader +
+				" 2873: 0100007F:11C1 00000000:0000 07 00000000:00000001 00:00000000 00000000     0        0 0 2 0000000013db98ee 1        \n" +
+				" 2874: 0100007F:11C2 00000000:0000 07 00000000:00000001 00:00000000 00000000     0        0 0 2 0000000013db98ef 1        \n",
+			queued:  2,
+			dropped: 2,
+		},
+		{
+			name: "skips short lines",
+			table: procfsHeader +
+				" 2873: 0100007F:11C1 00000000:0000 07 00000000:00001D40\n" +
+				" 2874: 0100007F:11C2 00000000:0000 07 00000000:00000100 00:00000000 00000000     0        0 504548 2 0000000013db98ef 9        \n",
+			queued:  0x100,
+			dropped: 9,
+		},
+		{
+			name: "rejects a malformed queue",
+			table: procfsHeader +
+				" 2873: 0100007F:11C1 00000000:0000 07 00001D40 00:00000000 00000000     0        0 504547 2 0000000013db98ee 91       \n",
+			wantErr: true,
+		},
+		{
+			name: "rejects a malformed rx_queue",
+			table: procfsHeader +
+				" 2873: 0100007F:11C1 00000000:0000 07 00000000:0000XD40 00:00000000 00000000     0        0 504547 2 0000000013db98ee 91       \n",
+			wantErr: true,
+		},
+		{
+			name: "rejects malformed drops",
+			table: procfsHeader +
+				" 2873: 0100007F:11C1 00000000:0000 07 00000000:00001D40 00:00000000 00000000     0        0 504547 2 0000000013db98ee x        \n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen := tt.seen
+			if seen == nil {
+				seen = make(map[string]bool)
+			}
+			queued, txQueued, dropped, err := parseProcfsNet(strings.NewReader(tt.table), seen, tt.owned)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseProcfsNet() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if queued != tt.queued || txQueued != tt.txQueued || dropped != tt.dropped {
+				t.Errorf("parseProcfsNet() = %d, %d, %d, want %d, %d, %d", queued, txQueued, dropped, tt.queued, tt.txQueued, tt.dropped)
+			}
+			for _, inode := range tt.seenAfter {
+				if !seen[inode] {
+					t.Errorf("inode %s not added to seen", inode)
+				}
+			}
+		})
+	}
+}
+
+func TestFailOnDrops(t *testing.T) {
+	tests := []struct {
+		name      string
+		drops     []int
+		unread    bool
+		cancelled bool
+		want      int
+	}{
+		{name: "no drops", drops: []int{5, 5}, want: 0},
+		{name: "drops", drops: []int{5, 9}, want: 1},
+		{name: "a counter reset isn't a drop", drops: []int{9, 2}, want: 0},
+		{name: "unreadable", unread: true, want: 3},
+		{name: "interrupted", drops: []int{5, 5}, cancelled: true, want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			polls := 0
+			tgt := &target{name: "statsd", open: func(_ context.Context, protocol string) (io.ReadCloser, error) {
+				if tt.unread {
+					return nil, errors.New("permission denied")
+				}
+				if protocol != "udp" {
+					return nil, os.ErrNotExist
+				}
+				drops := tt.drops[len(tt.drops)-1]
+				if polls < len(tt.drops) {
+					drops = tt.drops[polls]
+				}
+				polls++
+				return ioutil.NopCloser(strings.NewReader(procfsHeader +
+					" 2873: 0100007F:11C1 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 504547 2 0000000013db98ee " + strconv.Itoa(drops) + "\n")), nil
+			}}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelled {
+				cancel()
+			}
+			if got := failOnDrops(ctx, tgt, 10*time.Millisecond); got != tt.want {
+				t.Errorf("failOnDrops() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
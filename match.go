@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// processMatcher builds the findPID matcher for --process.regex,
+// --process.cmdline-match, --process.cmdline-regex and --process.port,
+// requiring every one that is set to match. It returns nil when none are.
+func processMatcher(nameRegex, cmdlineSubstring, cmdlineRegex string, port int) (func(walk *procWalk, pid, name string) bool, error) {
+	var name, cmdline *regexp.Regexp
+	var err error
+	if nameRegex != "" {
+		if name, err = regexp.Compile("^(?:" + nameRegex + ")$"); err != nil {
+			return nil, err
+		}
+	}
+	if cmdlineRegex != "" {
+		if cmdline, err = regexp.Compile(cmdlineRegex); err != nil {
+			return nil, err
+		}
+	}
+	if name == nil && cmdline == nil && cmdlineSubstring == "" && port == 0 {
+		return nil, nil
+	}
+
+	self := strconv.Itoa(os.Getpid())
+	return func(walk *procWalk, pid, comm string) bool {
+		if name != nil && !name.MatchString(comm) {
+			return false
+		}
+		if port != 0 && !ownsPort(walk, pid, port) {
+			return false
+		}
+		if cmdline == nil && cmdlineSubstring == "" {
+			return true
+		}
+		// Our own command line contains the pattern we were given.
+		if pid == self {
+			return false
+		}
+		args := processCmdline(pid)
+		return (cmdlineSubstring == "" || strings.Contains(args, cmdlineSubstring)) &&
+			(cmdline == nil || cmdline.MatchString(args))
+	}, nil
+}
+
+// processCmdline returns the command line of pid with its arguments
+// separated by spaces, or "" for kernel threads and processes that are gone.
+func processCmdline(pid string) string {
+	b, err := ioutil.ReadFile(procPath(pid, "cmdline"))
+	if err != nil {
+		return ""
+	}
+	return string(bytes.Replace(bytes.TrimRight(b, "\x00"), []byte{0}, []byte{' '}, -1))
+}
+
+var (
+	// matchMode is set by --match-mode and decides what happens when a
+	// process name or pattern matches several PIDs, e.g. pre-forked workers.
+	matchMode = "last"
+	// matchRescanInterval is set by --match-mode.rescan-interval, how often
+	// the aggregate and per-pid modes look for processes that started
+	// matching since. Zero disables it.
+	matchRescanInterval = 30 * time.Second
+)
+
+// matchTargets returns the targets for the processes match selects: the last
+// one walked, one summing all of them, or one per PID with an extra pid label
+// after labels, depending on matchMode. It also returns the PID the
+// single-process collectors should watch. Unless each PID has its own target,
+// the targets look for match again when their processes exit; those that do
+// expire instead, and their pidGroup watches the PIDs matching later.
+func matchTargets(ctx context.Context, match func(walk *procWalk, pid, name string) bool, labels []string) ([]*target, string, error) {
+	found, err := findPIDs(ctx, match)
+	if err != nil || len(found) == 0 {
+		return nil, "", err
+	}
+	last := found[len(found)-1]
+
+	switch matchMode {
+	case "aggregate":
+		t := newAggregateTarget(last.name, foundPIDs(found), labels)
+		t.rediscover = func(ctx context.Context) ([]string, error) {
+			found, err := findPIDs(ctx, match)
+			return foundPIDs(found), err
+		}
+		t.rescan = true
+		t.rescannedAt = time.Now()
+		return []*target{t}, last.pid, nil
+	case "per-pid":
+		g := &pidGroup{match: match, labels: labels}
+		return g.newTargets(found, nil), last.pid, nil
+	}
+	t := newProcessTarget(last.name, last.pid, labels)
+	t.rediscover = func(ctx context.Context) ([]string, error) {
+		pid, _, err := findPID(ctx, match)
+		if pid == "" {
+			return nil, err
+		}
+		return []string{pid}, nil
+	}
+	return []*target{t}, last.pid, nil
+}
+
+func foundPIDs(found []foundProcess) []string {
+	pids := make([]string, len(found))
+	for i, p := range found {
+		pids[i] = p.pid
+	}
+	return pids
+}
+
+// newAggregateTarget returns a target reading the procfs tables of all of
+// pids as one. Processes usually share a network namespace, and so the same
+// table, which parseProcfsNet's inode deduplication takes care of. PIDs that
+// have exited, or been reused, are skipped.
+func newAggregateTarget(name string, pids []string, labels []string) *target {
+	t := &target{
+		name:     name,
+		labels:   labels,
+		sdLabels: map[string]string{"process": name, "pid": strings.Join(pids, ",")},
+	}
+	t.setPIDs(pids)
+	t.open = func(ctx context.Context, protocol string) (io.ReadCloser, error) {
+		var buf bytes.Buffer
+		for _, pid := range t.livePIDs() {
+			b, err := ioutil.ReadFile(procPath(pid, "net", protocol))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			// Keep only the first table's header line.
+			if buf.Len() > 0 {
+				if i := bytes.IndexByte(b, '\n'); i >= 0 {
+					b = b[i+1:]
+				}
+			}
+			buf.Write(b)
+		}
+		if buf.Len() == 0 {
+			return nil, &os.PathError{Op: "open", Path: procPath(pids[0], "net", protocol), Err: os.ErrNotExist}
+		}
+		return ioutil.NopCloser(&buf), nil
+	}
+	return t
+}
+
+// pidGroup is a --match-mode=per-pid selection, whose targets are each one of
+// the PIDs it matched. entry is the entry its targets were started for.
+type pidGroup struct {
+	match  func(walk *procWalk, pid, name string) bool
+	labels []string
+	entry  string
+}
+
+// pidGroups are the per-pid selections being watched, by entry, under
+// targetsMu. They outlive their targets expiring, so that the processes of a
+// selection that all restarted are still picked up, and are only forgotten
+// with their entry.
+var pidGroups = make(map[string]*pidGroup)
+
+// newTargets returns targets for the processes in found that none of
+// watching, the targets already watched, is for.
+func (g *pidGroup) newTargets(found []foundProcess, watching []*target) []*target {
+	watched := make(map[string]bool)
+	for _, t := range watching {
+		if t.group != nil && t.entry == g.entry {
+			for _, pid := range t.currentPIDs() {
+				watched[pid] = true
+			}
+		}
+	}
+	var targets []*target
+	for _, p := range found {
+		if watched[p.pid] {
+			continue
+		}
+		t := newProcessTarget(p.name, p.pid, append(append([]string{}, g.labels...), p.pid))
+		t.expire = true
+		t.group = g
+		t.entry = g.entry
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+// rescan watches the processes that started matching g since it last looked,
+// such as the workers a pre-forking server spawned to replace those it
+// recycled. It fails with ctx's error if ctx is done before the search is.
+func (g *pidGroup) rescan(ctx context.Context) error {
+	found, err := findPIDs(ctx, g.match)
+	if err != nil {
+		return err
+	}
+
+	targetChangesMu.Lock()
+	defer targetChangesMu.Unlock()
+	targetsMu.Lock()
+	current := pidGroups[g.entry] == g
+	targetsMu.Unlock()
+	if !current {
+		// Its entry was removed while we looked.
+		return nil
+	}
+	added := g.newTargets(found, currentTargets())
+	for _, t := range added {
+		slog.Info("Found a new matching process", "process", t.name, "pid", t.sdLabels["pid"])
+		t.observeResolution(true)
+	}
+	startTargets(ctx, "", added)
+	return nil
+}
+
+// watchPIDGroups rescans every per-pid selection each interval until ctx is
+// done.
+func watchPIDGroups(ctx context.Context, interval time.Duration) {
+	for sleepContext(ctx, interval) {
+		targetsMu.Lock()
+		groups := make([]*pidGroup, 0, len(pidGroups))
+		for _, g := range pidGroups {
+			groups = append(groups, g)
+		}
+		targetsMu.Unlock()
+		for _, g := range groups {
+			// Being stopped mid-walk isn't worth a word.
+			if err := g.rescan(ctx); err != nil && ctx.Err() == nil {
+				slog.Warn("Unable to look for new matching processes", "err", err)
+			}
+		}
+	}
+}
+
+// addPIDGroups records the per-pid selections targets are from in pidGroups.
+// It's called with targetsMu held once the targets can change.
+func addPIDGroups(targets []*target) {
+	for _, t := range targets {
+		if t.group != nil {
+			t.group.entry = t.entry
+			pidGroups[t.entry] = t.group
+		}
+	}
+}
+
+// pidGroupFor returns the per-pid selection started for entry, or nil.
+func pidGroupFor(entry string) *pidGroup {
+	targetsMu.Lock()
+	defer targetsMu.Unlock()
+	return pidGroups[entry]
+}
+
+// forgetPIDGroups stops looking for new processes for entries.
+func forgetPIDGroups(entries []string) {
+	targetsMu.Lock()
+	defer targetsMu.Unlock()
+	for _, e := range entries {
+		delete(pidGroups, e)
+	}
+}
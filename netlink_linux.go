@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// netlinkDial opens a netlink socket for protocol in the network namespace
+// at nsPath.
+func netlinkDial(nsPath string, protocol int) (int, error) {
+	var fd int
+	err := inNetNS(nsPath, func() error {
+		var err error
+		fd, err = unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, protocol)
+		return err
+	})
+	if err != nil {
+		return -1, os.NewSyscallError("socket", err)
+	}
+	// Don't let a kernel that never answers wedge the collector forever.
+	tv := unix.Timeval{Sec: 5}
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return -1, os.NewSyscallError("setsockopt", err)
+	}
+	return fd, nil
+}
+
+// netlinkDump sends a NLM_F_DUMP request of msgType carrying payload and
+// returns the payload of every message in the reply, stopping at NLMSG_DONE.
+func netlinkDump(fd int, msgType uint16, payload []byte) ([][]byte, error) {
+	req := make([]byte, unix.SizeofNlMsghdr+len(payload))
+	binary.LittleEndian.PutUint32(req[0:], uint32(len(req)))
+	binary.LittleEndian.PutUint16(req[4:], msgType)
+	binary.LittleEndian.PutUint16(req[6:], unix.NLM_F_REQUEST|unix.NLM_F_DUMP)
+	binary.LittleEndian.PutUint32(req[8:], 1)
+	copy(req[unix.SizeofNlMsghdr:], payload)
+
+	if err := unix.Sendto(fd, req, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, os.NewSyscallError("sendto", err)
+	}
+
+	var msgs [][]byte
+	buf := make([]byte, 32*1024)
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return nil, os.NewSyscallError("recvfrom", err)
+		}
+		b := buf[:n]
+		for len(b) >= unix.SizeofNlMsghdr {
+			l := int(binary.LittleEndian.Uint32(b[0:]))
+			if l < unix.SizeofNlMsghdr || l > len(b) {
+				return nil, fmt.Errorf("netlink: malformed message of length %d", l)
+			}
+			switch binary.LittleEndian.Uint16(b[4:]) {
+			case unix.NLMSG_DONE:
+				return msgs, nil
+			case unix.NLMSG_ERROR:
+				if l >= unix.SizeofNlMsghdr+4 {
+					if errno := -int32(binary.LittleEndian.Uint32(b[unix.SizeofNlMsghdr:])); errno != 0 {
+						return nil, os.NewSyscallError("netlink", syscall.Errno(errno))
+					}
+				}
+				return msgs, nil
+			}
+			msgs = append(msgs, append([]byte(nil), b[unix.SizeofNlMsghdr:l]...))
+			b = b[nlmsgAlign(l, b):]
+		}
+	}
+}
+
+// netlinkAttrs walks the rtattr style attributes in b, calling fn with the
+// type and payload of each.
+func netlinkAttrs(b []byte, fn func(typ uint16, data []byte)) {
+	for len(b) >= unix.SizeofRtAttr {
+		l := int(binary.LittleEndian.Uint16(b[0:]))
+		if l < unix.SizeofRtAttr || l > len(b) {
+			return
+		}
+		// Mask off NLA_F_NESTED and NLA_F_NET_BYTEORDER.
+		fn(binary.LittleEndian.Uint16(b[2:])&0x3fff, b[unix.SizeofRtAttr:l])
+		b = b[nlmsgAlign(l, b):]
+	}
+}
+
+// nlmsgAlign rounds a message or attribute length up to the alignment the
+// next one starts at, capped at the end of the buffer b.
+func nlmsgAlign(l int, b []byte) int {
+	a := (l + unix.NLMSG_ALIGNTO - 1) &^ (unix.NLMSG_ALIGNTO - 1)
+	if a > len(b) {
+		return len(b)
+	}
+	return a
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// inNetNS runs fn on an OS thread that has joined the network namespace at
+// nsPath (e.g. /proc/<pid>/ns/net). Sockets created inside fn stay bound to
+// that namespace after it returns, so callers typically just open what they
+// need and do the rest of their work outside.
+func inNetNS(nsPath string, fn func() error) error {
+	runtime.LockOSThread()
+
+	self := "/proc/self/task/" + strconv.Itoa(unix.Gettid()) + "/ns/net"
+	if same, err := sameFile(self, nsPath); err != nil || same {
+		runtime.UnlockOSThread()
+		if err != nil {
+			return err
+		}
+		return fn()
+	}
+
+	orig, err := os.Open(self)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer orig.Close()
+
+	ns, err := os.Open(nsPath)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer ns.Close()
+
+	if err := unix.Setns(int(ns.Fd()), unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return os.NewSyscallError("setns", err)
+	}
+
+	fnErr := fn()
+
+	if err := unix.Setns(int(orig.Fd()), unix.CLONE_NEWNET); err != nil {
+		// Leave the thread locked so the runtime throws it away rather than
+		// scheduling other goroutines into the wrong namespace.
+		return os.NewSyscallError("setns", err)
+	}
+	runtime.UnlockOSThread()
+	return fnErr
+}
+
+func sameFile(a, b string) (bool, error) {
+	sa, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	sb, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(sa, sb), nil
+}
+
+// readNetNSFile returns /proc/net/<name> as seen from the network namespace at
+// nsPath. /proc/thread-self/net follows the calling thread's namespace, so it
+// has to be read in full before inNetNS switches back.
+func readNetNSFile(nsPath, name string) ([]byte, error) {
+	var b []byte
+	err := inNetNS(nsPath, func() error {
+		var err error
+		b, err = ioutil.ReadFile("/proc/thread-self/net/" + name)
+		return err
+	})
+	return b, err
+}
+
+// readNetNSSysctl returns the value of the sysctl at name, e.g.
+// net/core/rmem_max, as seen from the network namespace at nsPath. /proc/sys
+// shows the calling thread's namespace's value of those that are per
+// namespace.
+func readNetNSSysctl(nsPath, name string) (string, error) {
+	var b []byte
+	err := inNetNS(nsPath, func() error {
+		var err error
+		b, err = ioutil.ReadFile("/proc/sys/" + name)
+		return err
+	})
+	return strings.TrimSpace(string(b)), err
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+func readNetNSFile(nsPath, name string) ([]byte, error) {
+	return nil, errors.New("network namespaces are only supported on linux")
+}
+
+func readNetNSSysctl(nsPath, name string) (string, error) {
+	return "", errors.New("network namespaces are only supported on linux")
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+)
+
+// newNetNSTarget returns a target that reads the UDP tables of the network
+// namespace bind-mounted at path (e.g. by ip netns add), which needn't have
+// any process running in it.
+func newNetNSTarget(path string) *target {
+	return &target{
+		name:     path,
+		labels:   []string{path},
+		sdLabels: map[string]string{"netns": path},
+		nsPath:   path,
+		entry:    path,
+		open: func(ctx context.Context, protocol string) (io.ReadCloser, error) {
+			b, err := readNetNSFile(path, protocol)
+			if err != nil {
+				return nil, err
+			}
+			return ioutil.NopCloser(bytes.NewReader(b)), nil
+		},
+	}
+}
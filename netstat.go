@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log/slog"
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// netstatInclude is the default --collector.netstat.fields, much like
+// node_exporter's.
+const netstatInclude = `^(.*_(InErrors|InErrs)|Ip(6|Ext)_(InOctets|OutOctets)|Icmp6?_(InMsgs|OutMsgs)|TcpExt_(Listen.*|TCPRcvQDrop|TCPBacklogDrop)|Tcp_(InSegs|OutSegs|RetransSegs|CurrEstab)|UdpLite6?_.*|Udp6?_(InDatagrams|OutDatagrams|NoPorts|RcvbufErrors|SndbufErrors|MemErrors))$`
+
+// netstatCollector exports the counters of /proc/<targetPID>/net/netstat,
+// snmp and snmp6 whose MIB_field name matches include, read at scrape time,
+// to correlate drops with the namespace's overall traffic without running
+// node_exporter inside it. The metrics are named like node_exporter's, e.g.
+// udp_exporter_netstat_IpExt_InOctets.
+type netstatCollector struct {
+	include *regexp.Regexp
+}
+
+func (c *netstatCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c *netstatCollector) Collect(ch chan<- prometheus.Metric) {
+	values := make(map[string]float64)
+	for _, file := range []string{"netstat", "snmp"} {
+		v, err := readSNMP(procPath(currentTargetPID(), "net", file))
+		if err != nil {
+			slog.Warn("Unable to read counters", "file", file, "err", err)
+			continue
+		}
+		for k, n := range v {
+			values[k] = n
+		}
+	}
+	// IPv6 kernels only.
+	if v, err := readSNMP6(procPath(currentTargetPID(), "net", "snmp6")); err == nil {
+		for k, n := range v {
+			values[k] = n
+		}
+	}
+
+	for name, v := range values {
+		if !c.include.MatchString(name) {
+			continue
+		}
+		desc := prometheus.NewDesc(metricName(legacyExporterPrefix, "netstat_"+name), "Statistic "+name+" from the target's network namespace.", nil, nil)
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.UntypedValue, v)
+	}
+}
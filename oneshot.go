@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// failOnDrops watches t for d and returns the exit status for the
+// --fail-on-drops gate: 0 when no drops happened, 1 when some did and 3 when
+// t couldn't be read at all. Polling in steps rather than comparing the first
+// and last sample still catches drops on sockets that close before the end.
+// Being interrupted through ctx counts as t being unreadable.
+func failOnDrops(ctx context.Context, t *target, d time.Duration) int {
+	step := pollInterval
+	if d < step {
+		step = d
+	}
+
+	last := make(map[string]int, len(protocols))
+	total := make(map[string]int, len(protocols))
+	deadline := time.Now().Add(d)
+	for first := true; ; first = false {
+		readable := false
+		seen := make(map[string]bool)
+		owned := t.pollOwnedInodes()
+		for _, protocol := range protocols {
+			_, _, dropped, _, err := parseProcfsNetFile(ctx, t, protocol, seen, owned, nil)
+			if err != nil {
+				continue
+			}
+			readable = true
+			if diff := dropped - last[protocol]; !first && diff > 0 {
+				total[protocol] += diff
+			}
+			last[protocol] = dropped
+		}
+		if !readable {
+			slog.Error("Unable to read any UDP table", "target", t.name)
+			return 3
+		}
+
+		if !time.Now().Before(deadline) {
+			break
+		}
+		if remaining := time.Until(deadline); remaining < step {
+			step = remaining
+		}
+		if !sleepContext(ctx, step) {
+			slog.Error("Interrupted before --fail-on-drops finished")
+			return 3
+		}
+	}
+
+	sum := 0
+	var byProtocol []string
+	for _, protocol := range protocols {
+		sum += total[protocol]
+		if total[protocol] > 0 {
+			byProtocol = append(byProtocol, fmt.Sprintf("%s: %d", protocol, total[protocol]))
+		}
+	}
+	if sum == 0 {
+		fmt.Printf("No UDP drops for %s in %s\n", t.name, d)
+		return 0
+	}
+	fmt.Printf("%d UDP drops for %s in %s (%s)\n", sum, t.name, d, strings.Join(byProtocol, ", "))
+	return 1
+}
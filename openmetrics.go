@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/proto"
+)
+
+// enableOpenMetrics is set by --web.enable-openmetrics, to serve the
+// OpenMetrics format to scrapers that ask for it.
+var enableOpenMetrics bool
+
+// openMetricsHandler serves g in the OpenMetrics format, with the _created
+// samples the client library records for counters, to requests that accept
+// it, and hands the rest to next. promhttp's own OpenMetrics support leaves
+// the _created samples out.
+func openMetricsHandler(g prometheus.Gatherer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format := expfmt.NegotiateIncludingOpenMetrics(r.Header)
+		if format.FormatType() != expfmt.TypeOpenMetrics {
+			next.ServeHTTP(w, r)
+			return
+		}
+		families, err := g.Gather()
+		if err != nil {
+			http.Error(w, "An error has occurred while gathering metrics:\n\n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", string(format))
+		enc := expfmt.NewEncoder(w, format, expfmt.WithCreatedLines())
+		for _, f := range families {
+			// OpenMetrics counters are named for their _total samples,
+			// and expfmt types any other counter as unknown.
+			if f.GetType() == dto.MetricType_COUNTER && !strings.HasSuffix(f.GetName(), "_total") {
+				f.Name = proto.String(f.GetName() + "_total")
+			}
+			if err := enc.Encode(f); err != nil {
+				slog.Error("Error encoding OpenMetrics", "family", f.GetName(), "err", err)
+				return
+			}
+		}
+		if closer, ok := enc.(expfmt.Closer); ok {
+			closer.Close()
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestOpenMetricsHandler(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	dropped := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "udp_procfs_buffer_dropped",
+		Help: "The number of dropped UDP messages in the linux buffer",
+	}, []string{"protocol"})
+	registry.MustRegister(dropped)
+	dropped.WithLabelValues("udp").Add(91)
+	handler := openMetricsHandler(registry, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	tests := []struct {
+		name, accept, contentType string
+		want, notWant             []string
+	}{
+		{
+			name:        "OpenMetrics",
+			accept:      "application/openmetrics-text; version=1.0.0,text/plain;q=0.5",
+			contentType: "application/openmetrics-text",
+			want: []string{
+				"# TYPE udp_procfs_buffer_dropped counter\n",
+				`udp_procfs_buffer_dropped_total{protocol="udp"} 91.0` + "\n",
+				`udp_procfs_buffer_dropped_created{protocol="udp"} `,
+				"# EOF\n",
+			},
+		},
+		{
+			name:        "text",
+			accept:      "text/plain",
+			contentType: "text/plain",
+			want:        []string{`udp_procfs_buffer_dropped{protocol="udp"} 91` + "\n"},
+			notWant:     []string{"_created", "# EOF"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/metrics", nil)
+			r.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			resp := w.Result()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d", resp.StatusCode)
+			}
+			if got := resp.Header.Get("Content-Type"); !strings.HasPrefix(got, tt.contentType) {
+				t.Errorf("Content-Type = %q, want %s", got, tt.contentType)
+			}
+			b, _ := ioutil.ReadAll(resp.Body)
+			for _, want := range tt.want {
+				if !strings.Contains(string(b), want) {
+					t.Errorf("body is missing %q:\n%s", want, b)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(string(b), notWant) {
+					t.Errorf("body has %q:\n%s", notWant, b)
+				}
+			}
+		})
+	}
+}
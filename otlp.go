@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// exporterStart is the start time of the OTLP sums, which count from when the
+// exporter started.
+var exporterStart = time.Now()
+
+// The OTLP/HTTP JSON encoding of opentelemetry.proto.metrics.v1, only as much
+// of it as the exporter sends. 64 bit integers are strings, per the proto3
+// JSON mapping.
+type (
+	otlpRequest struct {
+		ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+	}
+	otlpResourceMetrics struct {
+		Resource     otlpResource       `json:"resource"`
+		ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeMetrics struct {
+		Scope   otlpScope    `json:"scope"`
+		Metrics []otlpMetric `json:"metrics"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue string `json:"stringValue,omitempty"`
+		IntValue    string `json:"intValue,omitempty"`
+	}
+	otlpMetric struct {
+		Name        string         `json:"name"`
+		Description string         `json:"description,omitempty"`
+		Gauge       *otlpGauge     `json:"gauge,omitempty"`
+		Sum         *otlpSum       `json:"sum,omitempty"`
+		Summary     *otlpSummary   `json:"summary,omitempty"`
+		Histogram   *otlpHistogram `json:"histogram,omitempty"`
+	}
+	otlpGauge struct {
+		DataPoints []otlpNumberPoint `json:"dataPoints"`
+	}
+	otlpSum struct {
+		DataPoints             []otlpNumberPoint `json:"dataPoints"`
+		AggregationTemporality int               `json:"aggregationTemporality"`
+		IsMonotonic            bool              `json:"isMonotonic"`
+	}
+	otlpSummary struct {
+		DataPoints []otlpSummaryPoint `json:"dataPoints"`
+	}
+	otlpHistogram struct {
+		DataPoints             []otlpHistogramPoint `json:"dataPoints"`
+		AggregationTemporality int                  `json:"aggregationTemporality"`
+	}
+	otlpPoint struct {
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+		TimeUnixNano      string          `json:"timeUnixNano"`
+	}
+	otlpNumberPoint struct {
+		otlpPoint
+		AsDouble float64 `json:"asDouble"`
+	}
+	otlpSummaryPoint struct {
+		otlpPoint
+		Count          string         `json:"count"`
+		Sum            float64        `json:"sum"`
+		QuantileValues []otlpQuantile `json:"quantileValues"`
+	}
+	otlpQuantile struct {
+		Quantile float64 `json:"quantile"`
+		Value    float64 `json:"value"`
+	}
+	otlpHistogramPoint struct {
+		otlpPoint
+		Count          string    `json:"count"`
+		Sum            float64   `json:"sum"`
+		BucketCounts   []string  `json:"bucketCounts"`
+		ExplicitBounds []float64 `json:"explicitBounds"`
+	}
+)
+
+// otlpCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE.
+const otlpCumulative = 2
+
+// pushOTLP sends everything registered to the OTLP/HTTP receiver at endpoint
+// (e.g. http://otel-collector:4318) every interval, with headers as in
+// pushRemoteWrite. The series' labels become data point attributes, and the
+// host and target describe the resource. With --push.wal-dir, requests that
+// can't be sent are sent again later. It returns once ctx is done.
+func pushOTLP(ctx context.Context, endpoint string, headers []string, interval time.Duration) {
+	header, err := parseHeaders(headers)
+	if err != nil {
+		slog.Error("Invalid OTLP header", "err", err)
+		return
+	}
+	metricsURL := strings.TrimSuffix(endpoint, "/") + "/v1/metrics"
+	wal, err := newPushWAL("otlp")
+	if err != nil {
+		slog.Error("Unable to open the OTLP WAL", "err", err)
+		return
+	}
+
+	client := &http.Client{Timeout: interval}
+	for sleepContext(ctx, interval) {
+		if err := pushOTLPOnce(ctx, client, metricsURL, header, wal); err != nil {
+			slog.Warn("Unable to push to OTLP", "err", err)
+		}
+	}
+}
+
+func pushOTLPOnce(ctx context.Context, client *http.Client, metricsURL string, header http.Header, wal *pushWAL) error {
+	families, err := snapshotGatherer().Gather()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: otlpResource{Attributes: otlpResourceAttributes()},
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope:   otlpScope{Name: "udp-procfs-exporter"},
+			Metrics: otlpMetrics(families, time.Now()),
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+	return wal.send(body, func(body []byte) error {
+		return otlpSend(ctx, client, metricsURL, header, body)
+	})
+}
+
+func otlpSend(ctx context.Context, client *http.Client, metricsURL string, header http.Header, body []byte) error {
+	req, err := http.NewRequest("POST", metricsURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return responseError(resp)
+}
+
+// otlpResourceAttributes describes the exporter's host and, when it watches
+// a single local process, that process.
+func otlpResourceAttributes() []otlpAttribute {
+	attrs := []otlpAttribute{{"service.name", otlpValue{StringValue: "udp-procfs-exporter"}}}
+	if host, err := os.Hostname(); err == nil {
+		attrs = append(attrs, otlpAttribute{"host.name", otlpValue{StringValue: host}})
+	}
+	if pid := currentTargetPID(); pid != "" {
+		attrs = append(attrs,
+			otlpAttribute{"process.pid", otlpValue{IntValue: pid}},
+			otlpAttribute{"process.executable.name", otlpValue{StringValue: targetProcName}},
+		)
+	}
+	return attrs
+}
+
+// otlpMetrics converts families to OTel metrics: counters to monotonic
+// cumulative sums, gauges and untyped metrics to gauges, and summaries and
+// histograms to their OTel equivalents.
+func otlpMetrics(families []*dto.MetricFamily, now time.Time) []otlpMetric {
+	end := strconv.FormatInt(now.UnixNano(), 10)
+	start := strconv.FormatInt(exporterStart.UnixNano(), 10)
+
+	metrics := make([]otlpMetric, 0, len(families))
+	for _, f := range families {
+		m := otlpMetric{Name: f.GetName(), Description: f.GetHelp()}
+		switch f.GetType() {
+		case dto.MetricType_COUNTER:
+			m.Sum = &otlpSum{AggregationTemporality: otlpCumulative, IsMonotonic: true}
+		case dto.MetricType_SUMMARY:
+			m.Summary = &otlpSummary{}
+		case dto.MetricType_HISTOGRAM:
+			m.Histogram = &otlpHistogram{AggregationTemporality: otlpCumulative}
+		default:
+			m.Gauge = &otlpGauge{}
+		}
+		for _, pm := range f.GetMetric() {
+			point := otlpPoint{TimeUnixNano: end}
+			for _, l := range pm.GetLabel() {
+				point.Attributes = append(point.Attributes, otlpAttribute{l.GetName(), otlpValue{StringValue: l.GetValue()}})
+			}
+			// JSON has no NaN or infinities, e.g. for a summary
+			// that hasn't observed anything yet, so those are left out.
+			switch f.GetType() {
+			case dto.MetricType_COUNTER:
+				point.StartTimeUnixNano = start
+				if v := pm.GetCounter().GetValue(); finite(v) {
+					m.Sum.DataPoints = append(m.Sum.DataPoints, otlpNumberPoint{point, v})
+				}
+			case dto.MetricType_GAUGE:
+				if v := pm.GetGauge().GetValue(); finite(v) {
+					m.Gauge.DataPoints = append(m.Gauge.DataPoints, otlpNumberPoint{point, v})
+				}
+			case dto.MetricType_SUMMARY:
+				s := pm.GetSummary()
+				p := otlpSummaryPoint{otlpPoint: point, Count: strconv.FormatUint(s.GetSampleCount(), 10), Sum: s.GetSampleSum()}
+				for _, q := range s.GetQuantile() {
+					if finite(q.GetValue()) {
+						p.QuantileValues = append(p.QuantileValues, otlpQuantile{q.GetQuantile(), q.GetValue()})
+					}
+				}
+				m.Summary.DataPoints = append(m.Summary.DataPoints, p)
+			case dto.MetricType_HISTOGRAM:
+				// OTel buckets aren't cumulative, and the last
+				// one has no upper bound.
+				h := pm.GetHistogram()
+				point.StartTimeUnixNano = start
+				p := otlpHistogramPoint{otlpPoint: point, Count: strconv.FormatUint(h.GetSampleCount(), 10), Sum: h.GetSampleSum()}
+				var below uint64
+				for _, b := range h.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), +1) {
+						continue
+					}
+					p.ExplicitBounds = append(p.ExplicitBounds, b.GetUpperBound())
+					p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(b.GetCumulativeCount()-below, 10))
+					below = b.GetCumulativeCount()
+				}
+				p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(h.GetSampleCount()-below, 10))
+				m.Histogram.DataPoints = append(m.Histogram.DataPoints, p)
+			default:
+				if v := pm.GetUntyped().GetValue(); finite(v) {
+					m.Gauge.DataPoints = append(m.Gauge.DataPoints, otlpNumberPoint{point, v})
+				}
+			}
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
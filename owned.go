@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// socketsNamespaceWide is set by --sockets.namespace-wide to count every
+// socket in the target's network namespace, as the exporter used to, rather
+// than only those the target has open.
+var socketsNamespaceWide bool
+
+// ownedInodes returns the inodes of the sockets pid has open, from the
+// socket:[inode] links in /proc/<pid>/fd.
+func ownedInodes(pid string) (map[string]bool, error) {
+	dir := procPath(pid, "fd")
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	owned := make(map[string]bool, len(names))
+	for _, name := range names {
+		link, err := os.Readlink(filepath.Join(dir, name))
+		// The descriptor may have been closed since the listing.
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(link, "socket:[") && strings.HasSuffix(link, "]") {
+			owned[link[len("socket:["):len(link)-1]] = true
+		}
+	}
+	return owned, nil
+}
+
+// ownedInodes returns the sockets t's processes have open. The procfs tables
+// list the whole network namespace, so other processes sharing it would be
+// counted too. It returns nil, meaning every socket counts, for targets that
+// aren't local processes and with --sockets.namespace-wide.
+func (t *target) ownedInodes() (map[string]bool, error) {
+	if socketsNamespaceWide || len(t.currentPIDs()) == 0 {
+		return nil, nil
+	}
+	owned := make(map[string]bool)
+	for _, pid := range t.livePIDs() {
+		inodes, err := ownedInodes(pid)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for inode := range inodes {
+			owned[inode] = true
+		}
+	}
+	return owned, nil
+}
+
+// pollOwnedInodes is ownedInodes for the watch loops, which fall back to
+// counting the whole namespace when the target's descriptors can't be listed,
+// e.g. without the ptrace access /proc/<pid>/fd needs.
+func (t *target) pollOwnedInodes() map[string]bool {
+	owned, err := t.ownedInodes()
+	if err != nil && !t.ownedFallback {
+		slog.Warn("Unable to list the sockets of the target, counting its whole network namespace", "target", t.name, "err", err)
+		t.ownedFallback = true
+	}
+	return owned
+}
+
+// watchedInodes returns the sockets the watched targets have open, for the
+// collectors reading targetPID's network namespace. It returns nil, meaning
+// every socket counts, with --sockets.namespace-wide and when a target's
+// sockets can't be listed, which the watch loop warns about.
+func watchedInodes() map[string]bool {
+	if socketsNamespaceWide {
+		return nil
+	}
+	owned := make(map[string]bool)
+	for _, t := range currentTargets() {
+		inodes, err := t.ownedInodes()
+		if err != nil || inodes == nil {
+			return nil
+		}
+		for inode := range inodes {
+			owned[inode] = true
+		}
+	}
+	return owned
+}
+
+// processName returns the status Name: of pid, or "" if it's gone.
+func processName(pid string) string {
+	status, err := ioutil.ReadFile(procPath(pid, "status"))
+	if err != nil {
+		return ""
+	}
+	return statusName(status)
+}
+
+// socketOwners maps the inodes of the sockets open in the processes in the
+// network namespace netns, a /proc/<pid>/ns/net link, to the process that has
+// them open. A socket shared by several processes, e.g. across a fork, maps
+// to the first one walked.
+func socketOwners(ctx context.Context, netns string) (map[string]foundProcess, error) {
+	owners := make(map[string]foundProcess)
+	_, err := findPIDs(ctx, func(_ *procWalk, pid, name string) bool {
+		if ns, err := os.Readlink(procPath(pid, "ns", "net")); err != nil || ns != netns {
+			return false
+		}
+		// Processes we can't ptrace leave their sockets unowned.
+		inodes, _ := ownedInodes(pid)
+		for inode := range inodes {
+			if _, ok := owners[inode]; !ok {
+				owners[inode] = foundProcess{pid, name}
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return owners, nil
+}
+
+// socketOwnerCache keeps socketOwners between polls, walking procfs again
+// only for sockets it hasn't seen before, and at most once a poll.
+type socketOwnerCache struct {
+	owners  map[string]foundProcess
+	unowned map[string]bool
+	scanned bool
+}
+
+// lookup returns the owner of the socket inode in netns, or an empty
+// foundProcess if no process we can see has it open. A walk that fails, e.g.
+// because ctx is done, is tried again on the next lookup.
+func (c *socketOwnerCache) lookup(ctx context.Context, netns, inode string) foundProcess {
+	if p, ok := c.owners[inode]; ok || c.unowned[inode] {
+		return p
+	}
+	if !c.scanned {
+		owners, err := socketOwners(ctx, netns)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Warn("Unable to look up socket owners", "err", err)
+			}
+			return foundProcess{}
+		}
+		c.owners, c.unowned, c.scanned = owners, make(map[string]bool), true
+		if p, ok := c.owners[inode]; ok {
+			return p
+		}
+	}
+	c.unowned[inode] = true
+	return foundProcess{}
+}
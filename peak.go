@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var udpBufferDroppedPeak, udpBufferQueuedPeak *peakCollector
+
+// peakCollector exports the largest value observed for each label set since
+// the previous scrape, then starts over; a label set with no observation since
+// then is left out until it has one. Every scrape resets it, so with several
+// Prometheus servers scraping one exporter each sees only the peaks since
+// whichever scraped last. Push sinks only peek, see snapshotGatherer.
+type peakCollector struct {
+	desc *prometheus.Desc
+
+	mu    sync.Mutex
+	peaks map[string]*peak
+}
+
+type peak struct {
+	labels   []string
+	value    float64
+	observed bool
+}
+
+func newPeakCollector(name, help string, labels []string) *peakCollector {
+	return &peakCollector{
+		desc:  prometheus.NewDesc(name, help, labels, nil),
+		peaks: make(map[string]*peak),
+	}
+}
+
+func (c *peakCollector) observe(labels []string, v float64) {
+	key := strings.Join(labels, "\xff")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.peaks[key]
+	if !ok {
+		c.peaks[key] = &peak{labels: labels, value: v, observed: true}
+		return
+	}
+	if !p.observed || v > p.value {
+		p.value = v
+	}
+	p.observed = true
+}
+
+// forget stops exporting labels.
+func (c *peakCollector) forget(labels []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.peaks, strings.Join(labels, "\xff"))
+}
+
+func (c *peakCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *peakCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range c.peaks {
+		if !p.observed {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, p.value, p.labels...)
+		if !peekOnly {
+			p.observed = false
+		}
+	}
+}
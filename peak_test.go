@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPeakCollectorScrapesWithoutPoll(t *testing.T) {
+	c := newPeakCollector("udp_procfs_buffer_queued_max", "Peak.", []string{"process"})
+	c.observe([]string{"statsd"}, 10)
+	c.observe([]string{"statsd"}, 30)
+	c.observe([]string{"statsd"}, 20)
+
+	want := `# HELP udp_procfs_buffer_queued_max Peak.
+# TYPE udp_procfs_buffer_queued_max gauge
+udp_procfs_buffer_queued_max{process="statsd"} 30
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want)); err != nil {
+		t.Errorf("first scrape: %v", err)
+	}
+	// No poll since, so there is no peak to report rather than one of 0.
+	if n := testutil.CollectAndCount(c); n != 0 {
+		t.Errorf("second scrape collected %d series, want 0", n)
+	}
+
+	c.observe([]string{"statsd"}, 5)
+	want = strings.Replace(want, "} 30", "} 5", 1)
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want)); err != nil {
+		t.Errorf("scrape after the next poll: %v", err)
+	}
+}
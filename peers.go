@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var peerLabels = []string{"protocol", "remote_addr", "remote_port"}
+
+var (
+	peerQueued  *prometheus.GaugeVec
+	peerDropped *prometheus.CounterVec
+)
+
+// newPeerMetrics builds the peers collector's metrics, which
+// /api/v1/cardinality describes whether or not the collector runs.
+func newPeerMetrics() {
+	peerQueued = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: metricName(legacyExporterPrefix, "peer_buffer_queued"),
+		Help: "Bytes queued on connected UDP sockets, by the peer they are connected to.",
+	}, peerLabels)
+	peerDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: metricName(legacyExporterPrefix, "peer_buffer_dropped_total"),
+		Help: "Packets dropped on connected UDP sockets, by the peer they are connected to.",
+	}, peerLabels)
+}
+
+// watchPeers periodically aggregates the connected UDP sockets the target has
+// open, or with --sockets.namespace-wide every one in the network namespace of
+// targetPID, by their remote address and port. Unconnected sockets, which is
+// what servers normally use, have no single peer and are left out.
+func watchPeers(ctx context.Context) {
+	prometheus.MustRegister(peerQueued, peerDropped)
+	queuedGC := newGaugeGC("peers", metricName(legacyExporterPrefix, "peer_buffer_queued"), peerQueued)
+	dropped := newCounterTracker("peers", metricName(legacyExporterPrefix, "peer_buffer_dropped_total"), peerDropped)
+
+	for {
+		nsPath := procPath(currentTargetPID(), "ns", "net")
+		owned := watchedInodes()
+		queued := make(map[[3]string]uint64)
+		drops := make(map[[3]string]uint64)
+		for _, family := range []uint8{afInet, afInet6} {
+			sockets, err := listUDPSockets(nsPath, family, true)
+			if err != nil {
+				slog.Warn("Unable to collect connected socket stats", "err", err)
+				continue
+			}
+			for _, s := range sockets {
+				if s.remotePort == 0 && s.remoteAddr.IsUnspecified() {
+					continue
+				}
+				if owned != nil && !owned[strconv.FormatUint(uint64(s.inode), 10)] {
+					continue
+				}
+				key := [3]string{diagProtocol(s.family), s.remoteAddr.String(), strconv.Itoa(int(s.remotePort))}
+				queued[key] += uint64(s.rxQueue)
+				drops[key] += uint64(s.skmem[skmemDrops])
+			}
+		}
+
+		// A socket closing lowers its peer's drop total, which just
+		// resyncs the counter.
+		for key, v := range queued {
+			if queuedGC.admit(key[:]...) {
+				peerQueued.WithLabelValues(key[:]...).Set(float64(v))
+			}
+			dropped.set(drops[key], key[:]...)
+		}
+		queuedGC.sweep()
+		dropped.sweep()
+
+		if !sleepContext(ctx, currentPollInterval()) {
+			return
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// podmanSockets returns where the Podman API socket is looked for when
+// --podman.socket isn't given: the rootful socket when running as root, the
+// calling user's rootless socket otherwise.
+func podmanSockets() []string {
+	if os.Geteuid() == 0 {
+		return []string{"/run/podman/podman.sock"}
+	}
+	var sockets []string
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		sockets = append(sockets, filepath.Join(dir, "podman", "podman.sock"))
+	}
+	return append(sockets, "/run/user/"+strconv.Itoa(os.Geteuid())+"/podman/podman.sock")
+}
+
+// podmanContainerPID finds the PID of the Podman container called name. The
+// PID Podman reports is in the host PID namespace even for rootless
+// containers, so it can be used with procfsPath directly; their procfs
+// entries are owned by the container's user namespace, though, so reading
+// them requires running as root or as the user owning the container.
+func podmanContainerPID(ctx context.Context, socket, name string) (string, error) {
+	sockets := []string{socket}
+	if socket == "" {
+		sockets = podmanSockets()
+	}
+
+	var lastErr error
+	for _, s := range sockets {
+		if _, err := os.Stat(s); err != nil {
+			lastErr = err
+			continue
+		}
+		var inspect struct {
+			State struct {
+				Pid     int  `json:"Pid"`
+				Running bool `json:"Running"`
+			} `json:"State"`
+		}
+		if err := getJSON(ctx, unixSocketClient(s), "http://podman/v1.0.0/libpod/containers/"+url.PathEscape(name)+"/json", &inspect); err != nil {
+			lastErr = err
+			continue
+		}
+		if !inspect.State.Running || inspect.State.Pid == 0 {
+			return "", fmt.Errorf("podman container %s is not running", name)
+		}
+		return strconv.Itoa(inspect.State.Pid), nil
+	}
+	return "", fmt.Errorf("unable to inspect podman container %s: %v", name, lastErr)
+}
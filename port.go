@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// portTables are the tables --process.port looks for bound sockets in.
+var portTables = []string{"udp", "udp6", "udplite", "udplite6"}
+
+// boundInodes returns the inodes of the UDP and UDP-Lite sockets bound to
+// port in pid's network namespace.
+func boundInodes(pid string, port int) map[string]bool {
+	bound := make(map[string]bool)
+	for _, table := range portTables {
+		f, err := os.Open(procPath(pid, "net", table))
+		if err != nil {
+			continue
+		}
+		s := bufio.NewScanner(f)
+		for n := 0; s.Scan(); n++ {
+			fields := strings.Fields(s.Text())
+			if n < 1 || len(fields) < 10 || fields[9] == "0" {
+				continue
+			}
+			if _, p, err := parseProcfsAddr(fields[1]); err == nil && p == port {
+				bound[fields[9]] = true
+			}
+		}
+		f.Close()
+	}
+	return bound
+}
+
+// ownsPort reports whether pid has a socket bound to the UDP port port: one
+// of the sockets bound to it in the process's network namespace, per its net
+// tables, that appears among the socket:[inode] links in its fd directory.
+// Each namespace's tables are read once per walk rather than once per
+// process.
+func ownsPort(walk *procWalk, pid string, port int) bool {
+	netns, err := os.Readlink(procPath(pid, "ns", "net"))
+	if err != nil {
+		return false
+	}
+	bound, ok := walk.boundInodes[netns]
+	if !ok {
+		bound = boundInodes(pid, port)
+		walk.boundInodes[netns] = bound
+	}
+	if len(bound) == 0 {
+		return false
+	}
+
+	owned, err := ownedInodes(pid)
+	if err != nil {
+		return false
+	}
+	for inode := range bound {
+		if owned[inode] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestOwnsPort(t *testing.T) {
+	// 10 and 20 share a namespace, whose tables are read from 10 only.
+	fakeProcfs(t,
+		fakeProcess{pid: "10", name: "statsd", netns: "net:[1]", sockets: []string{"100"},
+			tables: map[string]string{"udp": udpTable("4545 100", "5000 200")}},
+		fakeProcess{pid: "20", name: "relay", netns: "net:[1]", sockets: []string{"200"}},
+		fakeProcess{pid: "30", name: "statsd", netns: "net:[2]", sockets: []string{"300"},
+			tables: map[string]string{"udp6": udpTable("4545 300")}},
+		fakeProcess{pid: "40", name: "statsd", netns: "net:[2]", sockets: []string{"400"}},
+	)
+
+	tests := []struct {
+		port int
+		want []string
+	}{
+		{4545, []string{"10", "30"}},
+		{5000, []string{"20"}},
+		{6000, []string{}},
+	}
+	for _, tt := range tests {
+		match, err := processMatcher("", "", "", tt.port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Walks by several goroutines at once each read the tables for
+		// themselves.
+		results := make(chan []string)
+		for i := 0; i < 4; i++ {
+			go func() {
+				found, err := findPIDs(context.Background(), match)
+				if err != nil {
+					t.Error(err)
+				}
+				results <- foundPIDs(found)
+			}()
+		}
+		for i := 0; i < 4; i++ {
+			if got := <-results; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("port %d: findPIDs() = %q, want %q", tt.port, got, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// enablePprof is set by --web.enable-pprof, to serve the profiling endpoints
+// of net/http/pprof. The exporter serves its own mux rather than
+// http.DefaultServeMux, where importing that package registers them
+// unconditionally, so they're only served when asked for.
+var enablePprof bool
+
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
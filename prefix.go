@@ -0,0 +1,38 @@
+package main
+
+import "regexp"
+
+// defaultMetricPrefix is what every exporter metric is named with, unless
+// --metric-prefix swaps it for another.
+const defaultMetricPrefix = "udp_procfs_"
+
+// The prefixes the metrics were named with before --metric-prefix, which
+// --legacy-metric-names names them with again: udp_exporter_ for the
+// target's buffers and collectors, udp_procfs_ for the exporter's health and
+// udp_ for --collector.sockets' udp_socket_*.
+const (
+	legacyExporterPrefix = "udp_exporter_"
+	legacyProcfsPrefix   = "udp_procfs_"
+	legacySocketPrefix   = "udp_"
+)
+
+var (
+	metricPrefix = defaultMetricPrefix
+
+	// legacyMetricNames is set by --legacy-metric-names to name the
+	// metrics as older releases did, for dashboards built on them.
+	legacyMetricNames bool
+
+	validMetricPrefix = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)?$`)
+)
+
+// metricName returns the full name of the exporter metric name: name after
+// --metric-prefix, or after legacy, the prefix it had before there was one,
+// with --legacy-metric-names. Metrics must be built after the flags are
+// parsed for it to see them.
+func metricName(legacy, name string) string {
+	if legacyMetricNames {
+		return legacy + name
+	}
+	return metricPrefix + name
+}
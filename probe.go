@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// serveProbe looks up the local process named by ?process=, or with the PID
+// ?pid=, when requested, and serves the queued and dropped counts of its
+// sockets, like the blackbox exporter does for its probes. Scraping it once
+// per process with relabeling, e.g.
+//
+//	params: {process: [statsd]}
+//
+// lets one exporter watch any number of processes. The probe keeps no state
+// between requests, so udp_exporter_buffer_dropped is the kernel's count over
+// the sockets' lifetimes rather than the exporter's counter.
+func serveProbe(w http.ResponseWriter, r *http.Request) {
+	process, pid := r.URL.Query().Get("process"), r.URL.Query().Get("pid")
+	if (process == "") == (pid == "") {
+		http.Error(w, "Exactly one of the process or pid parameters is required.", http.StatusBadRequest)
+		return
+	}
+	if pid != "" {
+		if _, err := strconv.Atoi(pid); err != nil {
+			http.Error(w, "Invalid pid "+pid+".", http.StatusBadRequest)
+			return
+		}
+	}
+
+	start := time.Now()
+	registry := prometheus.NewRegistry()
+	queued := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: metricName(legacyExporterPrefix, "buffer_queued"),
+		Help: "The number of queued UDP messages in the linux buffer.",
+	}, []string{"protocol"})
+	txQueued := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: metricName(legacyExporterPrefix, "buffer_tx_queued"),
+		Help: "The number of bytes queued for sending in the linux buffer.",
+	}, []string{"protocol"})
+	dropped := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: metricName(legacyExporterPrefix, "buffer_dropped"),
+		Help: "The number of UDP messages the probed process's sockets have dropped.",
+	}, []string{"protocol"})
+	success := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_success",
+		Help: "Whether the process was found and any of its tables read.",
+	})
+	duration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_duration_seconds",
+		Help: "How long the probe took.",
+	})
+	registry.MustRegister(queued, txQueued, dropped, success, duration)
+
+	t, err := probeTarget(r, process, pid)
+	if err != nil && r.Context().Err() != nil {
+		// The scraper gave up, so there's no one to answer.
+		return
+	}
+	if err != nil {
+		slog.Debug("Probe failed", "process", process, "pid", pid, "err", err)
+	} else {
+		seen := make(map[string]bool)
+		owned, _ := t.ownedInodes()
+		for _, protocol := range protocols {
+			q, tx, d, err := readQueues(r.Context(), t, protocol, seen, owned)
+			if err != nil {
+				continue
+			}
+			queued.WithLabelValues(protocol).Set(float64(q))
+			txQueued.WithLabelValues(protocol).Set(float64(tx))
+			dropped.WithLabelValues(protocol).Add(float64(d))
+			success.Set(1)
+		}
+	}
+	duration.Set(time.Since(start).Seconds())
+
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// probeTarget returns a target for the process called process, or with the
+// PID pid.
+func probeTarget(r *http.Request, process, pid string) (*target, error) {
+	if pid != "" {
+		status, err := ioutil.ReadFile(procPath(pid, "status"))
+		if err != nil {
+			return nil, err
+		}
+		return newProcessTarget(statusName(status), pid, nil), nil
+	}
+	pid, _, err := findPID(r.Context(), func(_ *procWalk, _, name string) bool { return name == process })
+	if err != nil {
+		return nil, err
+	}
+	if pid == "" {
+		return nil, errors.New("no process named " + process)
+	}
+	return newProcessTarget(process, pid, nil), nil
+}
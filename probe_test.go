@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeProbeCancelled(t *testing.T) {
+	fakeProcfs(t,
+		fakeProcess{pid: "10", name: "collectd"},
+		fakeProcess{pid: "20", name: "statsd"},
+	)
+
+	// The scraper timing out during the walk for the process ends the
+	// probe without an answer, rather than the exporter.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest("GET", "/probe?process=statsd", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	serveProbe(w, r)
+	if w.Body.Len() != 0 {
+		t.Errorf("cancelled probe answered %q, want nothing", w.Body.String())
+	}
+
+	// And the next probe works.
+	w = httptest.NewRecorder()
+	serveProbe(w, httptest.NewRequest("GET", "/probe?process=statsd", nil))
+	if w.Code != 200 || w.Body.Len() == 0 {
+		t.Errorf("probe after a cancelled one = %d %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// userHZ is the unit of the times in /proc/<pid>/stat. It's fixed at 100 on
+// every architecture Linux reports it in, whatever the kernel's HZ.
+const userHZ = 100
+
+// processCollector exports resource usage of the watched process, read at
+// scrape time from /proc/<targetPID>. A consumer that can't keep up is the
+// usual reason for full buffers, so it helps to see both side by side.
+type processCollector struct {
+	cpu, rss, threads, start *prometheus.Desc
+	fds, maxFDs              *prometheus.Desc
+}
+
+func newProcessCollector() *processCollector {
+	return &processCollector{
+		cpu:     prometheus.NewDesc(metricName(legacyExporterPrefix, "target_cpu_seconds_total"), "User and system CPU time spent by the watched process.", []string{"mode"}, nil),
+		rss:     prometheus.NewDesc(metricName(legacyExporterPrefix, "target_resident_memory_bytes"), "Resident memory of the watched process.", nil, nil),
+		threads: prometheus.NewDesc(metricName(legacyExporterPrefix, "target_threads"), "Threads in the watched process.", nil, nil),
+		start:   prometheus.NewDesc(metricName(legacyExporterPrefix, "target_start_time_seconds"), "Start time of the watched process since the unix epoch.", nil, nil),
+		fds:     prometheus.NewDesc(metricName(legacyExporterPrefix, "target_open_fds"), "Open file descriptors of the watched process.", nil, nil),
+		maxFDs:  prometheus.NewDesc(metricName(legacyExporterPrefix, "target_max_fds"), "Soft limit on open file descriptors of the watched process. Once it's reached new sockets can't be opened.", nil, nil),
+	}
+}
+
+func (c *processCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.cpu
+	ch <- c.rss
+	ch <- c.threads
+	ch <- c.start
+	ch <- c.fds
+	ch <- c.maxFDs
+}
+
+func (c *processCollector) Collect(ch chan<- prometheus.Metric) {
+	fields, err := processStat(currentTargetPID())
+	if err != nil {
+		slog.Warn("Unable to read target process stats", "err", err)
+		return
+	}
+	utime, _ := strconv.ParseFloat(fields[11], 64)
+	stime, _ := strconv.ParseFloat(fields[12], 64)
+	threads, _ := strconv.ParseFloat(fields[17], 64)
+	starttime, _ := strconv.ParseFloat(fields[19], 64)
+	ch <- prometheus.MustNewConstMetric(c.cpu, prometheus.CounterValue, utime/userHZ, "user")
+	ch <- prometheus.MustNewConstMetric(c.cpu, prometheus.CounterValue, stime/userHZ, "system")
+	ch <- prometheus.MustNewConstMetric(c.threads, prometheus.GaugeValue, threads)
+	if boot, err := bootTime(); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.start, prometheus.GaugeValue, boot+starttime/userHZ)
+	}
+	if rss, err := statusValue(procPath(currentTargetPID(), "status"), "VmRSS"); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.rss, prometheus.GaugeValue, rss*1024)
+	}
+	if fds, err := ioutil.ReadDir(procPath(currentTargetPID(), "fd")); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.fds, prometheus.GaugeValue, float64(len(fds)))
+	}
+	if max, err := maxOpenFiles(procPath(currentTargetPID(), "limits")); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.maxFDs, prometheus.GaugeValue, max)
+	}
+}
+
+// maxOpenFiles returns the soft limit of the Max open files line of a
+// /proc/<pid>/limits file.
+func maxOpenFiles(filename string) (float64, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(line, "Max open files") {
+			fields := strings.Fields(line[len("Max open files"):])
+			if len(fields) == 0 {
+				break
+			}
+			if fields[0] == "unlimited" {
+				return math.Inf(1), nil
+			}
+			return strconv.ParseFloat(fields[0], 64)
+		}
+	}
+	return 0, fmt.Errorf("no Max open files in %s", filename)
+}
+
+// bootTime returns the btime line of /proc/stat, the boot time in seconds
+// since the unix epoch.
+func bootTime() (float64, error) {
+	b, err := ioutil.ReadFile(procPath("stat"))
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(line, "btime ") {
+			return strconv.ParseFloat(strings.TrimSpace(line[len("btime "):]), 64)
+		}
+	}
+	return 0, fmt.Errorf("no btime in %s", procPath("stat"))
+}
+
+// statusValue returns the number in the key: line of a /proc/<pid>/status
+// file, without its unit.
+func statusValue(filename, key string) (float64, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 2 && fields[0] == key+":" {
+			return strconv.ParseFloat(fields[1], 64)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no %s in %s", key, filename)
+}
+
+// processStat returns the fields of /proc/<pid>/stat after the command name.
+// The name in parentheses may contain anything, so fields are counted from
+// the last closing parenthesis: fields[0] is the state, field 3 in proc(5).
+func processStat(pid string) ([]string, error) {
+	stat, err := ioutil.ReadFile(procPath(pid, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	if len(fields) < 20 {
+		return nil, errors.New("too few fields in " + procPath(pid, "stat"))
+	}
+	return fields, nil
+}
+
+// processStartTime returns when pid started, in clock ticks after boot, or ""
+// if it has exited. Together with the PID it identifies a process, as PIDs are
+// reused.
+func processStartTime(pid string) string {
+	fields, err := processStat(pid)
+	if err != nil {
+		return ""
+	}
+	return fields[19]
+}
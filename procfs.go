@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+var (
+	// procfsPath is where the procfs we look for targets in is mounted.
+	procfsPath string
+
+	// hostProcfsCandidates are where container deployments conventionally
+	// mount the host's /proc.
+	hostProcfsCandidates = []string{"/host/proc", "/proc_host", "/rootfs/proc"}
+)
+
+// procPath joins elem onto procfsPath.
+func procPath(elem ...string) string {
+	return filepath.Join(append([]string{procfsPath}, elem...)...)
+}
+
+// detectProcfs picks the procfs to use when --path.procfs wasn't given: our
+// own /proc, unless we're in a PID namespace of our own (as in nearly every
+// container) and one of the usual host mounts is present, in which case the
+// host's procfs is what can actually see the target.
+func detectProcfs() string {
+	self, err := os.Readlink("/proc/self/ns/pid")
+	if err != nil {
+		return "/proc"
+	}
+	for _, candidate := range hostProcfsCandidates {
+		host, err := os.Readlink(filepath.Join(candidate, "1", "ns", "pid"))
+		if err != nil {
+			continue
+		}
+		if host != self {
+			slog.Info("/proc is namespaced, using the host procfs (override with --path.procfs)", "path", candidate)
+			return candidate
+		}
+	}
+	return "/proc"
+}
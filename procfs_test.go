@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeProcess is a process in a fake procfs: its status Name:, command line,
+// network namespace link, the socket inodes it has open and its net tables
+// by protocol.
+type fakeProcess struct {
+	pid, name, cmdline, netns string
+	sockets                   []string
+	tables                    map[string]string
+}
+
+// fakeProcfs points procfsPath at a procfs holding procs for the rest of the
+// test.
+func fakeProcfs(t *testing.T, procs ...fakeProcess) {
+	dir := t.TempDir()
+	saved := procfsPath
+	t.Cleanup(func() { procfsPath = saved })
+	procfsPath = dir
+
+	write := func(name, content string) {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := func(target, name string) {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range procs {
+		write(filepath.Join(dir, p.pid, "status"), "Name:\t"+p.name+"\nState:\tS (sleeping)\n")
+		write(filepath.Join(dir, p.pid, "cmdline"), strings.Replace(p.cmdline, " ", "\x00", -1)+"\x00")
+		if p.netns != "" {
+			link(p.netns, filepath.Join(dir, p.pid, "ns", "net"))
+		}
+		if err := os.MkdirAll(filepath.Join(dir, p.pid, "fd"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for i, inode := range p.sockets {
+			link("socket:["+inode+"]", filepath.Join(dir, p.pid, "fd", strconv.Itoa(i+3)))
+		}
+		for protocol, table := range p.tables {
+			write(filepath.Join(dir, p.pid, "net", protocol), table)
+		}
+	}
+}
+
+// udpTable returns a procfs UDP table with a socket bound to 127.0.0.1 per
+// "port inode" pair in sockets.
+func udpTable(sockets ...string) string {
+	table := procfsHeader
+	for i, s := range sockets {
+		fields := strings.Fields(s)
+		port, _ := strconv.Atoi(fields[0])
+		table += " " + strconv.Itoa(i) + ": 0100007F:" + strings.ToUpper(strconv.FormatInt(int64(port), 16)) +
+			" 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 " + fields[1] + " 2 0000000000000000 0\n"
+	}
+	return table
+}
+
+func TestFindPIDsCancelled(t *testing.T) {
+	fakeProcfs(t,
+		fakeProcess{pid: "10", name: "statsd"},
+		fakeProcess{pid: "20", name: "statsd"},
+		fakeProcess{pid: "30", name: "statsd"},
+	)
+
+	// Shutting down mid-walk abandons it with the context's error rather
+	// than taking the exporter down.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	found, err := findPIDs(ctx, func(_ *procWalk, pid, name string) bool {
+		cancel()
+		return true
+	})
+	if err != context.Canceled || found != nil {
+		t.Errorf("findPIDs() = %v, %v, want nil, context.Canceled", found, err)
+	}
+}
+
+func TestFindPIDsSkipsGoneProcesses(t *testing.T) {
+	fakeProcfs(t,
+		fakeProcess{pid: "10", name: "statsd"},
+		fakeProcess{pid: "20", name: "statsd"},
+	)
+	// 30 exited between its directory being listed and its status read,
+	// and 40's status was cut short as it exited.
+	if err := os.MkdirAll(filepath.Join(procfsPath, "30"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("gone", filepath.Join(procfsPath, "30", "status")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(procfsPath, "40"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(procfsPath, "40", "status"), []byte("Name:"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := findPIDs(context.Background(), func(*procWalk, string, string) bool { return true })
+	if err != nil {
+		t.Fatalf("findPIDs() error = %v", err)
+	}
+	if got, want := foundPIDs(found), []string{"10", "20"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("findPIDs() = %q, want %q", got, want)
+	}
+	if misses := nearMisses(context.Background(), localSelection{procName: "statsd"}); len(misses) != 1 {
+		t.Errorf("nearMisses() = %q, want PID 10 passed over", misses)
+	}
+}
+
+func TestPIDGroupRescan(t *testing.T) {
+	fakeProcfs(t,
+		fakeProcess{pid: "10", name: "statsd"},
+		fakeProcess{pid: "20", name: "statsd"},
+		fakeProcess{pid: "30", name: "other"},
+	)
+	defer func(mode string) { matchMode = mode }(matchMode)
+	matchMode = "per-pid"
+	match := func(_ *procWalk, _, name string) bool { return name == "statsd" }
+
+	watching, _, err := matchTargets(context.Background(), match, []string{"statsd"})
+	if err != nil {
+		t.Fatalf("matchTargets() error = %v", err)
+	}
+	if len(watching) != 2 || watching[0].group == nil || watching[0].group != watching[1].group {
+		t.Fatalf("matchTargets() = %d targets, want 2 of one group", len(watching))
+	}
+
+	// A worker started since 10 and 20 were found gets a target of its own.
+	found := []foundProcess{{pid: "10", name: "statsd"}, {pid: "20", name: "statsd"}, {pid: "40", name: "statsd"}}
+	added := watching[0].group.newTargets(found, watching)
+	if len(added) != 1 {
+		t.Fatalf("newTargets() = %d targets, want 1", len(added))
+	}
+	if want := []string{"statsd", "40"}; !reflect.DeepEqual(added[0].labels, want) || !added[0].expire {
+		t.Errorf("newTargets() labels = %q, expire = %v, want %q, true", added[0].labels, added[0].expire, want)
+	}
+}
+
+func TestRescanPIDs(t *testing.T) {
+	pids := []string{"10", "20"}
+	tgt := newAggregateTarget("statsd", pids, nil)
+	tgt.rediscover = func(context.Context) ([]string, error) { return pids, nil }
+
+	// Unchanged members keep the drop totals as they are.
+	if err := tgt.rescanPIDs(context.Background()); err != nil {
+		t.Fatalf("rescanPIDs() error = %v", err)
+	}
+	if tgt.rebaseDrops {
+		t.Error("rescanPIDs() rebased drops for the same processes")
+	}
+
+	// A new worker joins those still running, rebasing the totals on it.
+	pids = []string{"10", "20", "40"}
+	if err := tgt.rescanPIDs(context.Background()); err != nil {
+		t.Fatalf("rescanPIDs() error = %v", err)
+	}
+	if got := tgt.currentPIDs(); !reflect.DeepEqual(got, pids) || !tgt.rebaseDrops {
+		t.Errorf("rescanPIDs() = %q, rebaseDrops = %v, want %q, true", got, tgt.rebaseDrops, pids)
+	}
+	if tgt.sdLabels["pid"] != "10,20,40" {
+		t.Errorf("sd pid label = %q, want 10,20,40", tgt.sdLabels["pid"])
+	}
+}
+
+func TestCgroupPIDs(t *testing.T) {
+	// A v2 slice: no processes of its own, only in its leaf cgroups.
+	dir := t.TempDir()
+	for path, procs := range map[string]string{
+		"":                                  "",
+		"statsd.service":                    "10\n11\n",
+		"relay.slice/relay@1.service":       "20\n",
+		"relay.slice/relay@2.service":       "21\n",
+		"relay.slice":                       "",
+		"relay.slice/relay@2.service/child": "22\n",
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, path, "cgroup.procs"), []byte(procs), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tgt, err := newCgroupTarget(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tgt.sdLabels["pid"], "20,21,22,10,11"; got != want {
+		t.Errorf("newCgroupTarget() PIDs = %q, want %q", got, want)
+	}
+
+	if err := os.RemoveAll(filepath.Join(dir, "relay.slice")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tgt.members(), []string{"10", "11"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("members() = %q, want %q", got, want)
+	}
+
+	if _, err := cgroupPIDs(filepath.Join(dir, "gone.service")); !os.IsNotExist(err) {
+		t.Errorf("cgroupPIDs() of a missing cgroup = %v, want a not exist error", err)
+	}
+}
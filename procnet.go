@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// parseProcfsAddr decodes an address:port field of a procfs net table, e.g.
+// 0100007F:04D2 for 127.0.0.1:1234. The address is printed as 32-bit words
+// in host byte order, which on every architecture we run on is little endian.
+func parseProcfsAddr(s string) (net.IP, int, error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return nil, 0, fmt.Errorf("malformed address %q", s)
+	}
+	raw, err := hex.DecodeString(s[:i])
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return nil, 0, fmt.Errorf("malformed address %q", s)
+	}
+	port, err := strconv.ParseUint(s[i+1:], 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("malformed port in %q", s)
+	}
+
+	ip := make(net.IP, len(raw))
+	for w := 0; w < len(raw); w += 4 {
+		binary.BigEndian.PutUint32(ip[w:], binary.LittleEndian.Uint32(raw[w:]))
+	}
+	return ip, int(port), nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+// pushVictoriaMetrics pushes everything registered to the VictoriaMetrics
+// import endpoint at importURL (e.g.
+// http://vm:8428/api/v1/import/prometheus) every interval. extraLabels are
+// name=value pairs VictoriaMetrics adds to every pushed series. It returns
+// once ctx is done.
+func pushVictoriaMetrics(ctx context.Context, importURL string, extraLabels []string, interval time.Duration) {
+	u, err := url.Parse(importURL)
+	if err != nil {
+		slog.Error("Invalid VictoriaMetrics push URL", "err", err)
+		return
+	}
+	q := u.Query()
+	for _, l := range extraLabels {
+		q.Add("extra_label", l)
+	}
+	u.RawQuery = q.Encode()
+
+	client := &http.Client{Timeout: interval}
+	for sleepContext(ctx, interval) {
+		if err := pushOnce(ctx, client, u.String()); err != nil {
+			slog.Warn("Unable to push to VictoriaMetrics", "err", err)
+		}
+	}
+}
+
+func pushOnce(ctx context.Context, client *http.Client, importURL string) error {
+	families, err := snapshotGatherer().Gather()
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	for _, f := range families {
+		if _, err := expfmt.MetricFamilyToText(&body, f); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest("POST", importURL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", string(expfmt.FmtText))
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
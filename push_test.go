@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// pushTime is when the push sinks' tests send their samples.
+var pushTime = time.Unix(1700000000, 0)
+
+// pushFamilies returns families like those the exporter gathers: a gauge and
+// a counter by protocol, a histogram, a Go runtime metric and, last, a gauge
+// with no value yet. The sinks' encoders
+// sort labels in place, so each test gets its own.
+func pushFamilies() []*dto.MetricFamily {
+	label := func(name, value string) *dto.LabelPair {
+		return &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)}
+	}
+	return []*dto.MetricFamily{
+		{
+			Name: proto.String("udp_procfs_buffer_queued"),
+			Help: proto.String("The number of queued UDP messages in the linux buffer."),
+			Type: dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{
+				{Label: []*dto.LabelPair{label("protocol", "udp")}, Gauge: &dto.Gauge{Value: proto.Float64(7488)}},
+				{Label: []*dto.LabelPair{label("protocol", "udp6")}, Gauge: &dto.Gauge{Value: proto.Float64(0)}},
+			},
+		},
+		{
+			Name: proto.String("udp_procfs_buffer_dropped"),
+			Help: proto.String("The number of dropped UDP messages in the linux buffer."),
+			Type: dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{
+				{Label: []*dto.LabelPair{label("protocol", "udp")}, Counter: &dto.Counter{Value: proto.Float64(91)}},
+			},
+		},
+		{
+			Name: proto.String("udp_procfs_scrape_duration_seconds"),
+			Help: proto.String("How long the last poll took."),
+			Type: dto.MetricType_HISTOGRAM.Enum(),
+			Metric: []*dto.Metric{
+				{Histogram: &dto.Histogram{
+					SampleCount: proto.Uint64(4),
+					SampleSum:   proto.Float64(2.5),
+					Bucket: []*dto.Bucket{
+						{UpperBound: proto.Float64(0.1), CumulativeCount: proto.Uint64(1)},
+						{UpperBound: proto.Float64(1), CumulativeCount: proto.Uint64(3)},
+						{UpperBound: proto.Float64(math.Inf(+1)), CumulativeCount: proto.Uint64(4)},
+					},
+				}},
+			},
+		},
+		{
+			Name:   proto.String("go_goroutines"),
+			Help:   proto.String("Number of goroutines that currently exist."),
+			Type:   dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(8)}}},
+		},
+		{
+			Name:   proto.String("udp_procfs_buffer_utilization_ratio"),
+			Help:   proto.String("Queued bytes over the receive buffers."),
+			Type:   dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(math.NaN())}}},
+		},
+	}
+}
+
+// finiteFamilies is pushFamilies without the gauge that has no value, for the
+// sinks whose formats can't carry NaN.
+func finiteFamilies() []*dto.MetricFamily {
+	families := pushFamilies()
+	return families[:len(families)-1]
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// pushGateway pushes everything registered to the Pushgateway at gatewayURL
+// under job every interval, replacing what was pushed before for the same
+// grouping labels, given as name=value pairs. It returns once ctx is done.
+func pushGateway(ctx context.Context, gatewayURL, job string, grouping []string, interval time.Duration) {
+	pusher := push.New(gatewayURL, job).
+		Gatherer(snapshotGatherer()).
+		Client(&http.Client{Timeout: interval})
+	for _, l := range grouping {
+		i := strings.IndexByte(l, '=')
+		if i < 0 {
+			slog.Error("Invalid Pushgateway grouping label, must be name=value", "label", l)
+			return
+		}
+		pusher = pusher.Grouping(l[:i], l[i+1:])
+	}
+
+	for sleepContext(ctx, interval) {
+		if err := pusher.Push(); err != nil {
+			slog.Warn("Unable to push to the Pushgateway", "err", err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// qdiscStats is the subset of a qdisc's statistics we export.
+type qdiscStats struct {
+	device, kind, handle, parent string
+	drops, overlimits, backlog   uint64
+}
+
+// watchQdiscs periodically exports drop and overlimit counters for every
+// qdisc in the network namespace of targetPID.
+func watchQdiscs(ctx context.Context) {
+	qdiscLabels := []string{"device", "kind", "handle", "parent"}
+	qdiscDrops := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metricName(legacyExporterPrefix, "qdisc_drops_total"),
+			Help: "Packets dropped by a qdisc in the target's network namespace.",
+		},
+		qdiscLabels,
+	)
+	qdiscOverlimits := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metricName(legacyExporterPrefix, "qdisc_overlimits_total"),
+			Help: "Times a qdisc in the target's network namespace was over its limit.",
+		},
+		qdiscLabels,
+	)
+	qdiscBacklog := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: metricName(legacyExporterPrefix, "qdisc_backlog_bytes"),
+			Help: "Bytes currently queued in a qdisc in the target's network namespace.",
+		},
+		qdiscLabels,
+	)
+
+	prometheus.MustRegister(qdiscDrops)
+	prometheus.MustRegister(qdiscOverlimits)
+	prometheus.MustRegister(qdiscBacklog)
+
+	drops := newCounterTracker("qdisc", metricName(legacyExporterPrefix, "qdisc_drops_total"), qdiscDrops)
+	overlimits := newCounterTracker("qdisc", metricName(legacyExporterPrefix, "qdisc_overlimits_total"), qdiscOverlimits)
+	backlog := newGaugeGC("qdisc", metricName(legacyExporterPrefix, "qdisc_backlog_bytes"), qdiscBacklog)
+	for {
+		qdiscs, err := listQdiscs(procPath(currentTargetPID(), "ns", "net"))
+		if err != nil {
+			slog.Warn("Unable to collect qdisc stats", "err", err)
+		}
+		for _, q := range qdiscs {
+			drops.set(q.drops, q.device, q.kind, q.handle, q.parent)
+			overlimits.set(q.overlimits, q.device, q.kind, q.handle, q.parent)
+			if backlog.admit(q.device, q.kind, q.handle, q.parent) {
+				qdiscBacklog.WithLabelValues(q.device, q.kind, q.handle, q.parent).Set(float64(q.backlog))
+			}
+		}
+		drops.sweep()
+		overlimits.sweep()
+		backlog.sweep()
+
+		if !sleepContext(ctx, currentPollInterval()) {
+			return
+		}
+	}
+}
+
+// tcHandle formats a qdisc handle the way tc(8) prints it.
+func tcHandle(h uint32) string {
+	switch h {
+	case 0xffffffff:
+		return "root"
+	case 0xfffffff1:
+		return "ingress"
+	}
+	if h&0xffff == 0 {
+		return fmt.Sprintf("%x:", h>>16)
+	}
+	return fmt.Sprintf("%x:%x", h>>16, h&0xffff)
+}
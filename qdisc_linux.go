@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// Attribute types from linux/rtnetlink.h, linux/if_link.h and
+// linux/gen_stats.h.
+const (
+	tcaKind       = 1
+	tcaStats      = 3
+	tcaStats2     = 7
+	tcaStatsQueue = 3
+	iflaIfname    = 3
+
+	sizeofTcMsg     = 20
+	sizeofIfInfoMsg = 16
+)
+
+// listQdiscs dumps every qdisc in the network namespace at nsPath over
+// rtnetlink.
+func listQdiscs(nsPath string) ([]qdiscStats, error) {
+	fd, err := netlinkDial(nsPath, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(fd)
+
+	links, err := netlinkDump(fd, unix.RTM_GETLINK, make([]byte, sizeofIfInfoMsg))
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int32]string, len(links))
+	for _, m := range links {
+		if len(m) < sizeofIfInfoMsg {
+			continue
+		}
+		index := int32(binary.LittleEndian.Uint32(m[4:]))
+		netlinkAttrs(m[sizeofIfInfoMsg:], func(typ uint16, data []byte) {
+			if typ == iflaIfname {
+				names[index] = string(bytes.TrimRight(data, "\x00"))
+			}
+		})
+	}
+
+	msgs, err := netlinkDump(fd, unix.RTM_GETQDISC, make([]byte, sizeofTcMsg))
+	if err != nil {
+		return nil, err
+	}
+	qdiscs := make([]qdiscStats, 0, len(msgs))
+	for _, m := range msgs {
+		if len(m) < sizeofTcMsg {
+			continue
+		}
+		index := int32(binary.LittleEndian.Uint32(m[4:]))
+		q := qdiscStats{
+			device: names[index],
+			handle: tcHandle(binary.LittleEndian.Uint32(m[8:])),
+			parent: tcHandle(binary.LittleEndian.Uint32(m[12:])),
+		}
+		if q.device == "" {
+			q.device = strconv.Itoa(int(index))
+		}
+
+		haveStats2 := false
+		netlinkAttrs(m[sizeofTcMsg:], func(typ uint16, data []byte) {
+			switch typ {
+			case tcaKind:
+				q.kind = string(bytes.TrimRight(data, "\x00"))
+			case tcaStats2:
+				netlinkAttrs(data, func(typ uint16, data []byte) {
+					// struct gnet_stats_queue
+					if typ == tcaStatsQueue && len(data) >= 20 {
+						haveStats2 = true
+						q.backlog = uint64(binary.LittleEndian.Uint32(data[4:]))
+						q.drops = uint64(binary.LittleEndian.Uint32(data[8:]))
+						q.overlimits = uint64(binary.LittleEndian.Uint32(data[16:]))
+					}
+				})
+			case tcaStats:
+				// struct tc_stats, only used by kernels without TCA_STATS2.
+				if !haveStats2 && len(data) >= 36 {
+					q.drops = uint64(binary.LittleEndian.Uint32(data[12:]))
+					q.overlimits = uint64(binary.LittleEndian.Uint32(data[16:]))
+					q.backlog = uint64(binary.LittleEndian.Uint32(data[32:]))
+				}
+			}
+		})
+		qdiscs = append(qdiscs, q)
+	}
+	return qdiscs, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+func listQdiscs(nsPath string) ([]qdiscStats, error) {
+	return nil, errors.New("qdisc statistics are only supported on linux")
+}
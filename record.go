@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recording is set by --record to archive every procfs table the local
+// target's polls read.
+var recording *recorder
+
+// recordEntry is one table read during a poll. An archive is a gzip stream
+// of these as JSON lines.
+type recordEntry struct {
+	Poll     int       `json:"poll"`
+	Time     time.Time `json:"time"`
+	Protocol string    `json:"protocol"`
+	Data     string    `json:"data"`
+}
+
+type recorder struct {
+	mu  sync.Mutex
+	f   *os.File
+	gz  *gzip.Writer
+	enc *json.Encoder
+}
+
+func newRecorder(filename string) (*recorder, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(f)
+	return &recorder{f: f, gz: gz, enc: json.NewEncoder(gz)}, nil
+}
+
+// tee buffers the table in r, archives it and returns the buffered copy.
+// Every entry is flushed to disk as it's written, so an archive cut short by
+// the exporter being killed is still readable up to that point.
+func (rec *recorder) tee(poll int, protocol string, r io.Reader) io.Reader {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		return io.MultiReader(&buf, errReader{err})
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	err := rec.enc.Encode(recordEntry{Poll: poll, Time: time.Now(), Protocol: protocol, Data: buf.String()})
+	if err == nil {
+		err = rec.gz.Flush()
+	}
+	if err != nil {
+		slog.Warn("Unable to record table", "protocol", protocol, "err", err)
+	}
+	return &buf
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+// newReplayTarget returns a target that plays back an archive written by
+// --record, one recorded poll every --poll-interval, and keeps serving the
+// last one once it reaches the end. Going by time rather than counting opens keeps
+// the http_sd endpoint's reads from skipping ahead.
+func newReplayTarget(filename string) (*target, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var polls []map[string]string
+	last := -1
+	dec := json.NewDecoder(bufio.NewReader(gz))
+	for {
+		var e recordEntry
+		err := dec.Decode(&e)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// A recording that was never closed ends without the gzip
+			// footer, which is fine.
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		if e.Poll != last {
+			polls = append(polls, make(map[string]string))
+			last = e.Poll
+		}
+		polls[len(polls)-1][e.Protocol] = e.Data
+	}
+	if len(polls) == 0 {
+		return nil, fmt.Errorf("%s: no recorded polls", filename)
+	}
+	slog.Info("Replaying recording", "file", filename, "polls", len(polls))
+
+	start := time.Now()
+	return &target{name: filename, open: func(ctx context.Context, protocol string) (io.ReadCloser, error) {
+		i := int(time.Since(start) / currentPollInterval())
+		if i >= len(polls) {
+			i = len(polls) - 1
+		}
+		b, ok := polls[i][protocol]
+		if !ok {
+			return nil, &os.PathError{Op: "replay", Path: filename + ":" + protocol, Err: os.ErrNotExist}
+		}
+		return ioutil.NopCloser(strings.NewReader(b)), nil
+	}}, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// targetPIDMu guards targetPID, which rediscovery updates while the
+// single-process collectors are reading it.
+var targetPIDMu sync.Mutex
+
+func currentTargetPID() string {
+	targetPIDMu.Lock()
+	defer targetPIDMu.Unlock()
+	return targetPID
+}
+
+func setTargetPID(pid string) {
+	targetPIDMu.Lock()
+	defer targetPIDMu.Unlock()
+	targetPID = pid
+}
+
+func (t *target) currentPIDs() []string {
+	t.stateMu.Lock()
+	defer t.stateMu.Unlock()
+	return t.pids
+}
+
+// setPIDs points t at pids, noting when each started so that a reused PID
+// isn't mistaken for the same process.
+func (t *target) setPIDs(pids []string) {
+	starts := make([]string, len(pids))
+	for i, pid := range pids {
+		starts[i] = processStartTime(pid)
+	}
+	t.stateMu.Lock()
+	defer t.stateMu.Unlock()
+	t.pids, t.starts = pids, starts
+}
+
+// livePIDs returns the PIDs of t that are still the processes setPIDs saw.
+// One that has exited, or whose PID now belongs to another process, is gone
+// as far as t is concerned.
+func (t *target) livePIDs() []string {
+	t.stateMu.Lock()
+	pids, starts := t.pids, t.starts
+	t.stateMu.Unlock()
+
+	live := make([]string, 0, len(pids))
+	for i, pid := range pids {
+		if start := processStartTime(pid); start != "" && start == starts[i] {
+			live = append(live, pid)
+		}
+	}
+	return live
+}
+
+// rediscoverPIDs looks for t's processes again once they have all exited, so
+// a restarted target is picked up on its new PID without restarting the
+// exporter. It's called from the watch loop every poll the target is absent,
+// and fails with ctx's error if ctx is done before the search is.
+func (t *target) rediscoverPIDs(ctx context.Context) error {
+	pids, err := t.rediscover(ctx)
+	if err != nil {
+		return err
+	}
+	t.observeResolution(len(pids) > 0)
+	if len(pids) == 0 {
+		return nil
+	}
+	// Zombies keep their /proc entry, name and start time after the tables
+	// are gone, and are the same process we already had.
+	old := t.livePIDs()
+	if strings.Join(old, ",") == strings.Join(pids, ",") {
+		return nil
+	}
+	joined := strings.Join(pids, ",")
+	t.setPIDs(pids)
+	t.stateMu.Lock()
+	t.sdLabels["pid"] = joined
+	t.stateMu.Unlock()
+
+	// The new sockets count their drops from zero, so start over as if this
+	// were the first poll rather than seeing the totals go backwards.
+	t.lastDropped = nil
+	slog.Info("Target restarted", "target", t.name, "pid", joined)
+	return nil
+}
+
+// refreshMembers points t at its members' current PIDs, if they've changed.
+// It's called from the watch loop before every poll.
+func (t *target) refreshMembers() {
+	t.changePIDs(t.members())
+}
+
+// rescanPIDs points t at the processes it looks for, while some of those it
+// has are still running, so that an aggregate target takes in workers that
+// started since. It's called from the watch loop every
+// --match-mode.rescan-interval, and fails with ctx's error if ctx is done
+// before the search is.
+func (t *target) rescanPIDs(ctx context.Context) error {
+	pids, err := t.rediscover(ctx)
+	if err != nil {
+		return err
+	}
+	t.rescannedAt = time.Now()
+	// None being found is the target exiting, which the poll sees.
+	if len(pids) > 0 {
+		t.changePIDs(pids)
+	}
+	return nil
+}
+
+// changePIDs points t at pids, if they aren't the ones it has, rebasing the
+// drop totals for the processes that joined or left.
+func (t *target) changePIDs(pids []string) {
+	if strings.Join(pids, ",") == strings.Join(t.currentPIDs(), ",") {
+		return
+	}
+	joined := strings.Join(pids, ",")
+	t.setPIDs(pids)
+	t.stateMu.Lock()
+	t.sdLabels["pid"] = joined
+	t.stateMu.Unlock()
+	t.rebaseDrops = true
+	slog.Debug("Target members changed", "target", t.name, "pid", joined)
+}
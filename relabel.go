@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"google.golang.org/protobuf/proto"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// relabelRule is one of the config file's relabel rules, in the format of
+// Prometheus's metric_relabel_configs.
+type relabelRule struct {
+	SourceLabels []string `yaml:"source_labels,flow,omitempty"`
+	Separator    *string  `yaml:"separator,omitempty"`
+	Regex        *string  `yaml:"regex,omitempty"`
+	Modulus      uint64   `yaml:"modulus,omitempty"`
+	TargetLabel  string   `yaml:"target_label,omitempty"`
+	Replacement  *string  `yaml:"replacement,omitempty"`
+	Action       string   `yaml:"action,omitempty"`
+
+	regex *regexp.Regexp
+}
+
+var (
+	// relabelRules rewrite or drop the gathered series, from the config
+	// file's relabel section, so that cardinality and naming can be
+	// controlled here rather than in every scrape config.
+	relabelRules []relabelRule
+	// relabelRulesMu guards relabelRules, as reloads can change them.
+	relabelRulesMu sync.Mutex
+)
+
+func setRelabelRules(rules []relabelRule) {
+	relabelRulesMu.Lock()
+	defer relabelRulesMu.Unlock()
+	relabelRules = rules
+}
+
+// configRelabel returns the config file's relabel section, v, as the rules it
+// lists, checked and with Prometheus's defaults filled in.
+func configRelabel(v interface{}) ([]relabelRule, error) {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var rules []relabelRule
+	if err := yaml.UnmarshalStrict(b, &rules); err != nil {
+		return nil, fmt.Errorf("relabel: %v", err)
+	}
+	for i := range rules {
+		r := &rules[i]
+		if r.Separator == nil {
+			r.Separator = proto.String(";")
+		}
+		if r.Regex == nil {
+			r.Regex = proto.String("(.*)")
+		}
+		if r.Replacement == nil {
+			r.Replacement = proto.String("$1")
+		}
+		if r.Action == "" {
+			r.Action = "replace"
+		}
+		if r.regex, err = regexp.Compile("^(?:" + *r.Regex + ")$"); err != nil {
+			return nil, fmt.Errorf("relabel rule %d: invalid regex: %v", i+1, err)
+		}
+		switch r.Action {
+		case "replace", "keep", "drop", "labelmap", "labeldrop", "labelkeep":
+		case "hashmod":
+			if r.Modulus == 0 {
+				return nil, fmt.Errorf("relabel rule %d: hashmod needs a modulus", i+1)
+			}
+		default:
+			return nil, fmt.Errorf("relabel rule %d: unknown action %q", i+1, r.Action)
+		}
+		if (r.Action == "replace" || r.Action == "hashmod") && r.TargetLabel == "" {
+			return nil, fmt.Errorf("relabel rule %d: %s needs a target_label", i+1, r.Action)
+		}
+	}
+	return rules, nil
+}
+
+// relabelFamilies applies the relabel rules to every series of families, and
+// returns the families of those that are kept. Renamed series move to the
+// family of their new name. Counters and gauges that end up with the same
+// labels, e.g. sockets whose ports were hashed into the same bucket, are
+// added together; it's an error for other series to, or for a series to move
+// into a family of another type.
+func relabelFamilies(families []*dto.MetricFamily) ([]*dto.MetricFamily, error) {
+	relabelRulesMu.Lock()
+	defer relabelRulesMu.Unlock()
+	if len(relabelRules) == 0 {
+		return families, nil
+	}
+
+	var relabeled []*dto.MetricFamily
+	byName := make(map[string]*dto.MetricFamily)
+	seen := make(map[string]*dto.Metric)
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			labels := map[string]string{model.MetricNameLabel: f.GetName()}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if !relabel(labels, relabelRules) {
+				continue
+			}
+
+			name := labels[model.MetricNameLabel]
+			m.Label = m.Label[:0]
+			for label, value := range labels {
+				if !strings.HasPrefix(label, model.ReservedLabelPrefix) {
+					m.Label = append(m.Label, &dto.LabelPair{Name: proto.String(label), Value: proto.String(value)})
+				}
+			}
+			sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+
+			into, ok := byName[name]
+			if !ok {
+				into = &dto.MetricFamily{Name: proto.String(name), Help: f.Help, Type: f.Type, Unit: f.Unit}
+				byName[name] = into
+				relabeled = append(relabeled, into)
+			} else if into.GetType() != f.GetType() {
+				return nil, fmt.Errorf("relabeling moved %s series into %s, which is a %s", f.GetType(), name, into.GetType())
+			}
+
+			key := name
+			for _, l := range m.Label {
+				key += "\xff" + l.GetName() + "\xff" + l.GetValue()
+			}
+			if same := seen[key]; same != nil {
+				switch f.GetType() {
+				case dto.MetricType_COUNTER:
+					same.Counter.Value = proto.Float64(same.Counter.GetValue() + m.Counter.GetValue())
+				case dto.MetricType_GAUGE:
+					same.Gauge.Value = proto.Float64(same.Gauge.GetValue() + m.Gauge.GetValue())
+				case dto.MetricType_UNTYPED:
+					same.Untyped.Value = proto.Float64(same.Untyped.GetValue() + m.Untyped.GetValue())
+				default:
+					return nil, fmt.Errorf("relabeling made two %s series with the same labels", name)
+				}
+				continue
+			}
+			seen[key] = m
+			into.Metric = append(into.Metric, m)
+		}
+	}
+	return relabeled, nil
+}
+
+// relabel applies rules to labels, as Prometheus does, and returns whether
+// the series is kept.
+func relabel(labels map[string]string, rules []relabelRule) bool {
+	for _, r := range rules {
+		values := make([]string, len(r.SourceLabels))
+		for i, label := range r.SourceLabels {
+			values[i] = labels[label]
+		}
+		value := strings.Join(values, *r.Separator)
+
+		switch r.Action {
+		case "keep":
+			if !r.regex.MatchString(value) {
+				return false
+			}
+		case "drop":
+			if r.regex.MatchString(value) {
+				return false
+			}
+		case "replace":
+			match := r.regex.FindStringSubmatchIndex(value)
+			if match == nil {
+				continue
+			}
+			target := string(r.regex.ExpandString(nil, r.TargetLabel, value, match))
+			if !model.LabelName(target).IsValid() {
+				continue
+			}
+			if replaced := string(r.regex.ExpandString(nil, *r.Replacement, value, match)); replaced != "" {
+				labels[target] = replaced
+			} else {
+				delete(labels, target)
+			}
+		case "hashmod":
+			// The last 8 bytes of the MD5, as Prometheus uses, so that
+			// the buckets are the same as a scrape config's.
+			sum := md5.Sum([]byte(value))
+			labels[r.TargetLabel] = strconv.FormatUint(binary.BigEndian.Uint64(sum[8:])%r.Modulus, 10)
+		case "labelmap":
+			mapped := make(map[string]string)
+			for label, v := range labels {
+				if r.regex.MatchString(label) {
+					mapped[r.regex.ReplaceAllString(label, *r.Replacement)] = v
+				}
+			}
+			for label, v := range mapped {
+				labels[label] = v
+			}
+		case "labeldrop", "labelkeep":
+			for label := range labels {
+				if label != model.MetricNameLabel && r.regex.MatchString(label) == (r.Action == "labeldrop") {
+					delete(labels, label)
+				}
+			}
+		}
+	}
+	return true
+}
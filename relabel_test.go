@@ -0,0 +1,175 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// gatheredFamilies returns families like those /metrics gathers: a gauge and
+// a counter by protocol, a histogram and a Go runtime metric. Relabeling
+// rewrites them in place, so each test gets its own.
+func gatheredFamilies() []*dto.MetricFamily {
+	label := func(name, value string) *dto.LabelPair {
+		return &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)}
+	}
+	return []*dto.MetricFamily{
+		{
+			Name: proto.String("udp_exporter_buffer_queued"),
+			Type: dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{
+				{Label: []*dto.LabelPair{label("protocol", "udp")}, Gauge: &dto.Gauge{Value: proto.Float64(7488)}},
+				{Label: []*dto.LabelPair{label("protocol", "udp6")}, Gauge: &dto.Gauge{Value: proto.Float64(0)}},
+			},
+		},
+		{
+			Name: proto.String("udp_exporter_buffer_dropped"),
+			Type: dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{
+				{Label: []*dto.LabelPair{label("protocol", "udp")}, Counter: &dto.Counter{Value: proto.Float64(91)}},
+			},
+		},
+		{
+			Name: proto.String("udp_exporter_scrape_duration_seconds"),
+			Type: dto.MetricType_HISTOGRAM.Enum(),
+			Metric: []*dto.Metric{
+				{Histogram: &dto.Histogram{
+					SampleCount: proto.Uint64(4),
+					SampleSum:   proto.Float64(2.5),
+					Bucket: []*dto.Bucket{
+						{UpperBound: proto.Float64(1), CumulativeCount: proto.Uint64(3)},
+						{UpperBound: proto.Float64(math.Inf(+1)), CumulativeCount: proto.Uint64(4)},
+					},
+				}},
+			},
+		},
+		{
+			Name:   proto.String("go_goroutines"),
+			Type:   dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(8)}}},
+		},
+	}
+}
+
+// relabelSeries relabels families with the rules in doc, a relabel section,
+// and returns each series kept as its name, labels and value.
+func relabelSeries(t *testing.T, doc string, families []*dto.MetricFamily) ([]string, error) {
+	t.Helper()
+	var section interface{}
+	if err := yaml.Unmarshal([]byte(doc), &section); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := configRelabel(section)
+	if err != nil {
+		t.Fatalf("configRelabel() error = %v", err)
+	}
+	setRelabelRules(rules)
+	defer setRelabelRules(nil)
+
+	relabeled, err := relabelFamilies(families)
+	var series []string
+	for _, f := range relabeled {
+		for _, m := range f.GetMetric() {
+			var labels []string
+			for _, l := range m.GetLabel() {
+				labels = append(labels, l.GetName()+"="+l.GetValue())
+			}
+			value := m.GetGauge().GetValue() + m.GetCounter().GetValue()
+			series = append(series, f.GetName()+"{"+strings.Join(labels, ",")+"} "+strconv.FormatFloat(value, 'g', -1, 64))
+		}
+	}
+	return series, err
+}
+
+func TestRelabelFamilies(t *testing.T) {
+	tests := []struct {
+		name, rules string
+		want        []string
+	}{
+		{
+			name:  "drop by name",
+			rules: "- source_labels: [__name__]\n  regex: udp_exporter_buffer_queued|go_.*\n  action: drop\n",
+			want:  []string{"udp_exporter_buffer_dropped{protocol=udp} 91"},
+		},
+		{
+			name:  "keep by label",
+			rules: "- source_labels: [protocol]\n  regex: udp6\n  action: keep\n",
+			want:  []string{"udp_exporter_buffer_queued{protocol=udp6} 0"},
+		},
+		{
+			name:  "rewrite a label value",
+			rules: "- source_labels: [__name__]\n  regex: go_.*\n  action: drop\n- source_labels: [protocol]\n  regex: udp(.*)\n  target_label: ip\n  replacement: ipv4$1\n- regex: protocol\n  action: labeldrop\n",
+			want: []string{
+				"udp_exporter_buffer_queued{ip=ipv4} 7488",
+				"udp_exporter_buffer_queued{ip=ipv46} 0",
+				"udp_exporter_buffer_dropped{ip=ipv4} 91",
+			},
+		},
+		{
+			name:  "hash into buckets and add up what's left the same",
+			rules: "- source_labels: [__name__]\n  regex: go_.*\n  action: drop\n- source_labels: [protocol]\n  modulus: 1\n  target_label: bucket\n  action: hashmod\n- regex: bucket\n  action: labelkeep\n",
+			want: []string{
+				"udp_exporter_buffer_queued{bucket=0} 7488",
+				"udp_exporter_buffer_dropped{bucket=0} 91",
+			},
+		},
+		{
+			name:  "rename",
+			rules: "- source_labels: [__name__]\n  regex: udp_exporter_buffer_(queued|dropped)\n  target_label: __name__\n  replacement: statsd_$1\n- source_labels: [__name__]\n  regex: statsd_.*\n  action: keep\n",
+			want: []string{
+				"statsd_queued{protocol=udp} 7488",
+				"statsd_queued{protocol=udp6} 0",
+				"statsd_dropped{protocol=udp} 91",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The gauge, counter and Go runtime gauge.
+			families := gatheredFamilies()
+			got, err := relabelSeries(t, tt.rules, append(families[:2], families[3]))
+			if err != nil {
+				t.Fatalf("relabelFamilies() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("relabelFamilies():\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestRelabelFamiliesConflicts(t *testing.T) {
+	histograms := []*dto.MetricFamily{gatheredFamilies()[2], gatheredFamilies()[2]}
+	histograms[1].Name = proto.String("udp_exporter_poll_duration_seconds")
+	rename := "- source_labels: [__name__]\n  regex: .*_seconds\n  target_label: __name__\n  replacement: duration_seconds\n"
+	if _, err := relabelSeries(t, rename, histograms); err == nil {
+		t.Error("merging two histograms succeeded, want an error")
+	}
+	if _, err := relabelSeries(t, rename+"- source_labels: [__name__]\n  regex: go_goroutines\n  target_label: __name__\n  replacement: duration_seconds\n", gatheredFamilies()[2:4]); err == nil {
+		t.Error("moving a gauge into a histogram succeeded, want an error")
+	}
+}
+
+func TestConfigRelabelErrors(t *testing.T) {
+	for _, doc := range []string{
+		"- action: replace\n",
+		"- action: hashmod\n  target_label: bucket\n",
+		"- action: rewrite\n",
+		"- regex: '('\n  action: drop\n",
+		"- source_label: [port]\n  action: drop\n",
+	} {
+		var section interface{}
+		if err := yaml.Unmarshal([]byte(doc), &section); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := configRelabel(section); err == nil {
+			t.Errorf("configRelabel(%q) succeeded, want an error", doc)
+		}
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+var (
+	// targetPollIntervals are the --target.poll-interval overrides of
+	// pollInterval for the targets of some --target, --ssh.hosts or --netns
+	// entries.
+	targetPollIntervals map[string]time.Duration
+
+	// pollIntervalMu guards pollInterval and targetPollIntervals once the
+	// collectors are running, as reloads can change them.
+	pollIntervalMu sync.Mutex
+)
+
+func currentPollInterval() time.Duration {
+	pollIntervalMu.Lock()
+	defer pollIntervalMu.Unlock()
+	return pollInterval
+}
+
+func setPollInterval(d time.Duration) {
+	pollIntervalMu.Lock()
+	defer pollIntervalMu.Unlock()
+	pollInterval = d
+}
+
+// currentPollInterval returns how often t is polled: its entry's
+// --target.poll-interval, or else --poll-interval.
+func (t *target) currentPollInterval() time.Duration {
+	pollIntervalMu.Lock()
+	defer pollIntervalMu.Unlock()
+	if d, ok := targetPollIntervals[t.entry]; ok && t.entry != "" {
+		return d
+	}
+	return pollInterval
+}
+
+func setTargetPollIntervals(intervals map[string]time.Duration) {
+	pollIntervalMu.Lock()
+	defer pollIntervalMu.Unlock()
+	targetPollIntervals = intervals
+}
+
+// parseTargetPollIntervals parses --target.poll-interval's entry=duration
+// pairs. The entry is split off at the last =, as a netns path may hold one.
+func parseTargetPollIntervals(pairs []string) (map[string]time.Duration, error) {
+	intervals := make(map[string]time.Duration, len(pairs))
+	for _, pair := range pairs {
+		i := strings.LastIndexByte(pair, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("%q isn't entry=duration", pair)
+		}
+		d, err := time.ParseDuration(pair[i+1:])
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%q doesn't have a positive duration", pair)
+		}
+		intervals[pair[:i]] = d
+	}
+	return intervals, nil
+}
+
+var (
+	// reloader re-applies --config.file on SIGHUP or POST /-/reload, and
+	// is nil without one.
+	reloader *configReloader
+	// configWatch is set by --config.watch to also reload the config file
+	// whenever it changes.
+	configWatch bool
+)
+
+// configReloader applies the options of the config file that can change
+// without a restart: poll-interval, target.poll-interval, help, relabel, and
+// the targets of --target or --netns. Options set on the command line or in
+// the environment still win.
+type configReloader struct {
+	file  string
+	fixed map[string]bool
+}
+
+// targetSource is how the watched targets can change while running: key is
+// the option listing them, "target" or "netns", and add finds the targets of
+// one of its entries. It's nil when the targets can't change.
+type targetSource struct {
+	key string
+	add func(ctx context.Context, entry string) ([]*target, error)
+}
+
+var (
+	dynamicTargets *targetSource
+
+	// apiEntries are the entries added on /api/v1/targets and not since
+	// removed. Reloads keep watching them alongside the config file's.
+	apiEntries = make(map[string]bool)
+
+	// targetChangesMu keeps reloads and the targets API from changing the
+	// targets at once, and guards apiEntries.
+	targetChangesMu sync.Mutex
+)
+
+// newConfigReloader returns a reloader for file. It has to be called after
+// the environment is applied, so that the options it set count as fixed.
+func newConfigReloader(file string) *configReloader {
+	fixed := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		fixed[f.Name] = true
+	})
+	return &configReloader{file: file, fixed: fixed}
+}
+
+// reload reads the config file again and applies it. Targets found are
+// watched until ctx is done. A file that fails to apply changes nothing.
+func (r *configReloader) reload(ctx context.Context) error {
+	targetChangesMu.Lock()
+	defer targetChangesMu.Unlock()
+
+	b, err := ioutil.ReadFile(r.file)
+	if err != nil {
+		return err
+	}
+	config, err := parseConfigFile(r.file, b)
+	if err != nil {
+		return err
+	}
+
+	interval := currentPollInterval()
+	if v := config["poll-interval"]; v != nil && !r.fixed["poll-interval"] {
+		interval, err = time.ParseDuration(fmt.Sprint(v))
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("%s: invalid poll-interval %v", r.file, v)
+		}
+	}
+	var intervals map[string]time.Duration
+	changeIntervals := config["target.poll-interval"] != nil && !r.fixed["target.poll-interval"]
+	if changeIntervals {
+		if intervals, err = parseTargetPollIntervals(configList(config["target.poll-interval"])); err != nil {
+			return fmt.Errorf("%s: invalid target.poll-interval: %v", r.file, err)
+		}
+	}
+	var help map[string]string
+	if v := config["help"]; v != nil {
+		if help, err = configHelp(v); err != nil {
+			return fmt.Errorf("%s: %v", r.file, err)
+		}
+	}
+	var rules []relabelRule
+	if v := config["relabel"]; v != nil {
+		if rules, err = configRelabel(v); err != nil {
+			return fmt.Errorf("%s: %v", r.file, err)
+		}
+	}
+
+	// Find the new targets before stopping any old ones, so that one not
+	// being found leaves everything as it was.
+	var added, removed []*target
+	var forgotten []string
+	s := dynamicTargets
+	if s != nil && config[s.key] != nil && !r.fixed[s.key] {
+		entries := make(map[string]bool)
+		for _, e := range configList(config[s.key]) {
+			entries[e] = true
+		}
+		for e := range apiEntries {
+			entries[e] = true
+		}
+		watching := make(map[string]bool)
+		for _, t := range currentTargets() {
+			watching[t.entry] = true
+			if !entries[t.entry] {
+				removed = append(removed, t)
+			}
+		}
+		// Per-pid entries whose processes have all expired are still
+		// watched for new ones.
+		targetsMu.Lock()
+		for e := range pidGroups {
+			watching[e] = true
+			if !entries[e] {
+				forgotten = append(forgotten, e)
+			}
+		}
+		targetsMu.Unlock()
+		sorted := make([]string, 0, len(entries))
+		for e := range entries {
+			if !watching[e] {
+				sorted = append(sorted, e)
+			}
+		}
+		sort.Strings(sorted)
+		for _, e := range sorted {
+			targets, err := s.add(ctx, e)
+			if err != nil {
+				return fmt.Errorf("%s: %s %s: %v", r.file, s.key, e, err)
+			}
+			added = append(added, targets...)
+		}
+	}
+
+	if interval != currentPollInterval() {
+		slog.Info("Poll interval changed", "interval", interval)
+		setPollInterval(interval)
+	}
+	if changeIntervals {
+		setTargetPollIntervals(intervals)
+	}
+	setHelpOverrides(help)
+	setRelabelRules(rules)
+	if s != nil {
+		stopTargets(s.key, removed)
+		forgetPIDGroups(forgotten)
+		startTargets(ctx, s.key, added)
+	}
+	return nil
+}
+
+// startTargets watches targets, alongside those already watched, until ctx
+// is done. key names their entries in the log, unless it's empty.
+func startTargets(ctx context.Context, key string, targets []*target) {
+	targetsMu.Lock()
+	watchedTargets = append(watchedTargets, targets...)
+	addPIDGroups(targets)
+	targetsMu.Unlock()
+	for _, t := range targets {
+		if key != "" {
+			slog.Info("Started watching", key, t.entry)
+		}
+		t.setState(stateDiscovering)
+		t.start(ctx)
+	}
+}
+
+// stopTargets stops watching targets and deletes their series. key names
+// their entries in the log, unless it's empty.
+func stopTargets(key string, targets []*target) {
+	stopping := make(map[*target]bool, len(targets))
+	for _, t := range targets {
+		stopping[t] = true
+	}
+	targetsMu.Lock()
+	kept := watchedTargets[:0:0]
+	for _, t := range watchedTargets {
+		if !stopping[t] {
+			kept = append(kept, t)
+		}
+	}
+	watchedTargets = kept
+	if key != "" {
+		// Expired per-pid targets leave their selection watched for new
+		// processes, but ones whose entry was removed don't.
+		for _, t := range targets {
+			if t.group != nil {
+				delete(pidGroups, t.entry)
+			}
+		}
+	}
+	targetsMu.Unlock()
+
+	for _, t := range targets {
+		if key != "" {
+			slog.Info("Stopped watching", key, t.entry)
+		}
+		t.stop()
+		<-t.done
+		// A scrape polling t when it was removed could export it
+		// again after its series were deleted.
+		scrapeMu.Lock()
+		t.deleteSeries()
+		scrapeMu.Unlock()
+	}
+}
+
+// expireTarget stops watching t, whose process has been gone for
+// --series.retention, and deletes its series.
+func expireTarget(t *target) {
+	slog.Info("Target exited, deleting its series", "target", t.name, "pid", t.sdLabels["pid"])
+	targetChangesMu.Lock()
+	defer targetChangesMu.Unlock()
+	stopTargets("", []*target{t})
+}
+
+// configList returns the values of a config file option that can be a list
+// or a single value.
+func configList(v interface{}) []string {
+	list, ok := v.([]interface{})
+	if !ok {
+		if v == nil {
+			return nil
+		}
+		return []string{fmt.Sprint(v)}
+	}
+	values := make([]string, len(list))
+	for i, v := range list {
+		values[i] = fmt.Sprint(v)
+	}
+	return values
+}
+
+// deleteSeries stops exporting every series of a stopped target.
+func (t *target) deleteSeries() {
+	for _, protocol := range append(append([]string{}, protocols...), injectedProtocol) {
+		labels := append(append([]string{}, t.labels...), protocol)
+		udpBufferQueued.DeleteLabelValues(labels...)
+		udpBufferTxQueued.DeleteLabelValues(labels...)
+		if udpBufferUtilization != nil {
+			udpBufferUtilization.DeleteLabelValues(labels...)
+		}
+		udpBufferDropped.DeleteLabelValues(labels...)
+		udpBufferDroppedLastInterval.DeleteLabelValues(labels...)
+		parseErrors.DeleteLabelValues(labels...)
+		lastDrops.forget(labels)
+		udpBufferDroppedPeak.forget(labels)
+		udpBufferQueuedPeak.forget(labels)
+		if udpBufferQueuedZScore != nil {
+			udpBufferQueuedZScore.DeleteLabelValues(labels...)
+			udpBufferQueuedAnomalous.DeleteLabelValues(labels...)
+		}
+		if udpBufferQueuedEWMA != nil {
+			udpBufferQueuedEWMA.DeleteLabelValues(labels...)
+		}
+		if udpBufferQueuedSampled != nil {
+			udpBufferQueuedSampled.forget(labels)
+		}
+	}
+	for _, state := range states {
+		exporterState.DeleteLabelValues(append(append([]string{}, t.labels...), state)...)
+	}
+	if t.upValues != nil {
+		targetUp.DeleteLabelValues(t.upValues...)
+	}
+	pollDuration.DeleteLabelValues(t.labels...)
+	lastSuccessfulPoll.DeleteLabelValues(t.labels...)
+	for _, result := range []string{"found", "not_found"} {
+		targetResolutions.DeleteLabelValues(append(append([]string{}, t.labels...), result)...)
+	}
+}
+
+// watchReloads reloads the config file on every SIGHUP until ctx is done.
+func watchReloads(ctx context.Context) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	defer signal.Stop(c)
+	for {
+		select {
+		case <-c:
+			if err := reloader.reload(ctx); err != nil {
+				slog.Error("Unable to reload config file", "err", err)
+			} else {
+				slog.Info("Reloaded config file", "file", reloader.file)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// configWatchDebounce is how long --config.watch waits for changes to the
+// config file's directory to settle before reloading, as editors and
+// ConfigMap updates replace the file in several steps.
+const configWatchDebounce = time.Second
+
+// watchConfigFile reloads the config file whenever what it holds changes,
+// until ctx is done. Its directory is watched rather than the file itself,
+// to see the file replaced by a rename or, for a Kubernetes ConfigMap, by
+// swapping the symlink it's reached through.
+func watchConfigFile(ctx context.Context) error {
+	r := reloader
+	last, err := ioutil.ReadFile(r.file)
+	if err != nil {
+		return err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(r.file)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+		var settled <-chan time.Time
+		for {
+			select {
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				settled = time.After(configWatchDebounce)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("Error watching config file", "err", err)
+			case <-settled:
+				settled = nil
+				// A file that's missing is mid-replace, and one that
+				// didn't change was only touched or had a neighbour
+				// change. One that fails to apply is left for the next
+				// change rather than retried.
+				b, err := ioutil.ReadFile(r.file)
+				if err != nil || bytes.Equal(b, last) {
+					continue
+				}
+				last = b
+				if err := r.reload(ctx); err != nil {
+					slog.Error("Unable to reload changed config file", "err", err)
+				} else {
+					slog.Info("Reloaded changed config file", "file", r.file)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// enableLifecycle is set by --web.enable-lifecycle. Without it, the endpoints
+// that change what the exporter does refuse to, as anyone who can reach the
+// web port could otherwise call them.
+var enableLifecycle bool
+
+// serveReload reloads the config file on POST, watching any new targets
+// until ctx is done rather than just for the request.
+func serveReload(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Only POST requests allowed.", http.StatusMethodNotAllowed)
+			return
+		}
+		if !enableLifecycle {
+			http.Error(w, "Lifecycle API is not enabled, see --web.enable-lifecycle.", http.StatusForbidden)
+			return
+		}
+		if reloader == nil {
+			http.Error(w, "There's no --config.file to reload.", http.StatusBadRequest)
+			return
+		}
+		if err := reloader.reload(ctx); err != nil {
+			slog.Error("Unable to reload config file", "err", err)
+			http.Error(w, "Unable to reload config file: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		slog.Info("Reloaded config file", "file", reloader.file)
+		fmt.Fprintln(w, "Reloaded.")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTargetPollIntervals(t *testing.T) {
+	got, err := parseTargetPollIntervals([]string{"statsd=1s", "/var/run/netns/a=b=30s"})
+	if err != nil {
+		t.Fatalf("parseTargetPollIntervals() error = %v", err)
+	}
+	want := map[string]time.Duration{"statsd": time.Second, "/var/run/netns/a=b": 30 * time.Second}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseTargetPollIntervals() = %v, want %v", got, want)
+	}
+
+	for _, pair := range []string{"statsd", "=1s", "statsd=soon", "statsd=0s"} {
+		if _, err := parseTargetPollIntervals([]string{pair}); err == nil {
+			t.Errorf("parseTargetPollIntervals(%q) succeeded, want an error", pair)
+		}
+	}
+}
+
+func TestTargetPollInterval(t *testing.T) {
+	defer setTargetPollIntervals(nil)
+	setTargetPollIntervals(map[string]time.Duration{"statsd": time.Second})
+
+	for _, tt := range []struct {
+		entry string
+		want  time.Duration
+	}{
+		{"statsd", time.Second},
+		{"collectd", 10 * time.Second},
+		{"", 10 * time.Second},
+	} {
+		if got := (&target{entry: tt.entry}).currentPollInterval(); got != tt.want {
+			t.Errorf("currentPollInterval() of %q = %s, want %s", tt.entry, got, tt.want)
+		}
+	}
+}
+
+func TestWatchConfigFile(t *testing.T) {
+	defer func(r *configReloader) { reloader = r }(reloader)
+	defer setHelpOverrides(nil)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(file, []byte("help:\n  udp_exporter_buffer_dropped: old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	reloader = &configReloader{file: file, fixed: map[string]bool{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := watchConfigFile(ctx); err != nil {
+		t.Fatalf("watchConfigFile() error = %v", err)
+	}
+
+	// Replace the file the way editors do, by renaming a new one over it.
+	tmp := filepath.Join(dir, ".config.yml.swp")
+	if err := ioutil.WriteFile(tmp, []byte("help:\n  udp_exporter_buffer_dropped: new\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * configWatchDebounce); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		helpOverridesMu.Lock()
+		help := helpOverrides["udp_exporter_buffer_dropped"]
+		helpOverridesMu.Unlock()
+		if help == "new" {
+			return
+		}
+	}
+	t.Error("the changed config file wasn't reloaded")
+}
+
+func TestServeReloadNeedsLifecycle(t *testing.T) {
+	defer func(r *configReloader, enabled bool) { reloader, enableLifecycle = r, enabled }(reloader, enableLifecycle)
+	defer setHelpOverrides(nil)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(file, []byte("help:\n  udp_procfs_buffer_dropped: reloaded\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	reloader = &configReloader{file: file, fixed: map[string]bool{}}
+
+	for _, tt := range []struct {
+		enabled bool
+		want    int
+	}{
+		{false, http.StatusForbidden},
+		{true, http.StatusOK},
+	} {
+		enableLifecycle = tt.enabled
+		w := httptest.NewRecorder()
+		serveReload(context.Background())(w, httptest.NewRequest("POST", "/-/reload", nil))
+		if w.Code != tt.want {
+			t.Errorf("POST /-/reload with --web.enable-lifecycle=%t = %d, want %d", tt.enabled, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// remoteWriteSeries is one series of a remote_write request.
+type remoteWriteSeries struct {
+	labels [][2]string
+	value  float64
+}
+
+// pushRemoteWrite sends everything registered to the Prometheus remote_write
+// endpoint at writeURL (e.g. http://mimir/api/v1/push) every interval.
+// headers are "Name: value" pairs added to every request, such as Mimir's
+// X-Scope-OrgID. With --push.wal-dir, requests that can't be sent are sent
+// again later. It returns once ctx is done.
+func pushRemoteWrite(ctx context.Context, writeURL string, headers []string, interval time.Duration) {
+	header, err := parseHeaders(headers)
+	if err != nil {
+		slog.Error("Invalid remote_write header", "err", err)
+		return
+	}
+	wal, err := newPushWAL("remote-write")
+	if err != nil {
+		slog.Error("Unable to open the remote_write WAL", "err", err)
+		return
+	}
+
+	client := &http.Client{Timeout: interval}
+	for sleepContext(ctx, interval) {
+		if err := remoteWriteOnce(ctx, client, writeURL, header, wal); err != nil {
+			slog.Warn("Unable to send to remote_write", "err", err)
+		}
+	}
+}
+
+// parseHeaders turns "Name: value" flags into a header.
+func parseHeaders(headers []string) (http.Header, error) {
+	header := make(http.Header)
+	for _, h := range headers {
+		i := strings.IndexByte(h, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("%q isn't Name: value", h)
+		}
+		header.Add(strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:]))
+	}
+	return header, nil
+}
+
+func remoteWriteOnce(ctx context.Context, client *http.Client, writeURL string, header http.Header, wal *pushWAL) error {
+	families, err := snapshotGatherer().Gather()
+	if err != nil {
+		return err
+	}
+	body := snappy.Encode(nil, encodeWriteRequest(remoteWriteSamples(families), time.Now()))
+	return wal.send(body, func(body []byte) error {
+		return remoteWriteSend(ctx, client, writeURL, header, body)
+	})
+}
+
+func remoteWriteSend(ctx context.Context, client *http.Client, writeURL string, header http.Header, body []byte) error {
+	req, err := http.NewRequest("POST", writeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return responseError(resp)
+}
+
+// remoteWriteSamples flattens families into the series Prometheus would
+// have scraped from them, summaries and histograms included.
+func remoteWriteSamples(families []*dto.MetricFamily) []remoteWriteSeries {
+	var series []remoteWriteSeries
+	for _, f := range families {
+		name := f.GetName()
+		for _, m := range f.GetMetric() {
+			add := func(suffix, extraName, extraValue string, v float64) {
+				labels := [][2]string{{"__name__", name + suffix}}
+				for _, l := range m.GetLabel() {
+					labels = append(labels, [2]string{l.GetName(), l.GetValue()})
+				}
+				if extraName != "" {
+					labels = append(labels, [2]string{extraName, extraValue})
+				}
+				// Remote write wants the labels sorted by name.
+				sort.Slice(labels, func(i, j int) bool { return labels[i][0] < labels[j][0] })
+				series = append(series, remoteWriteSeries{labels: labels, value: v})
+			}
+			switch f.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", "", "", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", "", "", m.GetGauge().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add("", "quantile", strconv.FormatFloat(q.GetQuantile(), 'g', -1, 64), q.GetValue())
+				}
+				add("_sum", "", "", s.GetSampleSum())
+				add("_count", "", "", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, b := range h.GetBucket() {
+					if !math.IsInf(b.GetUpperBound(), +1) {
+						add("_bucket", "le", strconv.FormatFloat(b.GetUpperBound(), 'g', -1, 64), float64(b.GetCumulativeCount()))
+					}
+				}
+				add("_bucket", "le", "+Inf", float64(h.GetSampleCount()))
+				add("_sum", "", "", h.GetSampleSum())
+				add("_count", "", "", float64(h.GetSampleCount()))
+			default:
+				add("", "", "", m.GetUntyped().GetValue())
+			}
+		}
+	}
+	return series
+}
+
+// encodeWriteRequest encodes series as a prometheus.WriteRequest protobuf,
+// all sampled at now:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []remoteWriteSeries, now time.Time) []byte {
+	timestamp := now.UnixNano() / int64(time.Millisecond)
+	var req []byte
+	for _, s := range series {
+		var ts []byte
+		for _, l := range s.labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l[0])
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l[1])
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(timestamp))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return req
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodeWriteRequest decodes a prometheus.WriteRequest into one line per
+// sample, its labels then its value and timestamp.
+func decodeWriteRequest(t *testing.T, b []byte) []string {
+	t.Helper()
+	var samples []string
+	fields(t, b, func(num protowire.Number, v []byte) {
+		if num != 1 {
+			t.Errorf("WriteRequest field %d", num)
+		}
+		var labels []string
+		var sample string
+		fields(t, v, func(num protowire.Number, v []byte) {
+			switch num {
+			case 1:
+				var name, value string
+				fields(t, v, func(num protowire.Number, v []byte) {
+					if num == 1 {
+						name = string(v)
+					} else {
+						value = string(v)
+					}
+				})
+				labels = append(labels, name+"="+value)
+			case 2:
+				if len(v) < 11 || v[0] != 1<<3|1 || v[9] != 2<<3 {
+					t.Fatalf("malformed sample %x", v)
+				}
+				timestamp, n := protowire.ConsumeVarint(v[10:])
+				if n < 0 {
+					t.Fatalf("malformed sample timestamp %x", v)
+				}
+				sample = fmt.Sprintf("%g@%d", math.Float64frombits(binary.LittleEndian.Uint64(v[1:])), timestamp)
+			}
+		})
+		samples = append(samples, strings.Join(labels, ",")+" "+sample)
+	})
+	return samples
+}
+
+// fields calls fn with the number and contents of each length-delimited field
+// in the protobuf message b, the only kind remote write's messages hold
+// outside samples.
+func fields(t *testing.T, b []byte, fn func(protowire.Number, []byte)) {
+	t.Helper()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 || typ != protowire.BytesType {
+			t.Fatalf("malformed field %x", b)
+		}
+		b = b[n:]
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			t.Fatalf("malformed field %x", b)
+		}
+		fn(num, v)
+		b = b[n:]
+	}
+}
+
+func TestRemoteWrite(t *testing.T) {
+	body := snappy.Encode(nil, encodeWriteRequest(remoteWriteSamples(pushFamilies()), pushTime))
+	req, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Fatalf("snappy: %v", err)
+	}
+
+	got := decodeWriteRequest(t, req)
+	want := []string{
+		"__name__=udp_procfs_buffer_queued,protocol=udp 7488@1700000000000",
+		"__name__=udp_procfs_buffer_queued,protocol=udp6 0@1700000000000",
+		"__name__=udp_procfs_buffer_dropped,protocol=udp 91@1700000000000",
+		"__name__=udp_procfs_scrape_duration_seconds_bucket,le=0.1 1@1700000000000",
+		"__name__=udp_procfs_scrape_duration_seconds_bucket,le=1 3@1700000000000",
+		"__name__=udp_procfs_scrape_duration_seconds_bucket,le=+Inf 4@1700000000000",
+		"__name__=udp_procfs_scrape_duration_seconds_sum 2.5@1700000000000",
+		"__name__=udp_procfs_scrape_duration_seconds_count 4@1700000000000",
+		"__name__=go_goroutines 8@1700000000000",
+		"__name__=udp_procfs_buffer_utilization_ratio NaN@1700000000000",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("remote write samples:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// runResolve implements the resolve subcommand: it runs target discovery for
+// sel the same way the exporter would at startup and prints what would be
+// watched, and why near misses wouldn't be, without collecting anything.
+func runResolve(ctx context.Context, w io.Writer, sel localSelection) int {
+	fmt.Fprintf(w, "Resolving %s in %s\n", describeSelection(sel), procfsPath)
+
+	var excluded []string
+	if sel.container == "" && sel.pid == "" && sel.cgroup == "" {
+		excluded = nearMisses(ctx, sel)
+	}
+
+	status := 0
+	targets, _, _, err := selectLocalTargets(ctx, sel)
+	if err != nil {
+		fmt.Fprintf(w, "No target found, the exporter would exit: %v\n", err)
+		status = 1
+	}
+	for _, t := range targets {
+		fmt.Fprintf(w, "Would watch %s as PID %s\n", t.name, strings.Join(t.currentPIDs(), ","))
+		printTargetSockets(w, t)
+	}
+
+	if len(excluded) > 0 {
+		fmt.Fprintln(w, "Excluded:")
+		for _, e := range excluded {
+			fmt.Fprintln(w, "  "+e)
+		}
+	}
+	return status
+}
+
+// describeSelection names what sel looks for.
+func describeSelection(sel localSelection) string {
+	switch {
+	case sel.container != "":
+		return fmt.Sprintf("container %q", sel.container)
+	case sel.pid != "":
+		return "PID " + sel.pid
+	case sel.cgroup != "":
+		return "cgroup " + sel.cgroup
+	case sel.match != nil:
+		return "--process.*"
+	}
+	return strconv.Quote(sel.procName)
+}
+
+// nearMisses explains why processes that look like they might be the target
+// aren't watched: matches --match-mode=last passes over, and processes whose
+// binary has the requested name but whose Name: doesn't.
+func nearMisses(ctx context.Context, sel localSelection) []string {
+	var misses []string
+	match := sel.match
+	if match == nil {
+		match = func(_ *procWalk, _, name string) bool { return name == sel.procName }
+	}
+	// findPIDs walks in the same order as the exporter's discovery, so the
+	// last match here is the one it would pick.
+	found, err := findPIDs(ctx, match)
+	if err != nil {
+		return append(misses, err.Error())
+	}
+	if matchMode == "last" {
+		for i := 0; i < len(found)-1; i++ {
+			misses = append(misses, fmt.Sprintf("PID %s (%s): also matches, but only the last match is watched with --match-mode=last", found[i].pid, found[i].name))
+		}
+	}
+	if sel.match != nil {
+		return misses
+	}
+
+	entries, err := ioutil.ReadDir(procfsPath)
+	if err != nil {
+		return append(misses, fmt.Sprintf("unable to list procfs: %v", err))
+	}
+	for _, e := range entries {
+		pid := e.Name()
+		if _, err := strconv.Atoi(pid); err != nil {
+			continue
+		}
+		status, err := ioutil.ReadFile(filepath.Join(procfsPath, pid, "status"))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				misses = append(misses, fmt.Sprintf("PID %s: unable to read status: %v", pid, err))
+			}
+			continue
+		}
+		name := statusName(status)
+		if name == "" || name == sel.procName {
+			continue
+		}
+
+		// Name: is the kernel's comm, truncated to 15 characters and
+		// renameable by the process, so point out processes whose binary
+		// has the requested name but whose comm doesn't match.
+		cmdline, _ := ioutil.ReadFile(filepath.Join(procfsPath, pid, "cmdline"))
+		if i := bytes.IndexByte(cmdline, 0); i > 0 && filepath.Base(string(cmdline[:i])) == sel.procName {
+			misses = append(misses, fmt.Sprintf("PID %s (%s): binary is %s but its Name: is %q, which is what must be matched", pid, name, sel.procName, name))
+		}
+	}
+	return misses
+}
+
+// printTargetSockets lists the sockets of t the exporter would sum, from the
+// tables of each network namespace its processes are in.
+func printTargetSockets(w io.Writer, t *target) {
+	owned, err := t.ownedInodes()
+	if err != nil {
+		fmt.Fprintf(w, "  Unable to read its sockets, listing every one in its network namespace: %v\n", err)
+		owned = nil
+	}
+	seen := make(map[string]bool)
+	for _, pid := range t.livePIDs() {
+		netns, err := os.Readlink(filepath.Join(procfsPath, pid, "ns", "net"))
+		if err != nil {
+			netns = "unknown: " + err.Error()
+		}
+		if seen[netns] {
+			continue
+		}
+		seen[netns] = true
+		fmt.Fprintf(w, "  Network namespace %s, from PID %s\n", netns, pid)
+		for _, protocol := range protocols {
+			printSockets(w, protocol, filepath.Join(procfsPath, pid, "net", protocol), owned)
+		}
+	}
+}
+
+// printSockets lists the sockets in a procfs UDP table with the values the
+// exporter would sum for them, only those in owned unless it's nil.
+func printSockets(w io.Writer, protocol, filename string, owned map[string]bool) {
+	f, err := os.Open(filename)
+	if err != nil {
+		fmt.Fprintf(w, "    %s: %v\n", protocol, err)
+		return
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	listed := 0
+	for n := 0; s.Scan(); n++ {
+		// Skip the header lines.
+		if n < 1 {
+			continue
+		}
+		fields := strings.Fields(s.Text())
+		if len(fields) < 13 || owned != nil && !owned[fields[9]] {
+			continue
+		}
+		ip, port, err := parseProcfsAddr(fields[1])
+		if err != nil {
+			fmt.Fprintf(w, "    %s: %v\n", protocol, err)
+			continue
+		}
+		queued, _ := strconv.ParseInt(strings.Split(fields[4], ":")[1], 16, 32)
+		fmt.Fprintf(w, "    %-5s %-40s inode %-10s queued %-8d drops %s\n",
+			protocol, net.JoinHostPort(ip.String(), strconv.Itoa(port)), fields[9], queued, fields[12])
+		listed++
+	}
+	if listed == 0 {
+		fmt.Fprintf(w, "    %-5s no sockets\n", protocol)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// sampleInterval is set by --sample-interval, zero disables sampling
+	// the queues between polls.
+	sampleInterval time.Duration
+
+	// sampleHistogram is set by --sample.histogram to bytes or ratio to
+	// also count the queues every poll reads in a histogram of that unit,
+	// and sampleBuckets by --sample.histogram.buckets to replace its preset
+	// buckets.
+	sampleHistogram string
+	sampleBuckets   string
+
+	udpBufferQueuedSampled    *windowCollector
+	udpBufferSampledHistogram *prometheus.HistogramVec
+)
+
+// sampleBucketPresets are the buckets of each --sample.histogram unit: bytes
+// doubling from 4KiB up to 64MiB, as receive buffers range from the 208KiB
+// default to tens of MiB, and the ratio of the buffer that's queued.
+var sampleBucketPresets = map[string][]float64{
+	"bytes": prometheus.ExponentialBuckets(4096, 2, 15),
+	"ratio": {0.01, 0.05, 0.1, 0.25, 0.5, 0.75, 0.9, 0.95, 0.99, 1},
+}
+
+// parseBuckets parses the comma separated upper bounds of
+// --sample.histogram.buckets, which must go up.
+func parseBuckets(s string) ([]float64, error) {
+	var buckets []float64
+	for _, field := range strings.Split(s, ",") {
+		b, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket %q", field)
+		}
+		buckets = append(buckets, b)
+	}
+	if !sort.Float64sAreSorted(buckets) {
+		return nil, fmt.Errorf("buckets must be in increasing order")
+	}
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] == buckets[i-1] {
+			return nil, fmt.Errorf("bucket %v is given twice", buckets[i])
+		}
+	}
+	return buckets, nil
+}
+
+// registerSampleHistogram registers the --sample.histogram histogram, if
+// it's enabled, with labels.
+func registerSampleHistogram(labels []string) {
+	if sampleHistogram == "" {
+		return
+	}
+	buckets := sampleBucketPresets[sampleHistogram]
+	if sampleBuckets != "" {
+		// main has already checked them.
+		buckets, _ = parseBuckets(sampleBuckets)
+	}
+	opts := prometheus.HistogramOpts{
+		Name:    metricName(legacyExporterPrefix, "buffer_queued_sampled_bytes"),
+		Help:    "The bytes queued in the linux buffer at each poll.",
+		Buckets: buckets,
+	}
+	if sampleHistogram == "ratio" {
+		opts.Name = metricName(legacyExporterPrefix, "buffer_utilization_sampled_ratio")
+		opts.Help = "The bytes queued in the linux buffers over the sum of their sizes at each poll."
+	}
+	udpBufferSampledHistogram = prometheus.NewHistogramVec(opts, labels)
+	prometheus.MustRegister(udpBufferSampledHistogram)
+}
+
+// windowCollector exports the minimum, maximum and average of the values
+// observed for each label set since the previous scrape, then starts over.
+// Like peakCollector, every scrape resets it. Label sets with nothing
+// observed since the last scrape are left out rather than exported as zero.
+type windowCollector struct {
+	min, max, avg *prometheus.Desc
+
+	mu      sync.Mutex
+	windows map[string]*window
+}
+
+type window struct {
+	labels        []string
+	min, max, sum float64
+	count         int
+}
+
+func newWindowCollector(name, help string, labels []string) *windowCollector {
+	return &windowCollector{
+		min:     prometheus.NewDesc(name+"_min", "The least "+help, labels, nil),
+		max:     prometheus.NewDesc(name+"_max", "The most "+help, labels, nil),
+		avg:     prometheus.NewDesc(name+"_avg", "The average "+help, labels, nil),
+		windows: make(map[string]*window),
+	}
+}
+
+func (c *windowCollector) observe(labels []string, v float64) {
+	key := strings.Join(labels, "\xff")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w, ok := c.windows[key]
+	if !ok {
+		w = &window{labels: labels}
+		c.windows[key] = w
+	}
+	if w.count == 0 || v < w.min {
+		w.min = v
+	}
+	if w.count == 0 || v > w.max {
+		w.max = v
+	}
+	w.sum += v
+	w.count++
+}
+
+// forget stops exporting labels.
+func (c *windowCollector) forget(labels []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.windows, strings.Join(labels, "\xff"))
+}
+
+func (c *windowCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.min
+	ch <- c.max
+	ch <- c.avg
+}
+
+func (c *windowCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, w := range c.windows {
+		if w.count == 0 {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.min, prometheus.GaugeValue, w.min, w.labels...)
+		ch <- prometheus.MustNewConstMetric(c.max, prometheus.GaugeValue, w.max, w.labels...)
+		ch <- prometheus.MustNewConstMetric(c.avg, prometheus.GaugeValue, w.sum/float64(w.count), w.labels...)
+		if !peekOnly {
+			*w = window{labels: w.labels}
+		}
+	}
+}
+
+func registerSampleMetrics(labels []string) {
+	udpBufferQueuedSampled = newWindowCollector(
+		metricName(legacyExporterPrefix, "buffer_queued_sampled"),
+		"queued UDP messages seen by a --sample-interval sample since the last scrape.",
+		labels,
+	)
+	prometheus.MustRegister(udpBufferQueuedSampled)
+}
+
+// sampleQueues reads t's queued totals every sampleInterval until ctx is
+// done, for bursts that fill and drain the buffers well within a poll. Only
+// the queues are read; the watch loop still does everything else, and reports
+// the failures this skips. Remote targets aren't sampled, as each read of
+// theirs is a round trip.
+func sampleQueues(ctx context.Context, t *target) {
+	if t.nsPath == "" && len(t.currentPIDs()) == 0 {
+		return
+	}
+	for sleepContext(ctx, sampleInterval) {
+		seen := make(map[string]bool)
+		owned, _ := t.ownedInodes()
+		for _, protocol := range protocols {
+			queued, _, _, err := readQueues(ctx, t, protocol, seen, owned)
+			if err != nil {
+				continue
+			}
+			udpBufferQueuedSampled.observe(append(append([]string{}, t.labels...), protocol), float64(queued))
+		}
+	}
+}
+
+// readQueues is parseProcfsNetFile without the recording and debug sampling
+// that go with a poll.
+func readQueues(ctx context.Context, t *target, protocol string, seen, owned map[string]bool) (int, int, int, error) {
+	if backend == "netlink" {
+		if queued, txQueued, dropped, _, ok, err := parseDiagSockets(t, protocol, seen, owned); ok {
+			return queued, txQueued, dropped, err
+		}
+	}
+
+	f, err := t.open(ctx, protocol)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer f.Close()
+	return parseProcfsNet(f, seen, owned)
+}
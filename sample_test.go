@@ -0,0 +1,74 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseBuckets(t *testing.T) {
+	got, err := parseBuckets("65536, 262144,1e6")
+	if err != nil {
+		t.Fatalf("parseBuckets() error = %v", err)
+	}
+	if want := []float64{65536, 262144, 1e6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parseBuckets() = %v, want %v", got, want)
+	}
+
+	for _, s := range []string{"", "1,a", "0.5,0.1", "1,1"} {
+		if _, err := parseBuckets(s); err == nil {
+			t.Errorf("parseBuckets(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestRollingWindowZScore(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		samples []float64
+		v, want float64
+		wantOK  bool
+	}{
+		{name: "not full yet", size: 3, samples: []float64{1, 2}, v: 10},
+		{name: "above the mean", size: 8, samples: []float64{2, 4, 4, 4, 5, 5, 7, 9}, v: 9, want: 2, wantOK: true},
+		{name: "below the mean", size: 8, samples: []float64{2, 4, 4, 4, 5, 5, 7, 9}, v: 1, want: -2, wantOK: true},
+		{name: "flat queue floors the deviation", size: 4, samples: []float64{10, 10, 10, 10}, v: 12, want: 2, wantOK: true},
+		{name: "oldest samples drop out", size: 2, samples: []float64{100, 4, 8}, v: 10, want: 2, wantOK: true},
+	}
+	for _, tt := range tests {
+		w := newRollingWindow(tt.size)
+		for _, s := range tt.samples {
+			w.add(s)
+		}
+		got, ok := w.zscore(tt.v)
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("%s: zscore(%v) = %v, %t, want %v, %t", tt.name, tt.v, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestEWMAAdd(t *testing.T) {
+	defer func(d time.Duration) { ewmaHalfLife = d }(ewmaHalfLife)
+	ewmaHalfLife = 10 * time.Second
+
+	start := time.Unix(1700000000, 0)
+	tests := []struct {
+		name  string
+		after time.Duration
+		v     float64
+		want  float64
+	}{
+		{name: "first sample", v: 100, want: 100},
+		{name: "one half-life later", after: 10 * time.Second, v: 0, want: 50},
+		{name: "two half-lives later", after: 30 * time.Second, v: 90, want: 50 + 0.75*40},
+		{name: "no time passed", after: 30 * time.Second, v: 1000, want: 80},
+	}
+	var e ewma
+	for _, tt := range tests {
+		if got := e.add(tt.v, start.Add(tt.after)); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: add(%v) = %v, want %v", tt.name, tt.v, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	// pollOnScrape is set by --collection.on-scrape, to poll the targets
+	// when metrics are gathered rather than every --poll-interval.
+	pollOnScrape bool
+
+	// scrapeMu keeps concurrent scrapes from polling a target at once, as
+	// a target's counters and peaks aren't safe for that.
+	scrapeMu sync.Mutex
+
+	// gatherMu serialises gathering, so that peekOnly holds for exactly
+	// the gathers of snapshotGatherer.
+	gatherMu sync.Mutex
+	// peekOnly is set while snapshotGatherer gathers, for the collectors
+	// that start over on every scrape to leave their state alone.
+	peekOnly bool
+)
+
+// gatherer returns what /metrics gathers from: the default registry, with the
+// config file's relabel rules and help text applied. It resets the peaks and
+// sample windows. With --collection.on-scrape a scrapeCollector polls every
+// watched target with ctx, the scrape's, first; Gatherers gathers in order, so
+// the poll has finished before the metrics it sets in the default registry
+// are collected.
+func gatherer(ctx context.Context) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		gatherMu.Lock()
+		defer gatherMu.Unlock()
+		return rewriteFamilies(targetGatherers(scrapeCollector{ctx: ctx, poll: true}).Gather())
+	})
+}
+
+// snapshotGatherer returns what the push sinks and the API gather from: the
+// default registry as it stands, rewritten like gatherer's. Unlike gatherer it
+// neither polls nor resets the peaks and sample windows, which are Prometheus
+// scrapes' to reset.
+func snapshotGatherer() prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		gatherMu.Lock()
+		defer gatherMu.Unlock()
+		peekOnly = true
+		defer func() { peekOnly = false }()
+		return rewriteFamilies(targetGatherers(scrapeCollector{}).Gather())
+	})
+}
+
+// targetGatherers returns the default registry, after a registry of c with
+// --collection.on-scrape.
+func targetGatherers(c scrapeCollector) prometheus.Gatherers {
+	if !pollOnScrape {
+		return prometheus.Gatherers{prometheus.DefaultGatherer}
+	}
+	r := prometheus.NewPedanticRegistry()
+	r.MustRegister(c)
+	return prometheus.Gatherers{r, prometheus.DefaultGatherer}
+}
+
+// scrapeCollector exports the queued, tx queued and dropped totals of every
+// watched target as const metrics with --collection.on-scrape, in place of
+// the registered vectors the background polls set. With poll, it polls the
+// targets with ctx first; otherwise it exports what their last polls found.
+type scrapeCollector struct {
+	ctx  context.Context
+	poll bool
+}
+
+// exportedTotals are what a target's polls exported for one protocol.
+type exportedTotals struct {
+	queued, txQueued float64
+	dropped          float64
+	created          time.Time
+}
+
+// exportedFor returns t's totals for protocol, starting them on first use.
+func (t *target) exportedFor(protocol string) *exportedTotals {
+	if t.exported == nil {
+		t.exported = make(map[string]*exportedTotals)
+	}
+	e, ok := t.exported[protocol]
+	if !ok {
+		e = &exportedTotals{created: time.Now()}
+		t.exported[protocol] = e
+	}
+	return e
+}
+
+// add records a poll's queued total and the drops since the previous one.
+func (e *exportedTotals) add(queued, diff int) {
+	e.queued = float64(queued)
+	e.dropped += float64(diff)
+}
+
+func (c scrapeCollector) Describe(ch chan<- *prometheus.Desc) {
+	udpBufferQueued.Describe(ch)
+	udpBufferTxQueued.Describe(ch)
+	udpBufferDropped.Describe(ch)
+}
+
+func (c scrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	queued, txQueued, dropped := describe(udpBufferQueued), describe(udpBufferTxQueued), describe(udpBufferDropped)
+
+	scrapeMu.Lock()
+	defer scrapeMu.Unlock()
+	for _, t := range currentTargets() {
+		if c.poll {
+			t.poll(c.ctx)
+		}
+		for _, protocol := range append(append([]string{}, protocols...), injectedProtocol) {
+			e, ok := t.exported[protocol]
+			if !ok {
+				continue
+			}
+			labels := append(append([]string{}, t.labels...), protocol)
+			ch <- prometheus.MustNewConstMetric(queued, prometheus.GaugeValue, e.queued, labels...)
+			ch <- prometheus.MustNewConstMetric(txQueued, prometheus.GaugeValue, e.txQueued, labels...)
+			ch <- prometheus.MustNewConstMetricWithCreatedTimestamp(dropped, prometheus.CounterValue, e.dropped, e.created, labels...)
+		}
+	}
+}
+
+// describe returns the Desc of a collector of one metric, like a vector.
+func describe(c prometheus.Collector) *prometheus.Desc {
+	ch := make(chan *prometheus.Desc, 1)
+	c.Describe(ch)
+	return <-ch
+}
+
+// rewriteFamilies applies the config file's relabel rules and then its help
+// text to the families gathered with err.
+func rewriteFamilies(families []*dto.MetricFamily, err error) ([]*dto.MetricFamily, error) {
+	families, relabelErr := relabelFamilies(families)
+	if relabelErr != nil {
+		return nil, relabelErr
+	}
+	overrideHelp(families)
+	return families, err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestScrapeCollector(t *testing.T) {
+	defer func(onScrape bool) { pollOnScrape = onScrape }(pollOnScrape)
+	pollOnScrape = true
+	registerMetrics(nil)
+
+	drops := 5
+	var polled context.Context
+	tgt := &target{name: "statsd", open: func(ctx context.Context, protocol string) (io.ReadCloser, error) {
+		polled = ctx
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if protocol != "udp" {
+			return nil, os.ErrNotExist
+		}
+		return ioutil.NopCloser(strings.NewReader(procfsHeader +
+			" 2873: 0100007F:11C1 00000000:0000 07 00000010:00000100 00:00000000 00000000     0        0 504547 2 0000000013db98ee " + strconv.Itoa(drops) + "\n")), nil
+	}}
+	defer func(targets []*target) { watchedTargets = targets }(watchedTargets)
+	watchedTargets = []*target{tgt}
+
+	scrape := func(ctx context.Context) map[string]float64 {
+		t.Helper()
+		families, err := gatherer(ctx).Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		values := make(map[string]float64)
+		for _, f := range families {
+			for _, m := range f.GetMetric() {
+				if protocol(m) != "udp" {
+					continue
+				}
+				switch {
+				case m.GetGauge() != nil:
+					values[f.GetName()] = m.GetGauge().GetValue()
+				case m.GetCounter() != nil:
+					values[f.GetName()] = m.GetCounter().GetValue()
+				}
+			}
+		}
+		return values
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := scrape(ctx)
+	if polled != ctx {
+		t.Error("the scrape didn't poll with its own context")
+	}
+	if got["udp_procfs_buffer_queued"] != 0x100 || got["udp_procfs_buffer_tx_queued"] != 0x10 || got["udp_procfs_buffer_dropped"] != 5 {
+		t.Errorf("first scrape = %v, want 256 queued, 16 tx queued and 5 dropped", got)
+	}
+
+	drops = 12
+	if got := scrape(ctx); got["udp_procfs_buffer_dropped"] != 12 {
+		t.Errorf("second scrape dropped = %v, want 12", got["udp_procfs_buffer_dropped"])
+	}
+
+	// A scrape that's given up leaves the last poll's values.
+	drops = 20
+	cancel()
+	if got := scrape(ctx); got["udp_procfs_buffer_dropped"] != 12 || got["udp_procfs_buffer_queued"] != 0x100 {
+		t.Errorf("cancelled scrape = %v, want the second scrape's values", got)
+	}
+}
+
+func protocol(m *dto.Metric) string {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == "protocol" {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
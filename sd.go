@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// watchedTargets is every target being collected from. It's filled in before
+// the HTTP server starts, and afterwards only changed by reloads, under
+// targetsMu.
+var (
+	watchedTargets []*target
+	targetsMu      sync.Mutex
+)
+
+// currentTargets returns watchedTargets as of now, for iterating over while
+// a reload may change it.
+func currentTargets() []*target {
+	targetsMu.Lock()
+	defer targetsMu.Unlock()
+	return append([]*target{}, watchedTargets...)
+}
+
+// sdTargetGroup is one entry of Prometheus' http_sd format.
+type sdTargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// serveSD lists every watched target in Prometheus http_sd format. Each group
+// points back at this exporter (as addressed by the request) and describes
+// the target with __meta_udp_procfs_* labels, which relabeling can turn into
+// per-target jobs or annotations.
+func serveSD(w http.ResponseWriter, r *http.Request) {
+	targets := currentTargets()
+	groups := make([]sdTargetGroup, 0, len(targets))
+	for _, t := range targets {
+		labels := map[string]string{
+			"__meta_udp_procfs_state": t.currentState(),
+		}
+		t.stateMu.Lock()
+		for k, v := range t.sdLabels {
+			labels["__meta_udp_procfs_"+k] = v
+		}
+		t.stateMu.Unlock()
+		if ports := t.listeningPorts(r.Context()); len(ports) > 0 {
+			labels["__meta_udp_procfs_ports"] = strings.Join(ports, ",")
+		}
+		groups = append(groups, sdTargetGroup{Targets: []string{r.Host}, Labels: labels})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+// listeningPorts returns the sorted, deduplicated local ports of every socket
+// the target owned in its UDP tables at the last poll.
+func (t *target) listeningPorts(ctx context.Context) []string {
+	seen := make(map[int]bool)
+	for _, protocol := range protocols {
+		f, owned, err := t.polledTable(ctx, protocol)
+		if err != nil {
+			continue
+		}
+		s := bufio.NewScanner(f)
+		for n := 0; s.Scan(); n++ {
+			// Skip the header lines.
+			if n < 1 {
+				continue
+			}
+			fields := strings.Fields(s.Text())
+			if owned != nil && (len(fields) < 10 || !owned[fields[9]]) {
+				continue
+			}
+			if len(fields) > 1 {
+				if _, port, err := parseProcfsAddr(fields[1]); err == nil {
+					seen[port] = true
+				}
+			}
+		}
+		f.Close()
+	}
+
+	ports := make([]int, 0, len(seen))
+	for port := range seen {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	out := make([]string, len(ports))
+	for i, port := range ports {
+		out[i] = strconv.Itoa(port)
+	}
+	return out
+}
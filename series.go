@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The label GC policy shared by the collectors whose label values come and go
+// with what they watch (sockets, peers, devices, qdiscs).
+var (
+	seriesRetention        time.Duration
+	seriesZeroBeforeDelete bool
+	seriesMaxPerMetric     = 1000
+
+	// seriesRetentions are the --series.retention.override overrides of
+	// seriesRetention by retention mode.
+	seriesRetentions map[string]time.Duration
+)
+
+// retentionModes are the modes --series.retention.override can keep series
+// for longer or shorter in: those of each collector with a label GC, and
+// those of the PIDs --match-mode=per-pid watches.
+var retentionModes = []string{"sockets", "peers", "skmem", "qdisc", "ethtool", "ebpf", "unix", "per-pid"}
+
+// retention returns how long the series of mode are kept once they're gone.
+func retention(mode string) time.Duration {
+	if d, ok := seriesRetentions[mode]; ok {
+		return d
+	}
+	return seriesRetention
+}
+
+// parseRetentions parses --series.retention.override's mode=duration pairs.
+func parseRetentions(pairs []string) (map[string]time.Duration, error) {
+	retentions := make(map[string]time.Duration, len(pairs))
+	for _, pair := range pairs {
+		i := strings.IndexByte(pair, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("%q isn't mode=duration", pair)
+		}
+		mode := pair[:i]
+		known := false
+		for _, m := range retentionModes {
+			known = known || m == mode
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown mode %q, must be one of %s", mode, strings.Join(retentionModes, ", "))
+		}
+		d, err := time.ParseDuration(pair[i+1:])
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("%q doesn't have a duration of 0 or more", pair)
+		}
+		retentions[mode] = d
+	}
+	return retentions, nil
+}
+
+// seriesGC tracks the label sets written to one metric vector during each
+// poll and, on sweep, removes the ones that have gone away according to the
+// label GC policy. Its methods are only called from the goroutine polling
+// the metric.
+type seriesGC struct {
+	mode string
+	name string
+	vec  interface {
+		DeleteLabelValues(...string) bool
+	}
+	// zero is nil for counters, which can't be zeroed without looking like
+	// a reset.
+	zero     func(labels ...string)
+	onDelete func(key string)
+
+	poll     int
+	rejected int
+	series   map[string]*trackedSeries
+}
+
+type trackedSeries struct {
+	labels []string
+	poll   int
+	seen   time.Time
+	zeroed bool
+}
+
+func newGaugeGC(mode, name string, vec *prometheus.GaugeVec) *seriesGC {
+	return &seriesGC{
+		mode:   mode,
+		name:   name,
+		vec:    vec,
+		zero:   func(labels ...string) { vec.WithLabelValues(labels...).Set(0) },
+		series: make(map[string]*trackedSeries),
+	}
+}
+
+func newCounterGC(mode, name string, vec *prometheus.CounterVec) *seriesGC {
+	return &seriesGC{mode: mode, name: name, vec: vec, series: make(map[string]*trackedSeries)}
+}
+
+// admit marks labels as present in the current poll. It returns false when
+// labels is new and the metric already has --series.max-per-metric series,
+// in which case the caller must not write it.
+func (g *seriesGC) admit(labels ...string) bool {
+	key := strings.Join(labels, "\xff")
+	s, ok := g.series[key]
+	if !ok {
+		if len(g.series) >= seriesMaxPerMetric {
+			g.rejected++
+			return false
+		}
+		s = &trackedSeries{labels: append([]string{}, labels...)}
+		g.series[key] = s
+	}
+	s.poll = g.poll
+	s.seen = time.Now()
+	s.zeroed = false
+	return true
+}
+
+// sweep ends the current poll. Series that weren't admitted during it are
+// zeroed first if --series.zero-before-delete is set, and deleted once they
+// have been missing for the retention of g's mode.
+func (g *seriesGC) sweep() {
+	if g.rejected > 0 {
+		slog.Warn("Metric is at --series.max-per-metric, dropped new series", "metric", g.name, "dropped", g.rejected)
+		g.rejected = 0
+	}
+	for key, s := range g.series {
+		if s.poll == g.poll {
+			continue
+		}
+		if seriesZeroBeforeDelete && g.zero != nil && !s.zeroed {
+			g.zero(s.labels...)
+			s.zeroed = true
+			continue
+		}
+		if time.Since(s.seen) < retention(g.mode) {
+			continue
+		}
+		g.vec.DeleteLabelValues(s.labels...)
+		delete(g.series, key)
+		if g.onDelete != nil {
+			g.onDelete(key)
+		}
+	}
+	g.poll++
+}
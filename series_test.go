@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseRetentions(t *testing.T) {
+	got, err := parseRetentions([]string{"peers=0s", "qdisc=1h"})
+	if err != nil {
+		t.Fatalf("parseRetentions() error = %v", err)
+	}
+	if got["peers"] != 0 || got["qdisc"] != time.Hour || len(got) != 2 {
+		t.Errorf("parseRetentions() = %v", got)
+	}
+
+	for _, pair := range []string{"peers", "ports=1m", "qdisc=soon", "qdisc=-1s"} {
+		if _, err := parseRetentions([]string{pair}); err == nil {
+			t.Errorf("parseRetentions(%q) succeeded, want an error", pair)
+		}
+	}
+}
+
+func TestSeriesGCRetentionByMode(t *testing.T) {
+	defer func(d time.Duration) { seriesRetention = d }(seriesRetention)
+	defer func() { seriesRetentions = nil }()
+	seriesRetention = time.Hour
+	seriesRetentions = map[string]time.Duration{"peers": 0}
+
+	peers := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "peers"}, []string{"port"})
+	qdiscs := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "qdiscs"}, []string{"port"})
+	gcs := []*seriesGC{newGaugeGC("peers", "peers", peers), newGaugeGC("qdisc", "qdiscs", qdiscs)}
+	for i, vec := range []*prometheus.GaugeVec{peers, qdiscs} {
+		gcs[i].admit("8125")
+		vec.WithLabelValues("8125").Set(1)
+		gcs[i].sweep()
+	}
+	// Gone from the next poll, the peer's series goes at once and the
+	// qdisc's stays for --series.retention.
+	for _, gc := range gcs {
+		gc.sweep()
+	}
+	if peers.DeleteLabelValues("8125") {
+		t.Error("the peer's series wasn't deleted")
+	}
+	if !qdiscs.DeleteLabelValues("8125") {
+		t.Error("the qdisc's series was deleted within --series.retention")
+	}
+}
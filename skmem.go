@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Indexes into diagSocket.skmem, the SK_MEMINFO_* values from
+// linux/sock_diag.h.
+const (
+	skmemRmemAlloc = iota
+	skmemRcvbuf
+	skmemWmemAlloc
+	skmemSndbuf
+	skmemFwdAlloc
+	skmemWmemQueued
+	skmemOptmem
+	skmemBacklog
+	skmemDrops
+	skmemVars
+)
+
+// Linux address families as they appear in sock_diag messages.
+const (
+	afInet  = 2
+	afInet6 = 10
+)
+
+// diagSocket is one socket as reported by sock_diag.
+type diagSocket struct {
+	family                uint8
+	localAddr, remoteAddr net.IP
+	localPort, remotePort uint16
+	rxQueue, txQueue      uint32
+	uid, inode            uint32
+	hasSkmem              bool
+	skmem                 [skmemVars]uint32
+}
+
+var (
+	// skmemInodeLabel adds an inode label to the per-socket series instead
+	// of summing sockets that share an address and port.
+	skmemInodeLabel bool
+
+	skmemGauges []skmemGauge
+	// skmemDropped exports the drops counter, which unlike the others
+	// only goes up for as long as a socket is open.
+	skmemDropped *counterTracker
+)
+
+// skmemMetrics are the sock_diag memory values exported per socket as
+// gauges, by their name after the metric prefix.
+var skmemMetrics = []struct {
+	index      int
+	name, help string
+}{
+	{skmemRmemAlloc, "socket_rmem_alloc_bytes", "Memory allocated to the socket's receive queue."},
+	{skmemRcvbuf, "socket_rcvbuf_bytes", "The socket's receive buffer size (SO_RCVBUF)."},
+	{skmemFwdAlloc, "socket_fwd_alloc_bytes", "Memory reserved by the socket but not yet used."},
+	{skmemWmemAlloc, "socket_wmem_alloc_bytes", "Memory allocated to the socket's send queue."},
+	{skmemSndbuf, "socket_sndbuf_bytes", "The socket's send buffer size (SO_SNDBUF)."},
+}
+
+type skmemGauge struct {
+	index int
+	gauge *prometheus.GaugeVec
+	gc    *seriesGC
+}
+
+func registerSkmemMetrics() {
+	labels := []string{"protocol", "local_addr", "local_port"}
+	note := " Sockets sharing a local address and port (SO_REUSEPORT) are summed."
+	if skmemInodeLabel {
+		labels = append(labels, "inode")
+		note = ""
+	}
+	for _, m := range skmemMetrics {
+		name := metricName(legacyExporterPrefix, m.name)
+		g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: m.help + note}, labels)
+		prometheus.MustRegister(g)
+		skmemGauges = append(skmemGauges, skmemGauge{m.index, g, newGaugeGC("skmem", name, g)})
+	}
+	name := metricName(legacyExporterPrefix, "socket_skmem_drops_total")
+	dropped := prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: "Packets the kernel has dropped on the socket." + note}, labels)
+	prometheus.MustRegister(dropped)
+	skmemDropped = newCounterTracker("skmem", name, dropped)
+}
+
+// watchSkmem periodically exports the sock_diag memory counters of every UDP
+// socket the target has open, or with --sockets.namespace-wide every one in
+// the network namespace of targetPID.
+func watchSkmem(ctx context.Context) {
+	registerSkmemMetrics()
+
+	for {
+		nsPath := procPath(currentTargetPID(), "ns", "net")
+		owned := watchedInodes()
+		totals := make(map[[4]string]*[skmemVars]uint64)
+		for _, family := range []uint8{afInet, afInet6} {
+			sockets, err := listUDPSockets(nsPath, family, true)
+			if err != nil {
+				slog.Warn("Unable to collect socket memory stats", "err", err)
+				continue
+			}
+			for _, s := range sockets {
+				if owned != nil && !owned[strconv.FormatUint(uint64(s.inode), 10)] {
+					continue
+				}
+				key := [4]string{diagProtocol(s.family), s.localAddr.String(), strconv.Itoa(int(s.localPort))}
+				if skmemInodeLabel {
+					key[3] = strconv.FormatUint(uint64(s.inode), 10)
+				}
+				t, ok := totals[key]
+				if !ok {
+					t = new([skmemVars]uint64)
+					totals[key] = t
+				}
+				for i, v := range s.skmem {
+					t[i] += uint64(v)
+				}
+			}
+		}
+
+		keys := make([][4]string, 0, len(totals))
+		for key := range totals {
+			keys = append(keys, key)
+		}
+		// Should we hit --series.max-per-metric, the sockets with the most
+		// receive memory allocated are the interesting ones.
+		sort.Slice(keys, func(i, j int) bool {
+			return totals[keys[i]][skmemRmemAlloc] > totals[keys[j]][skmemRmemAlloc]
+		})
+		labels := func(key [4]string) []string {
+			if skmemInodeLabel {
+				return key[:]
+			}
+			return key[:3]
+		}
+		for _, g := range skmemGauges {
+			for _, key := range keys {
+				if g.gc.admit(labels(key)...) {
+					g.gauge.WithLabelValues(labels(key)...).Set(float64(totals[key][g.index]))
+				}
+			}
+			g.gc.sweep()
+		}
+		// A socket sharing an address closing lowers the sum, which
+		// just resyncs the counter.
+		for _, key := range keys {
+			skmemDropped.set(totals[key][skmemDrops], labels(key)...)
+		}
+		skmemDropped.sweep()
+
+		if !sleepContext(ctx, currentPollInterval()) {
+			return
+		}
+	}
+}
+
+func diagProtocol(family uint8) string {
+	if family == afInet6 {
+		return "udp6"
+	}
+	return "udp"
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snapshot is the file format written by the snapshot subcommand.
+type snapshot struct {
+	Time    time.Time        `json:"time"`
+	Process string           `json:"process"`
+	PID     string           `json:"pid"`
+	Sockets []snapshotSocket `json:"sockets"`
+}
+
+type snapshotSocket struct {
+	Protocol string `json:"protocol"`
+	Local    string `json:"local"`
+	Remote   string `json:"remote"`
+	Inode    string `json:"inode"`
+	Queued   int64  `json:"queued"`
+	Drops    int64  `json:"drops"`
+}
+
+func (s snapshotSocket) key() string {
+	return s.Protocol + " " + s.Inode
+}
+
+// runSnapshot implements the snapshot subcommand: it writes the UDP sockets
+// of the process named procName, with their queued and dropped counts, to
+// filename.
+func runSnapshot(ctx context.Context, w io.Writer, procName, filename string) int {
+	pid, err := findPIDByName(ctx, procName)
+	if err != nil {
+		fmt.Fprintln(w, err)
+		return 1
+	}
+	targetPID = pid
+	if targetPID == "" {
+		fmt.Fprintln(w, "Unable to find proc with the name: "+procName)
+		return 1
+	}
+
+	snap := snapshot{Time: time.Now(), Process: procName, PID: targetPID}
+	for _, protocol := range protocols {
+		sockets, err := readSockets(protocol, procPath(targetPID, "net", protocol))
+		if err != nil {
+			fmt.Fprintf(w, "Unable to read %s table: %v\n", protocol, err)
+			return 1
+		}
+		snap.Sockets = append(snap.Sockets, sockets...)
+	}
+
+	b, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		fmt.Fprintln(w, "Unable to encode snapshot:", err)
+		return 1
+	}
+	if err := ioutil.WriteFile(filename, append(b, '\n'), 0644); err != nil {
+		fmt.Fprintln(w, "Unable to write snapshot:", err)
+		return 1
+	}
+	fmt.Fprintf(w, "Wrote %d sockets of PID %s to %s\n", len(snap.Sockets), targetPID, filename)
+	return 0
+}
+
+// runDiff implements the diff subcommand: it prints how the queued and
+// dropped counts of each socket changed between the snapshots in files a and
+// b. Sockets are matched by inode, which is stable for a socket's lifetime.
+func runDiff(w io.Writer, a, b string) int {
+	before, err := readSnapshot(a)
+	if err != nil {
+		fmt.Fprintln(w, "Unable to read snapshot:", err)
+		return 1
+	}
+	after, err := readSnapshot(b)
+	if err != nil {
+		fmt.Fprintln(w, "Unable to read snapshot:", err)
+		return 1
+	}
+
+	fmt.Fprintf(w, "%s (PID %s) over %s\n", after.Process, after.PID, after.Time.Sub(before.Time).Round(time.Millisecond))
+	if before.PID != after.PID {
+		fmt.Fprintf(w, "PID changed from %s, the process was restarted in between\n", before.PID)
+	}
+
+	old := make(map[string]snapshotSocket, len(before.Sockets))
+	for _, s := range before.Sockets {
+		old[s.key()] = s
+	}
+	var lines []string
+	var drops int64
+	for _, s := range after.Sockets {
+		prev, ok := old[s.key()]
+		delete(old, s.key())
+		if !ok {
+			lines = append(lines, fmt.Sprintf("+ %-5s %-40s inode %-10s queued %-8d drops %d", s.Protocol, s.Local, s.Inode, s.Queued, s.Drops))
+			continue
+		}
+		drops += s.Drops - prev.Drops
+		lines = append(lines, fmt.Sprintf("  %-5s %-40s inode %-10s queued %+-8d drops %+d", s.Protocol, s.Local, s.Inode, s.Queued-prev.Queued, s.Drops-prev.Drops))
+	}
+	for _, s := range old {
+		lines = append(lines, fmt.Sprintf("- %-5s %-40s inode %-10s queued %-8d drops %d", s.Protocol, s.Local, s.Inode, s.Queued, s.Drops))
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i][2:] < lines[j][2:] })
+	for _, l := range lines {
+		fmt.Fprintln(w, l)
+	}
+	fmt.Fprintf(w, "%+d drops on sockets open in both snapshots\n", drops)
+	return 0
+}
+
+func readSnapshot(filename string) (*snapshot, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return &snap, nil
+}
+
+// readSockets parses every socket in a procfs UDP table.
+func readSockets(protocol, filename string) ([]snapshotSocket, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseSockets(protocol, f)
+}
+
+// parseSockets is readSockets for a table that's already open.
+func parseSockets(protocol string, r io.Reader) ([]snapshotSocket, error) {
+	var sockets []snapshotSocket
+	s := bufio.NewScanner(r)
+	for n := 0; s.Scan(); n++ {
+		// Skip the header lines.
+		if n < 1 {
+			continue
+		}
+		fields := strings.Fields(s.Text())
+		if len(fields) < 13 {
+			continue
+		}
+		local, err := procfsHostPort(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		remote, err := procfsHostPort(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		queued, err := strconv.ParseInt(strings.Split(fields[4], ":")[1], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse queued UDP buffers: %v", err)
+		}
+		drops, err := strconv.ParseInt(fields[12], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse dropped UDP buffers: %v", err)
+		}
+		sockets = append(sockets, snapshotSocket{
+			Protocol: protocol,
+			Local:    local,
+			Remote:   remote,
+			Inode:    fields[9],
+			Queued:   queued,
+			Drops:    drops,
+		})
+	}
+	return sockets, s.Err()
+}
+
+func procfsHostPort(s string) (string, error) {
+	ip, port, err := parseProcfsAddr(s)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(port)), nil
+}
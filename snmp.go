@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// snmpCounters are the UDP MIB counters we export, by their name in
+// /proc/net/snmp, with the name after the metric prefix they are exported
+// under.
+var snmpCounters = []struct {
+	field, name, help string
+}{
+	{"InDatagrams", "snmp_in_datagrams_total", "Datagrams delivered to UDP sockets in the target's network namespace."},
+	{"NoPorts", "snmp_no_ports_total", "Datagrams received in the target's network namespace for a port nothing listens on."},
+	{"InErrors", "snmp_in_errors_total", "Datagrams received in the target's network namespace that couldn't be delivered, including RcvbufErrors."},
+	{"RcvbufErrors", "snmp_rcvbuf_errors_total", "Datagrams dropped in the target's network namespace because a socket's receive buffer was full."},
+	{"SndbufErrors", "snmp_sndbuf_errors_total", "Datagrams not sent in the target's network namespace because a socket's send buffer was full."},
+}
+
+// snmpCollector exports the Udp, Udp6, UdpLite and UdpLite6 counters of the
+// network namespace of targetPID, read at scrape time from
+// /proc/<targetPID>/net/snmp and snmp6. RcvbufErrors counts the same drops as
+// the per-socket drops column, but includes sockets that have since closed.
+type snmpCollector struct {
+	descs []*prometheus.Desc
+}
+
+func newSNMPCollector() *snmpCollector {
+	c := &snmpCollector{}
+	for _, counter := range snmpCounters {
+		c.descs = append(c.descs, prometheus.NewDesc(metricName(legacyExporterPrefix, counter.name), counter.help, []string{"protocol"}, nil))
+	}
+	return c
+}
+
+func (c *snmpCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range c.descs {
+		ch <- desc
+	}
+}
+
+func (c *snmpCollector) Collect(ch chan<- prometheus.Metric) {
+	values, err := readSNMP(procPath(currentTargetPID(), "net", "snmp"))
+	if err != nil {
+		slog.Warn("Unable to read snmp counters", "err", err)
+		return
+	}
+	// IPv6 kernels only.
+	if values6, err := readSNMP6(procPath(currentTargetPID(), "net", "snmp6")); err == nil {
+		for k, v := range values6 {
+			values[k] = v
+		}
+	}
+
+	for _, protocol := range []string{"Udp", "Udp6", "UdpLite", "UdpLite6"} {
+		for i, counter := range snmpCounters {
+			if v, ok := values[protocol+"_"+counter.field]; ok {
+				ch <- prometheus.MustNewConstMetric(c.descs[i], prometheus.CounterValue, v, strings.ToLower(protocol))
+			}
+		}
+	}
+}
+
+// readSNMP parses /proc/net/snmp, where each MIB is a line of field names
+// followed by a line of their values, both prefixed with the MIB's name. The
+// values are returned keyed by MIB and field, e.g. Udp_InDatagrams.
+// /proc/net/netstat has the same format.
+func readSNMP(filename string) (map[string]float64, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]float64)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		names := strings.Fields(s.Text())
+		if len(names) == 0 || !s.Scan() {
+			break
+		}
+		fields := strings.Fields(s.Text())
+		if len(names) != len(fields) || names[0] != fields[0] {
+			return nil, fmt.Errorf("unable to parse %s: mismatched %s lines", filename, names[0])
+		}
+		mib := strings.TrimSuffix(names[0], ":")
+		for i := 1; i < len(names); i++ {
+			if v, err := strconv.ParseFloat(fields[i], 64); err == nil {
+				values[mib+"_"+names[i]] = v
+			}
+		}
+	}
+	return values, s.Err()
+}
+
+// readSNMP6 parses /proc/net/snmp6, a name and value per line. The names run
+// the MIB and field together, e.g. Udp6InDatagrams, so they're split after
+// the 6 to key them like readSNMP.
+func readSNMP6(filename string) (map[string]float64, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]float64)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		i := strings.IndexByte(fields[0], '6')
+		if i < 0 {
+			continue
+		}
+		if v, err := strconv.ParseFloat(fields[1], 64); err == nil {
+			values[fields[0][:i+1]+"_"+fields[0][i+1:]] = v
+		}
+	}
+	return values, s.Err()
+}
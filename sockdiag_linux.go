@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// Constants from linux/sock_diag.h and linux/inet_diag.h.
+const (
+	sockDiagByFamily  = 20
+	inetDiagSkmeminfo = 7
+
+	sizeofInetDiagReqV2 = 56
+	sizeofInetDiagMsg   = 72
+)
+
+// listUDPSockets enumerates the UDP sockets of family (AF_INET or AF_INET6)
+// in the network namespace at nsPath via NETLINK_SOCK_DIAG. With skmem set
+// the kernel is also asked for each socket's memory counters.
+func listUDPSockets(nsPath string, family uint8, skmem bool) ([]diagSocket, error) {
+	return listDiagSockets(nsPath, family, unix.IPPROTO_UDP, skmem)
+}
+
+// listDiagSockets is listUDPSockets for any IP protocol inet_diag knows,
+// such as IPPROTO_UDPLITE.
+func listDiagSockets(nsPath string, family, protocol uint8, skmem bool) ([]diagSocket, error) {
+	fd, err := netlinkDial(nsPath, unix.NETLINK_SOCK_DIAG)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(fd)
+
+	req := make([]byte, sizeofInetDiagReqV2)
+	req[0] = family
+	req[1] = protocol
+	if skmem {
+		req[2] = 1 << (inetDiagSkmeminfo - 1)
+	}
+	binary.LittleEndian.PutUint32(req[4:], 0xffffffff) // all states
+
+	msgs, err := netlinkDump(fd, sockDiagByFamily, req)
+	if err != nil {
+		return nil, err
+	}
+
+	sockets := make([]diagSocket, 0, len(msgs))
+	for _, m := range msgs {
+		if len(m) < sizeofInetDiagMsg {
+			continue
+		}
+		addrLen := net.IPv4len
+		if m[0] == unix.AF_INET6 {
+			addrLen = net.IPv6len
+		}
+		s := diagSocket{
+			family:     m[0],
+			localPort:  binary.BigEndian.Uint16(m[4:]),
+			remotePort: binary.BigEndian.Uint16(m[6:]),
+			localAddr:  net.IP(append([]byte(nil), m[8:8+addrLen]...)),
+			remoteAddr: net.IP(append([]byte(nil), m[24:24+addrLen]...)),
+			rxQueue:    binary.LittleEndian.Uint32(m[56:]),
+			txQueue:    binary.LittleEndian.Uint32(m[60:]),
+			uid:        binary.LittleEndian.Uint32(m[64:]),
+			inode:      binary.LittleEndian.Uint32(m[68:]),
+		}
+		netlinkAttrs(m[sizeofInetDiagMsg:], func(typ uint16, data []byte) {
+			if typ != inetDiagSkmeminfo {
+				return
+			}
+			for i := 0; i < len(s.skmem) && (i+1)*4 <= len(data); i++ {
+				s.skmem[i] = binary.LittleEndian.Uint32(data[i*4:])
+			}
+			s.hasSkmem = true
+		})
+		sockets = append(sockets, s)
+	}
+	return sockets, nil
+}
+
+// Constants from linux/unix_diag.h.
+const (
+	unixDiagName  = 0
+	unixDiagPeer  = 2
+	unixDiagRqlen = 4
+
+	udiagShowName  = 0x01
+	udiagShowPeer  = 0x04
+	udiagShowRqlen = 0x10
+
+	sizeofUnixDiagReq = 24
+	sizeofUnixDiagMsg = 16
+)
+
+// listUnixSockets enumerates the unix domain sockets of type sockType (e.g.
+// SOCK_DGRAM) in the network namespace at nsPath via NETLINK_SOCK_DIAG.
+func listUnixSockets(nsPath string, sockType uint8) ([]unixSocket, error) {
+	fd, err := netlinkDial(nsPath, unix.NETLINK_SOCK_DIAG)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(fd)
+
+	req := make([]byte, sizeofUnixDiagReq)
+	req[0] = unix.AF_UNIX
+	binary.LittleEndian.PutUint32(req[4:], 0xffffffff) // all states
+	binary.LittleEndian.PutUint32(req[12:], udiagShowName|udiagShowPeer|udiagShowRqlen)
+
+	msgs, err := netlinkDump(fd, sockDiagByFamily, req)
+	if err != nil {
+		return nil, err
+	}
+
+	sockets := make([]unixSocket, 0, len(msgs))
+	for _, m := range msgs {
+		if len(m) < sizeofUnixDiagMsg || m[1] != sockType {
+			continue
+		}
+		s := unixSocket{inode: binary.LittleEndian.Uint32(m[4:])}
+		netlinkAttrs(m[sizeofUnixDiagMsg:], func(typ uint16, data []byte) {
+			switch {
+			case typ == unixDiagName:
+				s.path = unixSocketName(data)
+			case typ == unixDiagPeer && len(data) >= 4:
+				s.peer = binary.LittleEndian.Uint32(data)
+			case typ == unixDiagRqlen && len(data) >= 8:
+				s.rxQueue = binary.LittleEndian.Uint32(data)
+				s.txQueue = binary.LittleEndian.Uint32(data[4:])
+			}
+		})
+		sockets = append(sockets, s)
+	}
+	return sockets, nil
+}
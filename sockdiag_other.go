@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+func listUDPSockets(nsPath string, family uint8, skmem bool) ([]diagSocket, error) {
+	return nil, errors.New("sock_diag is only supported on linux")
+}
+
+func listDiagSockets(nsPath string, family, protocol uint8, skmem bool) ([]diagSocket, error) {
+	return nil, errors.New("sock_diag is only supported on linux")
+}
+
+func listUnixSockets(nsPath string, sockType uint8) ([]unixSocket, error) {
+	return nil, errors.New("sock_diag is only supported on linux")
+}
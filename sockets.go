@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var socketLabels = []string{"protocol", "local_addr", "local_port", "inode", "owner_process", "owner_pid"}
+
+var (
+	socketQueued      *prometheus.GaugeVec
+	socketDropped     *prometheus.CounterVec
+	socketUtilization *prometheus.GaugeVec
+)
+
+// newSocketMetrics builds the sockets collector's metrics, which
+// /api/v1/cardinality describes whether or not the collector runs.
+func newSocketMetrics() {
+	socketQueued = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: metricName(legacySocketPrefix, "socket_rx_queue_bytes"),
+		Help: "Bytes queued on each UDP socket of the target.",
+	}, socketLabels)
+	socketDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: metricName(legacySocketPrefix, "socket_drops_total"),
+		Help: "Packets dropped by each UDP socket of the target.",
+	}, socketLabels)
+	socketUtilization = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: metricName(legacySocketPrefix, "socket_buffer_utilization_ratio"),
+		Help: "Bytes queued on each UDP socket of the target over its receive buffer size.",
+	}, socketLabels)
+}
+
+// watchSockets periodically exports the queued and dropped counts of every
+// socket the targets own in their procfs UDP tables separately, so one
+// listener backing up can be told apart from the others in the same process.
+// Each socket is labelled with the process that has it open, which with
+// --sockets.namespace-wide is whichever process in the namespace that is.
+func watchSockets(ctx context.Context) {
+	prometheus.MustRegister(socketQueued, socketDropped, socketUtilization)
+	queuedGC := newGaugeGC("sockets", metricName(legacySocketPrefix, "socket_rx_queue_bytes"), socketQueued)
+	utilizationGC := newGaugeGC("sockets", metricName(legacySocketPrefix, "socket_buffer_utilization_ratio"), socketUtilization)
+	dropped := newCounterTracker("sockets", metricName(legacySocketPrefix, "socket_drops_total"), socketDropped)
+	owners := make(map[string]*socketOwnerCache)
+	warned := false
+
+	for {
+		// Each namespace's tables are read from one of the targets'
+		// processes in it, and each socket is owned by the first of them
+		// found to have it open.
+		var namespaces []string
+		tablePIDs := make(map[string]string)
+		owned := make(map[string]foundProcess)
+		lookup := socketsNamespaceWide
+		for _, t := range currentTargets() {
+			for _, pid := range t.livePIDs() {
+				netns, err := os.Readlink(procPath(pid, "ns", "net"))
+				if err != nil {
+					continue
+				}
+				if _, ok := tablePIDs[netns]; !ok {
+					namespaces = append(namespaces, netns)
+					tablePIDs[netns] = pid
+				}
+				if socketsNamespaceWide {
+					continue
+				}
+				inodes, err := ownedInodes(pid)
+				if err != nil {
+					// Without its descriptors the sockets can't
+					// be told apart, so count the namespace's and
+					// look up who has each open instead.
+					if !warned {
+						slog.Warn("Unable to list the sockets of the target, exporting its whole network namespace's", "pid", pid, "err", err)
+						warned = true
+					}
+					lookup = true
+					continue
+				}
+				name := processName(pid)
+				for inode := range inodes {
+					if _, ok := owned[inode]; !ok {
+						owned[inode] = foundProcess{pid, name}
+					}
+				}
+			}
+		}
+
+		for _, netns := range namespaces {
+			pid := tablePIDs[netns]
+			cache := owners[netns]
+			if cache == nil {
+				cache = &socketOwnerCache{}
+				owners[netns] = cache
+			}
+			cache.scanned = false
+			owner := func(inode string) (foundProcess, bool) {
+				if p, ok := owned[inode]; ok {
+					return p, true
+				}
+				if lookup {
+					return cache.lookup(ctx, netns, inode), true
+				}
+				return foundProcess{}, false
+			}
+			for _, protocol := range protocols {
+				sockets, err := readSockets(protocol, procPath(pid, "net", protocol))
+				if os.IsNotExist(err) {
+					continue
+				}
+				if err != nil {
+					slog.Warn("Unable to collect per-socket stats", "err", err)
+					continue
+				}
+				// Buffer sizes are only in sock_diag, so sockets it
+				// can't list have no utilization.
+				rcvbufs, err := diagRcvbufs([]string{procPath(pid, "ns", "net")}, protocol)
+				if err != nil {
+					slog.Warn("Unable to collect per-socket buffer sizes", "err", err)
+				}
+				for _, s := range sockets {
+					// Each namespace's tables are read once and a
+					// socket is only in its own family's, a dual-stack
+					// one in udp6, so none is listed twice. The tables
+					// hold other processes' sockets, though.
+					p, ok := owner(s.Inode)
+					if !ok {
+						continue
+					}
+
+					addr, port, _ := net.SplitHostPort(s.Local)
+					labels := []string{protocol, addr, port, s.Inode, p.name, p.pid}
+					if queuedGC.admit(labels...) {
+						socketQueued.WithLabelValues(labels...).Set(float64(s.Queued))
+					}
+					if rcvbuf := rcvbufs[s.Inode]; rcvbuf > 0 && utilizationGC.admit(labels...) {
+						socketUtilization.WithLabelValues(labels...).Set(float64(s.Queued) / float64(rcvbuf))
+					}
+					dropped.set(uint64(s.Drops), labels...)
+				}
+			}
+		}
+		queuedGC.sweep()
+		utilizationGC.sweep()
+		dropped.sweep()
+
+		if !sleepContext(ctx, currentPollInterval()) {
+			return
+		}
+	}
+}
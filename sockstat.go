@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sockstatCollector exports the socket inventory of the network namespace of
+// targetPID from /proc/<targetPID>/net/sockstat and sockstat6, read at scrape
+// time. Queues often back up because UDP as a whole is short of memory rather
+// than because of anything the one socket did.
+type sockstatCollector struct {
+	used, inuse, mem *prometheus.Desc
+}
+
+func newSockstatCollector() *sockstatCollector {
+	return &sockstatCollector{
+		used:  prometheus.NewDesc(metricName(legacyExporterPrefix, "sockstat_sockets_used"), "Sockets of every kind in use in the target's network namespace.", nil, nil),
+		inuse: prometheus.NewDesc(metricName(legacyExporterPrefix, "sockstat_inuse"), "UDP and UDP-Lite sockets in use in the target's network namespace.", []string{"protocol"}, nil),
+		mem:   prometheus.NewDesc(metricName(legacyExporterPrefix, "sockstat_mem_bytes"), "Memory allocated to UDP sockets on the whole host, which net.ipv4.udp_mem limits.", []string{"protocol"}, nil),
+	}
+}
+
+func (c *sockstatCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.used
+	ch <- c.inuse
+	ch <- c.mem
+}
+
+func (c *sockstatCollector) Collect(ch chan<- prometheus.Metric) {
+	stats, err := readSockstat(procPath(currentTargetPID(), "net", "sockstat"))
+	if err != nil {
+		slog.Warn("Unable to read sockstat", "err", err)
+		return
+	}
+	// IPv6 kernels only.
+	if stats6, err := readSockstat(procPath(currentTargetPID(), "net", "sockstat6")); err == nil {
+		for k, v := range stats6 {
+			stats[k] = v
+		}
+	}
+
+	if v, ok := stats["sockets"]["used"]; ok {
+		ch <- prometheus.MustNewConstMetric(c.used, prometheus.GaugeValue, v)
+	}
+	for _, protocol := range []string{"UDP", "UDP6", "UDPLITE", "UDPLITE6"} {
+		if v, ok := stats[protocol]["inuse"]; ok {
+			ch <- prometheus.MustNewConstMetric(c.inuse, prometheus.GaugeValue, v, strings.ToLower(protocol))
+		}
+		if v, ok := stats[protocol]["mem"]; ok {
+			// Counted in pages.
+			ch <- prometheus.MustNewConstMetric(c.mem, prometheus.GaugeValue, v*float64(os.Getpagesize()), strings.ToLower(protocol))
+		}
+	}
+}
+
+// readSockstat parses lines like "UDP: inuse 3 mem 12" into
+// stats["UDP"]["inuse"] and so on.
+func readSockstat(filename string) (map[string]map[string]float64, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stats := make(map[string]map[string]float64)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		values := make(map[string]float64)
+		for i := 1; i+1 < len(fields); i += 2 {
+			if v, err := strconv.ParseFloat(fields[i+1], 64); err == nil {
+				values[fields[i]] = v
+			}
+		}
+		stats[strings.TrimSuffix(fields[0], ":")] = values
+	}
+	return stats, s.Err()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// softnetCollector exports the per-CPU backlog counters of
+// /proc/net/softnet_stat, read at scrape time. Packets dropped there never
+// reach a socket, so they show up in none of the other metrics.
+type softnetCollector struct {
+	processed, dropped, squeezed *prometheus.Desc
+}
+
+func newSoftnetCollector() *softnetCollector {
+	return &softnetCollector{
+		processed: prometheus.NewDesc(metricName(legacyExporterPrefix, "softnet_processed_total"), "Packets the CPU's network backlog processed.", []string{"cpu"}, nil),
+		dropped:   prometheus.NewDesc(metricName(legacyExporterPrefix, "softnet_dropped_total"), "Packets dropped because the CPU's network backlog was full (net.core.netdev_max_backlog).", []string{"cpu"}, nil),
+		squeezed:  prometheus.NewDesc(metricName(legacyExporterPrefix, "softnet_times_squeezed_total"), "Times the CPU's softirq ran out of budget with packets left to process (net.core.netdev_budget).", []string{"cpu"}, nil),
+	}
+}
+
+func (c *softnetCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.processed
+	ch <- c.dropped
+	ch <- c.squeezed
+}
+
+func (c *softnetCollector) Collect(ch chan<- prometheus.Metric) {
+	f, err := os.Open(procPath(currentTargetPID(), "net", "softnet_stat"))
+	if err != nil {
+		slog.Warn("Unable to read softnet_stat", "err", err)
+		return
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for line := 0; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		// Since 5.10 the 13th column is the CPU, as offline CPUs are
+		// skipped. Before that the lines are in CPU order.
+		cpu := strconv.Itoa(line)
+		if len(fields) >= 13 {
+			if n, err := strconv.ParseUint(fields[12], 16, 32); err == nil {
+				cpu = strconv.FormatUint(n, 10)
+			}
+		}
+		for i, desc := range []*prometheus.Desc{c.processed, c.dropped, c.squeezed} {
+			if v, err := strconv.ParseUint(fields[i], 16, 32); err == nil {
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(v), cpu)
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		slog.Warn("Unable to read softnet_stat", "err", err)
+	}
+}
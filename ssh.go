@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// newSSHTarget returns a target that reads the procfs UDP tables of procName
+// on a remote host by running commands over ssh. We shell out to the system
+// ssh client so that keys, agents, known_hosts and ssh_config all behave the
+// way operators already expect them to.
+func newSSHTarget(host, user, identityFile, procName string) *target {
+	args := []string{"-o", "BatchMode=yes"}
+	if identityFile != "" {
+		args = append(args, "-i", identityFile)
+	}
+
+	address := host
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		address = h
+		args = append(args, "-p", port)
+	}
+	if user != "" && !strings.Contains(address, "@") {
+		address = user + "@" + address
+	}
+	args = append(args, address)
+
+	return &target{
+		name:     host,
+		labels:   []string{host},
+		sdLabels: map[string]string{"host": host, "process": procName},
+		open: func(ctx context.Context, protocol string) (io.ReadCloser, error) {
+			cmd := exec.CommandContext(ctx, "ssh", append(args, remoteNetFileCommand(procName, protocol))...)
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			out, err := cmd.Output()
+			if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == exitProcNotFound {
+				return nil, &os.PathError{Op: "open", Path: host + ":/proc/<" + procName + ">/net/" + protocol, Err: os.ErrNotExist}
+			}
+			if err != nil {
+				return nil, fmt.Errorf("ssh %s: %v: %s", host, err, strings.TrimSpace(stderr.String()))
+			}
+			return ioutil.NopCloser(bytes.NewReader(out)), nil
+		},
+	}
+}
+
+// remoteNetFileCommand builds a POSIX shell command that finds procName the
+// same way findPIDByName does (an exact, fixed-string match on the Name: line
+// of /proc/<pid>/status) and prints its net table for protocol. It only relies
+// on grep and cut so it works on busybox based appliances.
+func remoteNetFileCommand(procName, protocol string) string {
+	return "pid=$(grep -F -x -l " + shellQuote("Name:\t"+procName) + " /proc/[0-9]*/status 2>/dev/null | tail -n 1 | cut -d/ -f3); " +
+		"[ -n \"$pid\" ] && [ -e /proc/$pid/net/" + protocol + " ] || exit " + strconv.Itoa(exitProcNotFound) + "; " +
+		"cat /proc/$pid/net/" + protocol
+}
+
+// exitProcNotFound is the status remoteNetFileCommand exits with when no
+// process matches, or the remote kernel lacks the table, so we can tell a
+// missing target from an ssh failure.
+const exitProcNotFound = 3
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRemoteNetFileCommand(t *testing.T) {
+	// Process names are matched literally, not as grep patterns.
+	got := remoteNetFileCommand("statsd.*[1]", "udp6")
+	want := "grep -F -x -l 'Name:\tstatsd.*[1]' /proc/[0-9]*/status"
+	if !strings.Contains(got, want) {
+		t.Errorf("remoteNetFileCommand() = %q, want it to contain %q", got, want)
+	}
+	if !strings.HasSuffix(got, "cat /proc/$pid/net/udp6") {
+		t.Errorf("remoteNetFileCommand() = %q, want it to print the udp6 table", got)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The states a target moves through, exported as an enum style gauge so
+// fleet dashboards can count exporters by health with a simple sum by(state).
+const (
+	stateDiscovering      = "discovering"
+	stateCollecting       = "collecting"
+	stateDegraded         = "degraded"
+	stateTargetAbsent     = "target-absent"
+	statePermissionDenied = "permission-denied"
+)
+
+var (
+	states = []string{
+		stateDiscovering,
+		stateCollecting,
+		stateDegraded,
+		stateTargetAbsent,
+		statePermissionDenied,
+	}
+	exporterState *prometheus.GaugeVec
+)
+
+func registerStateMetric(targetLabels []string) {
+	exporterState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: metricName(legacyProcfsPrefix, "exporter_state"),
+			Help: "The current state of the exporter for a target, 1 for the active state and 0 for all others.",
+		},
+		append(append([]string{}, targetLabels...), "state"),
+	)
+	prometheus.MustRegister(exporterState)
+}
+
+// setState moves t into state, logging the transition.
+func (t *target) setState(state string) {
+	t.stateMu.Lock()
+	defer t.stateMu.Unlock()
+	if t.state == state {
+		return
+	}
+	if t.state != "" {
+		slog.Info("Target changed state", "target", t.name, "from", t.state, "to", state)
+	}
+	t.state = state
+
+	for _, s := range states {
+		v := 0.0
+		if s == state {
+			v = 1
+		}
+		exporterState.WithLabelValues(append(append([]string{}, t.labels...), s)...).Set(v)
+	}
+}
+
+func (t *target) currentState() string {
+	t.stateMu.Lock()
+	defer t.stateMu.Unlock()
+	return t.state
+}
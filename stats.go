@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+)
+
+// targetStats is one watched target on /api/v1/stats.
+type targetStats struct {
+	Labels map[string]string `json:"labels"`
+	State  string            `json:"state"`
+	// Protocols are the totals of the last poll.
+	Protocols map[string]protocolTotals `json:"protocols"`
+	// Sockets are those of the last poll too.
+	Sockets []snapshotSocket `json:"sockets"`
+}
+
+// protocolTotals are what a poll summed over a protocol's table. Dropped is
+// the kernel's count over the sockets' lifetimes, not the exporter's counter.
+type protocolTotals struct {
+	Queued   int `json:"queued"`
+	TxQueued int `json:"tx_queued"`
+	Dropped  int `json:"dropped"`
+}
+
+// serveStats reports the queued and dropped counts of every watched target at
+// its last poll, and of each of its sockets, as JSON for tools that don't
+// speak Prometheus.
+func serveStats(w http.ResponseWriter, r *http.Request) {
+	targets := currentTargets()
+	stats := make([]targetStats, 0, len(targets))
+	for _, t := range targets {
+		s := targetStats{
+			Labels:    make(map[string]string),
+			State:     t.currentState(),
+			Protocols: make(map[string]protocolTotals),
+			Sockets:   []snapshotSocket{},
+		}
+		t.stateMu.Lock()
+		for k, v := range t.sdLabels {
+			s.Labels[k] = v
+		}
+		for protocol, totals := range t.totals {
+			s.Protocols[protocol] = totals
+		}
+		t.stateMu.Unlock()
+
+		seen := make(map[string]bool)
+		for _, protocol := range protocols {
+			f, owned, err := t.polledTable(r.Context(), protocol)
+			if err != nil {
+				continue
+			}
+			sockets, err := parseSockets(protocol, f)
+			f.Close()
+			if err != nil {
+				continue
+			}
+			for _, socket := range sockets {
+				// As in parseProcfsNet.
+				if seen[socket.Inode] || owned != nil && !owned[socket.Inode] {
+					continue
+				}
+				seen[socket.Inode] = true
+				s.Sockets = append(s.Sockets, socket)
+			}
+		}
+		stats = append(stats, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// polledTable returns t's protocol table as the last poll read it, and the
+// sockets the poll counted in it, nil meaning all of them, so that serving
+// the API doesn't read remote targets' tables again. A table the netlink
+// backend read through sock_diag instead, which only local targets do, is
+// read from procfs afresh.
+func (t *target) polledTable(ctx context.Context, protocol string) (io.ReadCloser, map[string]bool, error) {
+	t.stateMu.Lock()
+	table, polled := t.tables[protocol]
+	owned := t.owned
+	t.stateMu.Unlock()
+	if !polled {
+		return nil, nil, &os.PathError{Op: "open", Path: protocol, Err: os.ErrNotExist}
+	}
+	if len(table) == 0 {
+		f, err := t.open(ctx, protocol)
+		return f, owned, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(table)), owned, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestServeStatsFromLastPoll(t *testing.T) {
+	// A remote target whose host has gone away since its last poll.
+	tgt := &target{
+		name:     "statsd",
+		sdLabels: map[string]string{"host": "appliance"},
+		open: func(context.Context, string) (io.ReadCloser, error) {
+			t.Error("the table was read again to serve the request")
+			return nil, errors.New("ssh: connection refused")
+		},
+		tables: map[string][]byte{"udp": []byte(udpTable("8125 100", "8126 101", "9000 102"))},
+		owned:  map[string]bool{"100": true, "101": true},
+	}
+	defer func(targets []*target) { watchedTargets = targets }(watchedTargets)
+	watchedTargets = []*target{tgt}
+
+	w := httptest.NewRecorder()
+	serveStats(w, httptest.NewRequest("GET", "/api/v1/stats", nil))
+	var stats []targetStats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || len(stats[0].Sockets) != 2 {
+		t.Fatalf("/api/v1/stats = %+v, want the 2 sockets the poll counted", stats)
+	}
+
+	if got, want := tgt.listeningPorts(context.Background()), []string{"8125", "8126"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listeningPorts() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// statsdMaxPacket keeps datagrams under a typical MTU, as the agent drops
+// fragmented ones.
+const statsdMaxPacket = 1432
+
+// emitStatsd sends the exporter's gauges and counters to the DogStatsD agent
+// at address every interval, gauges as gauges and counters as the count since
+// the previous send, with the labels as tags. The exporter's own runtime
+// metrics, and summaries and histograms, aren't sent. It returns once ctx is
+// done.
+func emitStatsd(ctx context.Context, address string, interval time.Duration) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		slog.Error("Unable to reach the StatsD agent", "err", err)
+		return
+	}
+	defer conn.Close()
+
+	previous := make(map[string]float64)
+	for sleepContext(ctx, interval) {
+		families, err := snapshotGatherer().Gather()
+		if err != nil {
+			slog.Warn("Unable to gather metrics for StatsD", "err", err)
+			continue
+		}
+		var packet bytes.Buffer
+		for _, line := range statsdLines(families, previous) {
+			if packet.Len() > 0 && packet.Len()+1+len(line) > statsdMaxPacket {
+				sendStatsd(conn, packet.Bytes())
+				packet.Reset()
+			}
+			if packet.Len() > 0 {
+				packet.WriteByte('\n')
+			}
+			packet.WriteString(line)
+		}
+		if packet.Len() > 0 {
+			sendStatsd(conn, packet.Bytes())
+		}
+	}
+}
+
+func sendStatsd(conn net.Conn, packet []byte) {
+	if _, err := conn.Write(packet); err != nil {
+		slog.Warn("Unable to send to the StatsD agent", "err", err)
+	}
+}
+
+// statsdLines formats families as DogStatsD lines. previous holds each
+// counter's value at the last send, by line prefix, and is updated to the
+// counters in families; a counter is first sent on the send after it's seen,
+// and one that has gone down was reset, so its new value is the count sent.
+func statsdLines(families []*dto.MetricFamily, previous map[string]float64) []string {
+	var lines []string
+	current := make(map[string]bool)
+	for _, f := range families {
+		if isRuntimeMetric(f.GetName()) {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			var tags []string
+			for _, l := range m.GetLabel() {
+				tags = append(tags, l.GetName()+":"+l.GetValue())
+			}
+			sort.Strings(tags)
+			suffix := ""
+			if len(tags) > 0 {
+				suffix = "|#" + strings.Join(tags, ",")
+			}
+
+			switch f.GetType() {
+			case dto.MetricType_GAUGE:
+				lines = append(lines, f.GetName()+":"+formatStatsd(m.GetGauge().GetValue())+"|g"+suffix)
+			case dto.MetricType_UNTYPED:
+				lines = append(lines, f.GetName()+":"+formatStatsd(m.GetUntyped().GetValue())+"|g"+suffix)
+			case dto.MetricType_COUNTER:
+				key := f.GetName() + suffix
+				v := m.GetCounter().GetValue()
+				last, ok := previous[key]
+				previous[key] = v
+				current[key] = true
+				if !ok {
+					continue
+				}
+				if v >= last {
+					v -= last
+				}
+				lines = append(lines, f.GetName()+":"+formatStatsd(v)+"|c"+suffix)
+			}
+		}
+	}
+	for key := range previous {
+		if !current[key] {
+			delete(previous, key)
+		}
+	}
+	return lines
+}
+
+func formatStatsd(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestStatsdLines(t *testing.T) {
+	previous := make(map[string]float64)
+
+	// Counters are sent as their increase, so not until the second send.
+	got := statsdLines(finiteFamilies(), previous)
+	want := []string{
+		"udp_procfs_buffer_queued:7488|g|#protocol:udp",
+		"udp_procfs_buffer_queued:0|g|#protocol:udp6",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("first statsdLines():\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	families := finiteFamilies()
+	families[1].Metric[0].Counter.Value = proto.Float64(100)
+	got = statsdLines(families, previous)
+	want = []string{
+		"udp_procfs_buffer_queued:7488|g|#protocol:udp",
+		"udp_procfs_buffer_queued:0|g|#protocol:udp6",
+		"udp_procfs_buffer_dropped:9|c|#protocol:udp",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("second statsdLines():\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// A counter that went down was reset, so all of its new value is sent.
+	families = finiteFamilies()
+	families[1].Metric[0].Counter.Value = proto.Float64(4)
+	got = statsdLines(families[1:2], previous)
+	want = []string{"udp_procfs_buffer_dropped:4|c|#protocol:udp"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("statsdLines() after a reset:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestStatsdLinesForgetsSeriesGone(t *testing.T) {
+	previous := map[string]float64{"udp_procfs_buffer_dropped|#protocol:udp6": 3}
+	statsdLines(finiteFamilies(), previous)
+	want := map[string]float64{"udp_procfs_buffer_dropped|#protocol:udp": 91}
+	if !reflect.DeepEqual(previous, want) {
+		t.Errorf("previous = %v, want %v", previous, want)
+	}
+}
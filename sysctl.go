@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log/slog"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// bufferSysctls are the receive buffer limits exported by the sysctl
+// collector, with the name after the metric prefix of each one's metric.
+var bufferSysctls = []struct {
+	name, metric, help string
+}{
+	{"net/core/rmem_max", "sysctl_rmem_max_bytes", "net.core.rmem_max, the largest receive buffer a socket can ask for with SO_RCVBUF."},
+	{"net/core/rmem_default", "sysctl_rmem_default_bytes", "net.core.rmem_default, the receive buffer sockets start with."},
+	{"net/ipv4/udp_rmem_min", "sysctl_udp_rmem_min_bytes", "net.ipv4.udp_rmem_min, the receive buffer every UDP socket may use even under memory pressure."},
+}
+
+// sysctlCollector exports the kernel's receive buffer limits as seen from
+// the network namespace of targetPID, read at scrape time, to put next to
+// the queued bytes they cap.
+type sysctlCollector struct {
+	descs []*prometheus.Desc
+}
+
+func newSysctlCollector() *sysctlCollector {
+	c := &sysctlCollector{}
+	for _, s := range bufferSysctls {
+		c.descs = append(c.descs, prometheus.NewDesc(metricName(legacyExporterPrefix, s.metric), s.help, nil, nil))
+	}
+	return c
+}
+
+func (c *sysctlCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range c.descs {
+		ch <- desc
+	}
+}
+
+func (c *sysctlCollector) Collect(ch chan<- prometheus.Metric) {
+	nsPath := procPath(currentTargetPID(), "ns", "net")
+	for i, s := range bufferSysctls {
+		value, err := readNetNSSysctl(nsPath, s.name)
+		if err != nil {
+			slog.Warn("Unable to read sysctl", "sysctl", s.name, "err", err)
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			slog.Warn("Unable to parse sysctl", "sysctl", s.name, "err", err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.descs[i], prometheus.GaugeValue, v)
+	}
+}
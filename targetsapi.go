@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// apiTarget is one watched target on /api/v1/targets.
+type apiTarget struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels"`
+	State  string            `json:"state"`
+}
+
+// serveTargets lists the watched targets on GET /api/v1/targets, and with
+// --target or --netns and --web.enable-lifecycle starts watching another on
+// POST, given {"name": "<process name or netns path>"}, and stops watching one
+// on DELETE /api/v1/targets/<name>. Targets added this way last until they're
+// deleted or the exporter restarts; reloads keep them.
+func serveTargets(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/api/v1/targets")
+		name = strings.TrimPrefix(name, "/")
+		switch {
+		case r.Method == "GET" && name == "":
+			listTargets(w)
+		case (r.Method == "POST" && name == "" || r.Method == "DELETE" && name != "") && !enableLifecycle:
+			http.Error(w, "Lifecycle API is not enabled, see --web.enable-lifecycle.", http.StatusForbidden)
+		case r.Method == "POST" && name == "":
+			addTarget(ctx, w, r)
+		case r.Method == "DELETE" && name != "":
+			removeTarget(w, name)
+		default:
+			http.Error(w, "GET or POST /api/v1/targets, or DELETE /api/v1/targets/<name>.", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func listTargets(w http.ResponseWriter) {
+	targets := make([]apiTarget, 0)
+	for _, t := range currentTargets() {
+		targets = append(targets, describeTarget(t))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(targets)
+}
+
+func describeTarget(t *target) apiTarget {
+	a := apiTarget{Name: t.entry, Labels: make(map[string]string), State: t.currentState()}
+	if a.Name == "" {
+		a.Name = t.name
+	}
+	t.stateMu.Lock()
+	for k, v := range t.sdLabels {
+		a.Labels[k] = v
+	}
+	t.stateMu.Unlock()
+	return a
+}
+
+func addTarget(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if dynamicTargets == nil {
+		http.Error(w, "Targets can only be added with --target or --netns.", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		http.Error(w, `The body must be {"name": "<`+dynamicTargets.key+`>"}.`, http.StatusBadRequest)
+		return
+	}
+
+	targetChangesMu.Lock()
+	defer targetChangesMu.Unlock()
+	if len(targetsFor(req.Name)) > 0 || pidGroupFor(req.Name) != nil {
+		http.Error(w, req.Name+" is already watched.", http.StatusConflict)
+		return
+	}
+	// ctx, not the request's, as the targets outlive the request.
+	targets, err := dynamicTargets.add(ctx, req.Name)
+	if err != nil {
+		http.Error(w, "Unable to find "+req.Name+": "+err.Error(), http.StatusNotFound)
+		return
+	}
+	startTargets(ctx, dynamicTargets.key, targets)
+	apiEntries[req.Name] = true
+
+	added := make([]apiTarget, len(targets))
+	for i, t := range targets {
+		added[i] = describeTarget(t)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(added)
+}
+
+func removeTarget(w http.ResponseWriter, name string) {
+	if dynamicTargets == nil {
+		http.Error(w, "Targets can only be removed with --target or --netns.", http.StatusBadRequest)
+		return
+	}
+
+	targetChangesMu.Lock()
+	defer targetChangesMu.Unlock()
+	// The mux cleans paths, so a netns path arrives without its leading
+	// slash.
+	entry := name
+	if len(targetsFor(entry)) == 0 && pidGroupFor(entry) == nil {
+		entry = "/" + name
+	}
+	targets := targetsFor(entry)
+	if len(targets) == 0 && pidGroupFor(entry) == nil {
+		http.Error(w, name+" isn't watched.", http.StatusNotFound)
+		return
+	}
+	stopTargets(dynamicTargets.key, targets)
+	// Per-pid entries whose processes have all expired have no targets
+	// left to stop.
+	forgetPIDGroups([]string{entry})
+	delete(apiEntries, entry)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// targetsFor returns the targets started for entry.
+func targetsFor(entry string) []*target {
+	var found []*target
+	for _, t := range currentTargets() {
+		if t.entry == entry {
+			found = append(found, t)
+		}
+	}
+	return found
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeTargetsNeedsLifecycle(t *testing.T) {
+	defer func(enabled bool) { enableLifecycle = enabled }(enableLifecycle)
+	enableLifecycle = false
+
+	for _, tt := range []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/api/v1/targets", http.StatusOK},
+		{"POST", "/api/v1/targets", http.StatusForbidden},
+		{"DELETE", "/api/v1/targets/statsd", http.StatusForbidden},
+	} {
+		w := httptest.NewRecorder()
+		serveTargets(context.Background())(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(`{"name": "statsd"}`)))
+		if w.Code != tt.want {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.path, w.Code, tt.want)
+		}
+	}
+}
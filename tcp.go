@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// tcpStates are the TCP socket states by their number in the st column of
+// /proc/net/tcp, from include/net/tcp_states.h.
+var tcpStates = map[string]string{
+	"01": "established",
+	"02": "syn_sent",
+	"03": "syn_recv",
+	"04": "fin_wait1",
+	"05": "fin_wait2",
+	"06": "time_wait",
+	"07": "close",
+	"08": "close_wait",
+	"09": "last_ack",
+	"0A": "listen",
+	"0B": "closing",
+	"0C": "new_syn_recv",
+}
+
+// tcpTable is one poll's totals from a procfs TCP table.
+type tcpTable struct {
+	states                        map[string]int
+	rxQueued, txQueued            int64
+	retransmitTimers, retransmits int64
+}
+
+// watchTCP periodically reads the procfs TCP tables of targetPID, for daemons
+// that take UDP in and send it on over TCP.
+func watchTCP(ctx context.Context) {
+	tcpSockets := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: metricName(legacyExporterPrefix, "tcp_sockets"),
+		Help: "TCP sockets of the target, by state.",
+	}, []string{"protocol", "state"})
+	tcpRxQueued := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: metricName(legacyExporterPrefix, "tcp_rx_queue_bytes"),
+		Help: "Bytes received on TCP sockets of the target but not yet read, or the accept backlog of listeners.",
+	}, []string{"protocol"})
+	tcpTxQueued := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: metricName(legacyExporterPrefix, "tcp_tx_queue_bytes"),
+		Help: "Bytes sent on TCP sockets of the target but not yet acknowledged by the peer.",
+	}, []string{"protocol"})
+	tcpRetransmitTimers := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: metricName(legacyExporterPrefix, "tcp_retransmit_timers"),
+		Help: "TCP sockets of the target with the retransmit timer running, i.e. waiting on unacknowledged data.",
+	}, []string{"protocol"})
+	tcpRetransmits := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: metricName(legacyExporterPrefix, "tcp_unrecovered_retransmits"),
+		Help: "Retransmit timeouts not yet recovered from, summed over the TCP sockets of the target.",
+	}, []string{"protocol"})
+
+	prometheus.MustRegister(tcpSockets, tcpRxQueued, tcpTxQueued, tcpRetransmitTimers, tcpRetransmits)
+
+	for {
+		var owned map[string]bool
+		if !socketsNamespaceWide {
+			// Let the main watch loop report failures.
+			owned, _ = ownedInodes(currentTargetPID())
+		}
+		for _, protocol := range []string{"tcp", "tcp6"} {
+			table, err := readTCPTable(procPath(currentTargetPID(), "net", protocol), owned)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				slog.Warn("Unable to read table", "protocol", protocol, "err", err)
+				continue
+			}
+			for _, state := range tcpStates {
+				tcpSockets.WithLabelValues(protocol, state).Set(float64(table.states[state]))
+			}
+			tcpRxQueued.WithLabelValues(protocol).Set(float64(table.rxQueued))
+			tcpTxQueued.WithLabelValues(protocol).Set(float64(table.txQueued))
+			tcpRetransmitTimers.WithLabelValues(protocol).Set(float64(table.retransmitTimers))
+			tcpRetransmits.WithLabelValues(protocol).Set(float64(table.retransmits))
+		}
+
+		if !sleepContext(ctx, currentPollInterval()) {
+			return
+		}
+	}
+}
+
+// readTCPTable sums a procfs TCP table, counting only the sockets in owned
+// unless it's nil.
+func readTCPTable(filename string, owned map[string]bool) (tcpTable, error) {
+	table := tcpTable{states: make(map[string]int, len(tcpStates))}
+	f, err := os.Open(filename)
+	if err != nil {
+		return table, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for n := 0; s.Scan(); n++ {
+		// Skip the header lines.
+		if n < 1 {
+			continue
+		}
+		fields := strings.Fields(s.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		if owned != nil && !owned[fields[9]] {
+			continue
+		}
+		if state, ok := tcpStates[fields[3]]; ok {
+			table.states[state]++
+		}
+
+		queues := strings.Split(fields[4], ":")
+		if len(queues) != 2 {
+			return table, fmt.Errorf("unable to parse TCP queues %q", fields[4])
+		}
+		tx, err := strconv.ParseInt(queues[0], 16, 64)
+		if err != nil {
+			return table, fmt.Errorf("unable to parse TCP tx_queue: %v", err)
+		}
+		rx, err := strconv.ParseInt(queues[1], 16, 64)
+		if err != nil {
+			return table, fmt.Errorf("unable to parse TCP rx_queue: %v", err)
+		}
+		table.txQueued += tx
+		table.rxQueued += rx
+
+		// Timer 1 is the retransmit timer, tr:tm->when.
+		if strings.HasPrefix(fields[5], "01:") {
+			table.retransmitTimers++
+		}
+		retransmits, err := strconv.ParseInt(fields[6], 16, 64)
+		if err != nil {
+			return table, fmt.Errorf("unable to parse TCP retrnsmt: %v", err)
+		}
+		table.retransmits += retransmits
+	}
+	return table, s.Err()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The exporter's own telemetry, to tell a target whose tables fail to parse,
+// or that keeps being looked for, apart from one with genuinely empty queues.
+var (
+	pollDuration       *prometheus.GaugeVec
+	parseErrors        *prometheus.CounterVec
+	lastSuccessfulPoll *prometheus.GaugeVec
+	targetResolutions  *prometheus.CounterVec
+
+	// targetUp has the target labels plus whichever of process and pid
+	// they lack, upLabels, filled in from the target's sdLabels.
+	targetUp *prometheus.GaugeVec
+	upLabels []string
+)
+
+func registerTelemetryMetrics(targetLabels []string) {
+	pollDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: metricName(legacyProcfsPrefix, "scrape_duration_seconds"),
+			Help: "How long the last poll of the target's tables took.",
+		},
+		targetLabels,
+	)
+	parseErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metricName(legacyProcfsPrefix, "parse_errors_total"),
+			Help: "The number of times a table of the target couldn't be read or parsed, other than for being absent.",
+		},
+		append(append([]string{}, targetLabels...), "protocol"),
+	)
+	lastSuccessfulPoll = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: metricName(legacyProcfsPrefix, "last_successful_poll_timestamp_seconds"),
+			Help: "When a poll of the target last read at least one of its tables, as a Unix timestamp.",
+		},
+		targetLabels,
+	)
+	targetResolutions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metricName(legacyProcfsPrefix, "target_resolution_total"),
+			Help: "The number of times the target's processes were looked up, by whether any were found.",
+		},
+		append(append([]string{}, targetLabels...), "result"),
+	)
+	upLabels = nil
+	for _, name := range []string{"process", "pid"} {
+		if !containsString(targetLabels, name) {
+			upLabels = append(upLabels, name)
+		}
+	}
+	targetUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: metricName(legacyProcfsPrefix, "target_up"),
+			Help: "1 when the target's process was found and its tables could be read by the last poll, 0 otherwise.",
+		},
+		append(append([]string{}, targetLabels...), upLabels...),
+	)
+	prometheus.MustRegister(targetUp)
+	prometheus.MustRegister(pollDuration)
+	prometheus.MustRegister(parseErrors)
+	prometheus.MustRegister(lastSuccessfulPoll)
+	prometheus.MustRegister(targetResolutions)
+}
+
+// observePoll records a poll of t that started at start, and read at least
+// one table if parsed is set.
+func (t *target) observePoll(start time.Time, parsed bool) {
+	labels := append([]string{}, t.labels...)
+	t.stateMu.Lock()
+	for _, name := range upLabels {
+		labels = append(labels, t.sdLabels[name])
+	}
+	t.stateMu.Unlock()
+	// A new PID is a new series; the old one goes.
+	if t.upValues != nil && strings.Join(t.upValues, "\xff") != strings.Join(labels, "\xff") {
+		targetUp.DeleteLabelValues(t.upValues...)
+	}
+	t.upValues = labels
+	up := 0.0
+	if parsed {
+		up = 1
+	}
+	targetUp.WithLabelValues(labels...).Set(up)
+
+	now := time.Now()
+	pollDuration.WithLabelValues(t.labels...).Set(now.Sub(start).Seconds())
+	if parsed {
+		lastSuccessfulPoll.WithLabelValues(t.labels...).Set(float64(now.UnixNano()) / 1e9)
+	}
+}
+
+// observeResolution counts a lookup of t's processes.
+func (t *target) observeResolution(found bool) {
+	result := "not_found"
+	if found {
+		result = "found"
+	}
+	targetResolutions.WithLabelValues(append(append([]string{}, t.labels...), result)...).Inc()
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
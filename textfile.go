@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+// textfileName is the file written in --output.textfile-dir.
+const textfileName = "udp_procfs_exporter.prom"
+
+// runtimeMetricPrefixes are the families about the exporter's own Go runtime
+// and HTTP handler rather than the target. node_exporter exports the same
+// families itself, so its textfile collector refuses to collect them again.
+var runtimeMetricPrefixes = []string{"go_", "process_", "promhttp_"}
+
+func isRuntimeMetric(name string) bool {
+	for _, prefix := range runtimeMetricPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeTextfiles writes everything registered to dir every interval, for
+// node_exporter's textfile collector. It returns once ctx is done.
+func writeTextfiles(ctx context.Context, dir string, interval time.Duration) {
+	for sleepContext(ctx, interval) {
+		if err := writeTextfileOnce(ctx, dir); err != nil {
+			slog.Warn("Unable to write textfile", "err", err)
+		}
+	}
+}
+
+// writeTextfileOnce writes the file under a temporary name and renames it into
+// place, so node_exporter never reads half of it.
+func writeTextfileOnce(ctx context.Context, dir string) error {
+	families, err := snapshotGatherer().Gather()
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	for _, f := range families {
+		if isRuntimeMetric(f.GetName()) {
+			continue
+		}
+		if _, err := expfmt.MetricFamilyToText(&body, f); err != nil {
+			return err
+		}
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+textfileName+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(body.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, textfileName))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// unixSocketClient returns an HTTP client that sends every request to the
+// unix socket at path, whatever host the URL names. Container runtimes serve
+// their APIs this way.
+func unixSocketClient(path string) *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
+
+// getJSON fetches url with client and decodes the JSON response into v.
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	return doJSON(ctx, client, req, v)
+}
+
+// doJSON sends req with client and decodes the JSON response into v.
+func doJSON(ctx context.Context, client *http.Client, req *http.Request, v interface{}) error {
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
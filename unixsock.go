@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sockDgram is SOCK_DGRAM as it appears in unix_diag messages.
+const sockDgram = 2
+
+// unixSocket is one unix domain socket as reported by sock_diag. peer is the
+// inode of the socket it's connected to, if any.
+type unixSocket struct {
+	inode, peer      uint32
+	path             string
+	rxQueue, txQueue uint32
+}
+
+// watchUnixSockets periodically exports the unix domain datagram sockets of
+// targetPID, for clients that write metrics to a unix socket rather than UDP.
+// The kernel charges queued datagrams to the sender, so only the sending side
+// has a queue size to report: the receiver's is its peers' tx_queue.
+func watchUnixSockets(ctx context.Context) {
+	unixSockets := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: metricName(legacyExporterPrefix, "unix_dgram_sockets"),
+		Help: "Unix domain datagram sockets of the target, by the path they are bound or connected to.",
+	}, []string{"path"})
+	unixTxQueued := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: metricName(legacyExporterPrefix, "unix_dgram_tx_queue_bytes"),
+		Help: "Memory charged to the target's unix domain datagram sockets for sent datagrams the receiver hasn't read yet, by path.",
+	}, []string{"path"})
+
+	prometheus.MustRegister(unixSockets, unixTxQueued)
+	socketsGC := newGaugeGC("unix", metricName(legacyExporterPrefix, "unix_dgram_sockets"), unixSockets)
+	txQueuedGC := newGaugeGC("unix", metricName(legacyExporterPrefix, "unix_dgram_tx_queue_bytes"), unixTxQueued)
+
+	for {
+		var owned map[string]bool
+		if !socketsNamespaceWide {
+			// Let the main watch loop report failures.
+			owned, _ = ownedInodes(currentTargetPID())
+		}
+		sockets, err := listUnixSockets(procPath(currentTargetPID(), "ns", "net"), sockDgram)
+		if err != nil {
+			slog.Warn("Unable to collect unix socket stats", "err", err)
+		}
+
+		// Clients rarely bind a name of their own, so they're labelled
+		// with the name of the socket they're connected to.
+		names := make(map[uint32]string, len(sockets))
+		for _, s := range sockets {
+			names[s.inode] = s.path
+		}
+		count := make(map[string]int)
+		txQueued := make(map[string]uint64)
+		for _, s := range sockets {
+			if owned != nil && !owned[strconv.FormatUint(uint64(s.inode), 10)] {
+				continue
+			}
+			path := s.path
+			if path == "" && s.peer != 0 {
+				path = names[s.peer]
+			}
+			count[path]++
+			txQueued[path] += uint64(s.txQueue)
+		}
+		for path, n := range count {
+			if socketsGC.admit(path) {
+				unixSockets.WithLabelValues(path).Set(float64(n))
+			}
+			if txQueuedGC.admit(path) {
+				unixTxQueued.WithLabelValues(path).Set(float64(txQueued[path]))
+			}
+		}
+		socketsGC.sweep()
+		txQueuedGC.sweep()
+
+		if !sleepContext(ctx, currentPollInterval()) {
+			return
+		}
+	}
+}
+
+// unixSocketName formats the sun_path of a unix socket the way ss does, with
+// abstract names (those starting with a NUL) prefixed by @.
+func unixSocketName(b []byte) string {
+	if len(b) > 0 && b[0] == 0 {
+		return "@" + string(bytes.TrimRight(b[1:], "\x00"))
+	}
+	return string(bytes.TrimRight(b, "\x00"))
+}
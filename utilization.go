@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strconv"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// udpBufferUtilization is only registered with --backend=netlink, the only
+// backend to read the buffer sizes, and is nil otherwise.
+var udpBufferUtilization *prometheus.GaugeVec
+
+func registerUtilizationMetric(labels []string) {
+	udpBufferUtilization = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: metricName(legacyExporterPrefix, "buffer_utilization_ratio"),
+			Help: "The bytes queued in the linux buffers over the sum of their sizes, from 0 to about 1. Only exported with --backend=netlink.",
+		},
+		labels,
+	)
+	prometheus.MustRegister(udpBufferUtilization)
+}
+
+// diagRcvbufs returns the receive buffer size of every socket sock_diag lists
+// for protocol in namespaces, by inode. Only the udp and udplite tables can be
+// listed; the map is empty for the others.
+func diagRcvbufs(namespaces []string, protocol string) (map[string]uint32, error) {
+	rcvbufs := make(map[string]uint32)
+	table, known := diagTables[protocol]
+	if !known {
+		return rcvbufs, nil
+	}
+	for _, nsPath := range namespaces {
+		sockets, err := listDiagSockets(nsPath, table.family, table.protocol, true)
+		if errno, isErrno := underlyingErrno(err); isErrno && errno == syscall.ENOENT {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range sockets {
+			rcvbufs[strconv.FormatUint(uint64(s.inode), 10)] = s.skmem[skmemRcvbuf]
+		}
+	}
+	return rcvbufs, nil
+}
+
+// recordUtilization exports how full t's protocol sockets are, as the bytes
+// queued on them over the sum of their receive buffers, rcvbuf. Only the
+// netlink backend reads the buffer sizes, with the sockets themselves, so
+// other backends and targets don't export it.
+func (t *target) recordUtilization(protocol string, queued, rcvbuf int) {
+	ratio := 0.0
+	if rcvbuf > 0 {
+		ratio = float64(queued) / float64(rcvbuf)
+	}
+	labels := append(append([]string{}, t.labels...), protocol)
+	udpBufferUtilization.WithLabelValues(labels...).Set(ratio)
+	if sampleHistogram == "ratio" && rcvbuf > 0 {
+		udpBufferSampledHistogram.WithLabelValues(labels...).Observe(ratio)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// version, revision, branch and buildDate describe the build, set with e.g.
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.revision=$(git rev-parse HEAD)"
+//
+// as script/build does. Left unset, version and revision come from what the
+// Go toolchain stamps into binaries built in a checkout, and buildDate from
+// the time of that commit.
+var (
+	version   string
+	revision  string
+	branch    string
+	buildDate string
+)
+
+func init() {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	if version == "" && info.Main.Version != "" {
+		version = info.Main.Version
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if revision == "" {
+				revision = s.Value
+			}
+		case "vcs.time":
+			if buildDate == "" {
+				buildDate = s.Value
+			}
+		}
+	}
+}
+
+// exporterVersion is version, or "unknown" when nothing set it.
+func exporterVersion() string {
+	if version == "" {
+		return "unknown"
+	}
+	return version
+}
+
+// printVersion prints the build for --version.
+func printVersion(w io.Writer) {
+	fmt.Fprintf(w, "udp-procfs-exporter, version %s (branch: %s, revision: %s)\n", exporterVersion(), branch, revision)
+	fmt.Fprintf(w, "  build date:       %s\n", buildDate)
+	fmt.Fprintf(w, "  go version:       %s\n", runtime.Version())
+	fmt.Fprintf(w, "  platform:         %s/%s\n", runtime.GOOS, runtime.GOARCH)
+}
+
+// registerBuildInfo exports the build as the always 1
+// udp_procfs_exporter_build_info, for joining against or counting a fleet's
+// builds by.
+func registerBuildInfo() {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: metricName(legacyProcfsPrefix, "exporter_build_info"),
+			Help: "A metric with a constant '1' value labeled by the version, revision, branch, build date and Go version the exporter was built from.",
+			ConstLabels: prometheus.Labels{
+				"version":   exporterVersion(),
+				"revision":  revision,
+				"branch":    branch,
+				"builddate": buildDate,
+				"goversion": runtime.Version(),
+			},
+		},
+		func() float64 { return 1 },
+	))
+}
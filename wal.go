@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// walDir is set by --push.wal-dir, under which the push sinks keep the
+	// requests they couldn't send. Empty disables the WALs.
+	walDir string
+	// walMaxBytes is set by --push.wal-max-bytes, the most each sink's WAL
+	// keeps before dropping its oldest requests.
+	walMaxBytes int64 = 16 << 20
+)
+
+// pushWAL keeps the request bodies a push sink couldn't send as files in dir,
+// and sends them again, oldest first, before the sink's next request. The
+// bodies carry their samples' timestamps, so after an outage the receiver
+// gets the drops that happened during it rather than a gap. Its methods are
+// only called from the sink's goroutine.
+type pushWAL struct {
+	name string
+	dir  string
+}
+
+// newPushWAL returns the WAL of the sink name, in a directory of its own
+// under walDir, or nil without --push.wal-dir.
+func newPushWAL(name string) (*pushWAL, error) {
+	if walDir == "" {
+		return nil, nil
+	}
+	dir := filepath.Join(walDir, name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &pushWAL{name: name, dir: dir}, nil
+}
+
+// send sends what w kept and then body with send, keeping body for the next
+// time if that fails in a way that sending it again could fix. A nil w only
+// sends body.
+func (w *pushWAL) send(body []byte, send func([]byte) error) error {
+	if w == nil {
+		return send(body)
+	}
+	err := w.replay(send)
+	if err == nil {
+		err = send(body)
+	}
+	if err != nil && retryable(err) {
+		w.keep(body)
+	}
+	return err
+}
+
+// replay sends the kept requests, oldest first, deleting each once it's been
+// sent or rejected for good. It stops at the first that could be sent again.
+func (w *pushWAL) replay(send func([]byte) error) error {
+	files, err := w.files()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		path := filepath.Join(w.dir, f.Name())
+		body, err := ioutil.ReadFile(path)
+		if err == nil {
+			err = send(body)
+		}
+		if err != nil && retryable(err) {
+			return err
+		}
+		if err != nil {
+			slog.Warn("Dropping a buffered push the sink rejected", "sink", w.name, "err", err)
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	if len(files) > 0 {
+		slog.Info("Sent the pushes buffered during an outage", "sink", w.name, "requests", len(files))
+	}
+	return nil
+}
+
+// keep writes body to a new file, named after when it was kept so that the
+// files sort oldest first, then drops the oldest requests over walMaxBytes.
+func (w *pushWAL) keep(body []byte) {
+	path := filepath.Join(w.dir, fmt.Sprintf("%020d.req", time.Now().UnixNano()))
+	// Writing to a temporary file first keeps a crash from leaving half a
+	// request behind.
+	if err := ioutil.WriteFile(path+".tmp", body, 0600); err != nil {
+		slog.Warn("Unable to buffer a push", "sink", w.name, "err", err)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		slog.Warn("Unable to buffer a push", "sink", w.name, "err", err)
+		return
+	}
+
+	files, err := w.files()
+	if err != nil {
+		slog.Warn("Unable to list the buffered pushes", "sink", w.name, "err", err)
+		return
+	}
+	var size int64
+	for i := len(files) - 1; i >= 0; i-- {
+		size += files[i].Size()
+		if size <= walMaxBytes {
+			continue
+		}
+		slog.Warn("Push buffer is at --push.wal-max-bytes, dropped the oldest pushes", "sink", w.name, "dropped", i+1)
+		for _, f := range files[:i+1] {
+			os.Remove(filepath.Join(w.dir, f.Name()))
+		}
+		return
+	}
+}
+
+// files lists the requests w kept, oldest first.
+func (w *pushWAL) files() ([]os.FileInfo, error) {
+	entries, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []os.FileInfo
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".req") {
+			files = append(files, e)
+		}
+	}
+	return files, nil
+}
+
+// statusError is a push a sink answered with a status other than 2xx.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+// responseError returns the error of a sink's non-2xx response, with what
+// the sink said about it.
+func responseError(resp *http.Response) error {
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(resp.Body)
+	return &statusError{code: resp.StatusCode, msg: fmt.Sprintf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))}
+}
+
+// retryable reports whether a push that failed with err could succeed if
+// sent again: it didn't reach the sink, or the sink was overloaded or
+// failing, rather than rejecting the request itself.
+func retryable(err error) bool {
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.code == http.StatusTooManyRequests || status.code/100 == 5
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestPushWAL(t *testing.T) {
+	defer func(dir string) { walDir = dir }(walDir)
+	walDir = t.TempDir()
+	wal, err := newPushWAL("remote-write")
+	if err != nil {
+		t.Fatalf("newPushWAL() error = %v", err)
+	}
+
+	var sent []string
+	var down error
+	send := func(body []byte) error {
+		if down != nil {
+			return down
+		}
+		if string(body) == "c" {
+			return &statusError{code: 400, msg: "400 Bad Request"}
+		}
+		sent = append(sent, string(body))
+		return nil
+	}
+
+	// Requests the sink couldn't get are kept.
+	down = errors.New("connection refused")
+	wal.send([]byte("a"), send)
+	down = &statusError{code: 503, msg: "503 Service Unavailable"}
+	wal.send([]byte("b"), send)
+	if files, _ := ioutil.ReadDir(wal.dir); len(files) != 2 {
+		t.Fatalf("the WAL has %d files, want 2", len(files))
+	}
+
+	// They're sent first once it's back, and the one it rejects isn't kept.
+	down = nil
+	if err := wal.send([]byte("c"), send); err == nil {
+		t.Fatal("send() succeeded, want the sink's 400")
+	}
+	if err := wal.send([]byte("d"), send); err != nil {
+		t.Fatalf("send() error = %v", err)
+	}
+	if want := []string{"a", "b", "d"}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent %q, want %q", sent, want)
+	}
+	if files, _ := ioutil.ReadDir(wal.dir); len(files) != 0 {
+		t.Errorf("the WAL has %d files left, want 0", len(files))
+	}
+}
+
+func TestPushWALDropsOldest(t *testing.T) {
+	defer func(dir string, max int64) { walDir, walMaxBytes = dir, max }(walDir, walMaxBytes)
+	walDir = t.TempDir()
+	walMaxBytes = 4
+	wal, err := newPushWAL("influx")
+	if err != nil {
+		t.Fatalf("newPushWAL() error = %v", err)
+	}
+
+	fail := func([]byte) error { return errors.New("timeout") }
+	for _, body := range []string{"aa", "bb", "cc"} {
+		wal.send([]byte(body), fail)
+	}
+
+	var sent []string
+	wal.send([]byte("dd"), func(body []byte) error {
+		sent = append(sent, string(body))
+		return nil
+	})
+	if want := []string{"bb", "cc", "dd"}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent %q, want %q", sent, want)
+	}
+}